@@ -5,6 +5,7 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
 	"encoding/json"
 	"encoding/xml"
@@ -13,6 +14,7 @@ import (
 	"html"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -24,7 +26,7 @@ import (
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -32,7 +34,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/joe-black-jb/compass-api/internal"
 	"github.com/joe-black-jb/compass-api/internal/api"
-	"github.com/joho/godotenv"
+	"github.com/joe-black-jb/compass-api/internal/config"
 )
 
 var EDINETAPIKey string
@@ -50,7 +52,8 @@ var emptyStrConvErr = `strconv.Atoi: parsing "": invalid syntax`
 var invalidSummaryJSONFile = "invalid-summary.json"
 var invalidSummaries []internal.InvalidSummary
 var apiTimes int
-var registerSingleReport string
+var registerSingleReport bool
+var getXBRLFromS3 bool
 
 /* NOTE
 ・連結キャッシュフロー計算書:  0105050
@@ -80,55 +83,48 @@ var registerSingleReport string
 */
 
 func init() {
-	env := os.Getenv("ENV")
-	fmt.Println("環境: ", env)
-
-	if env == "local" {
-		err := godotenv.Load()
-		if err != nil {
-			log.Fatal("Error loading .env file err: ", err)
-			return
-		}
+	conf, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
-	EDINETAPIKey = os.Getenv("EDINET_API_KEY")
-	if EDINETAPIKey == "" {
-		fmt.Println("EDINET API key not found")
-		return
+	// EDINET の API キー・保存先が不足している場合は実行しない
+	err = conf.ValidateBatch()
+	if err != nil {
+		log.Fatal(err)
 	}
-	EDINETSubAPIKey = os.Getenv("EDINET_SUB_API_KEY")
-	if EDINETSubAPIKey == "" {
-		fmt.Println("EDINET Sub API key not found")
-		return
+	err = api.Init(conf)
+	if err != nil {
+		log.Fatal(err)
 	}
+	slog.Info("環境", "env", conf.Env)
+
+	EDINETAPIKey = conf.EDINETAPIKey
+	EDINETSubAPIKey = conf.EDINETSubAPIKey
 
-	cfg, cfgErr := config.LoadDefaultConfig(context.TODO())
+	cfg, cfgErr := awsconfig.LoadDefaultConfig(context.TODO())
 	if cfgErr != nil {
-		fmt.Println("Load default config error: %v", cfgErr)
+		slog.Error("load default config failed", "error", cfgErr)
 		return
 	}
-	region := os.Getenv("REGION")
-	sdkConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	sdkConfig, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithRegion(conf.Region))
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("load default config failed", "error", err)
 		return
 	}
 	s3Client = s3.NewFromConfig(sdkConfig)
 	dynamoClient = dynamodb.NewFromConfig(cfg)
-	tableName = os.Getenv("DYNAMO_TABLE_NAME")
-	bucketName = os.Getenv("BUCKET_NAME")
-	EDINETBucketName = os.Getenv("EDINET_BUCKET_NAME")
-  registerSingleReport = os.Getenv("REGISTER_SINGLE_REPORT")
+	tableName = conf.CompaniesTableName
+	bucketName = conf.BucketName
+	EDINETBucketName = conf.EDINETBucketName
+	registerSingleReport = conf.RegisterSingleReport
+	getXBRLFromS3 = conf.GetXBRLFromS3
 }
 
 func main() {
 	start := time.Now()
 
-	if tableName == "" {
-		log.Fatal("テーブル名が設定されていません")
-	}
-
-  if registerSingleReport == "true" {
-    fmt.Println("single モードで登録します⭐️")
+  if registerSingleReport {
+    slog.Info("single モードで登録します")
     // 楽天グループ
     singleEDINETCode := "E05080"
     singleDocID := "S100NQTZ"
@@ -150,11 +146,11 @@ func main() {
     RegisterReport(dynamoClient, singleEDINETCode, singleDocID, singleDateKey, companyName, periodStart, periodEnd, &fundamental, &singleWg)
   } else {
     reports, err := GetReports()
-    fmt.Println("len(reports): ", len(reports))
     if err != nil {
-      fmt.Println(err)
+      slog.Error("GetReports error", "error", err)
       return
     }
+    slog.Info("対象のレポート", "count", len(reports))
 
     var wg sync.WaitGroup
     // reports: 1ヶ月分指定したら1ヶ月分のレポートが入っている
@@ -216,9 +212,13 @@ func main() {
 	// 並列で処理する場合
 	// wg.Wait()
 
-	fmt.Println("All processes done ⭐️")
-	fmt.Println("APIを叩いた回数(概算): ", apiTimes)
-	fmt.Println("所要時間: ", time.Since(start))
+	// Webhook の再送を待つ (WEBHOOK_RETRY_TIMEOUT を過ぎたものは失敗として記録する)
+	err := api.WaitWebhookRetries(context.TODO())
+	if err != nil {
+		slog.Error("Webhook の再送を打ち切りました", "error", err)
+	}
+
+	slog.Info("All processes done", "apiTimes", apiTimes, "elapsed", time.Since(start).String())
 }
 
 func unzip(source, destination string) (string, error) {
@@ -278,7 +278,7 @@ func unzip(source, destination string) (string, error) {
 	// zipファイルを削除
 	err = os.RemoveAll(source)
 	if err != nil {
-		fmt.Println("zip ファイル削除エラー: ", err)
+		slog.Error("zip ファイル削除エラー", "error", err)
 	}
 	return XBRLFilepath, nil
 }
@@ -289,7 +289,7 @@ EDINET 書類一覧取得 API を使用し有価証券報告書または訂正
 func GetReports() ([]internal.Result, error) {
 	loc, err := time.LoadLocation("Asia/Tokyo")
 	if err != nil {
-		fmt.Println("load location error")
+		slog.Error("load location error")
 		return nil, err
 	}
 
@@ -309,7 +309,7 @@ func GetReports() ([]internal.Result, error) {
 	endDate := time.Date(2024, time.October, 31, 1, 0, 0, 0, loc)
 	// now := time.Now()
 	for date.Before(endDate) || date.Equal(endDate) {
-		fmt.Println(fmt.Sprintf("%s の処理を開始します⭐️", date.Format("2006-01-02")))
+		slog.Info("処理を開始します", "date", date.Format("2006-01-02"))
 
 		/// テスト ///
 		var statement internal.Report
@@ -337,7 +337,7 @@ func GetReports() ([]internal.Result, error) {
     url := fmt.Sprintf("https://api.edinet-fsa.go.jp/api/v2/documents.json?date=%s&&Subscription-Key=%s&type=2", dateStr, EDINETSubAPIKey)
     resp, err := http.Get(url)
     if err != nil {
-      fmt.Println("http get error : ", err)
+      slog.Error("http get error", "date", dateStr, "error", err)
       return nil, err
     }
     defer resp.Body.Close()
@@ -361,6 +361,14 @@ func GetReports() ([]internal.Result, error) {
       if isSecReport || isAmendReport {
         s.DateKey = dateKey
         results = append(results, s)
+
+        // 購読しているユーザーに通知する
+        alertCount, err := api.RecordFilingAlerts(context.TODO(), s)
+        if err != nil {
+          slog.Error("通知登録エラー", "docID", s.DocId, "companyName", s.FilerName, "error", err)
+        } else if alertCount > 0 {
+          slog.Info("通知しました", "docID", s.DocId, "companyName", s.FilerName, "alerts", alertCount)
+        }
       }
     }
     //////////////////////////////////////
@@ -373,7 +381,7 @@ func GetReports() ([]internal.Result, error) {
 }
 
 func RegisterReport(dynamoClient *dynamodb.Client, EDINETCode string, docID string, dateKey string, companyName string, periodStart string, periodEnd string, fundamental *internal.Fundamental, wg *sync.WaitGroup) {
-	fmt.Printf("===== ⭐️「%s」⭐️ =====\n", companyName)
+	slog.Info("レポートの登録を開始します", "docID", docID, "edinetCode", EDINETCode, "companyName", companyName, "dateKey", dateKey)
   // 並列で処理する場合
 	// defer wg.Done()
 
@@ -391,23 +399,23 @@ func RegisterReport(dynamoClient *dynamodb.Client, EDINETCode string, docID stri
 	dateDocKey := fmt.Sprintf("%s/%s", dateKey, docID)
 	// 末尾にスラッシュを追加
 	dateDocKeyWithSlash := dateDocKey + "/"
-	fmt.Println("dateKeyもあるkeyWithSlash: ", dateDocKeyWithSlash)
 	// 同じ dateKey のディレクトリがある場合、RegisterReport() を実施しないのでチェックしても意味がない❗️
 	isDocRegistered, err := api.CheckExistsS3Key(s3Client, EDINETBucketName, dateDocKeyWithSlash)
 	if err != nil {
-		fmt.Println("CheckExistsS3Key error: ", err)
+		slog.Error("CheckExistsS3Key error", "docID", docID, "key", dateDocKeyWithSlash, "error", err)
 		return
 	}
-	fmt.Printf("%s の %s は登録済みですか❓: %v\n", EDINETBucketName, dateDocKeyWithSlash, isDocRegistered)
+	slog.Debug("登録済みか確認しました", "docID", docID, "bucket", EDINETBucketName, "key", dateDocKeyWithSlash, "registered", isDocRegistered)
 
   // XBRLファイルの中身
   var body []byte
   var parentPath string
+  // S3 に登録する (登録済みの) XBRL ファイルのキー
+  var xbrlKey string
   if isDocRegistered {
-    getXBRLFromS3 := os.Getenv("GET_XBRL_FROM_S3")
     var xbrlFileName string
-    if getXBRLFromS3 == "true" {
-      if registerSingleReport == "true" {
+    if getXBRLFromS3 {
+      if registerSingleReport {
         // S3 に登録済みの XBRL ファイルを取得し、中身を body に格納
         xbrlFileName = "jpcrp030000-asr-001_E05080-000_2021-12-31_01_2022-03-30.xbrl"
       } else {
@@ -416,18 +424,23 @@ func RegisterReport(dynamoClient *dynamodb.Client, EDINETCode string, docID stri
 
         listOutput := api.ListS3Objects(s3Client, EDINETBucketName, dateDocIDKey)
         // fmt.Printf("%s/%s の List 結果 ⭐️: %v\n", EDINETBucketName, dateDocIDKey, listOutput)
-        if len(listOutput.Contents) > 0 {
-          firstFile := listOutput.Contents[0]
-          fmt.Println("先頭のファイル名 ⭐️: ", *firstFile.Key)
+        // 同じディレクトリに PDF もあるため、拡張子で XBRL ファイルを探す
+        for _, content := range listOutput.Contents {
+          if !strings.HasSuffix(*content.Key, ".xbrl") {
+            continue
+          }
+          slog.Debug("XBRL ファイル", "docID", docID, "key", *content.Key)
           // S3 に登録済みのxbrlファイル
-          splitBySlash := strings.Split(*firstFile.Key, "/")
+          splitBySlash := strings.Split(*content.Key, "/")
           if len(splitBySlash) >= 3 {
             xbrlFileName = splitBySlash[len(splitBySlash) - 1]
           }
+          break
         }
       }
       key := fmt.Sprintf("%s/%s/%s", dateKey, docID, xbrlFileName)
-      fmt.Println("S3 から XBRL ファイルを取得します⭐️")
+      xbrlKey = key
+      slog.Info("S3 から XBRL ファイルを取得します", "docID", docID, "key", key)
       output, err := api.GetS3Object(s3Client, EDINETBucketName, key)
       if err != nil {
         log.Fatal("S3からのXBRLファイル取得エラー: ", err)
@@ -441,7 +454,7 @@ func RegisterReport(dynamoClient *dynamodb.Client, EDINETCode string, docID stri
     }
   } else {
     //////// テスト ////////
-    fmt.Printf("「%s」のレポート (%s) を API から取得します🎾\n", companyName, docID)
+    slog.Info("レポートを API から取得します", "docID", docID, "companyName", companyName)
     apiTimes += 1
     url := fmt.Sprintf("https://api.edinet-fsa.go.jp/api/v2/documents/%s?type=1&Subscription-Key=%s", docID, EDINETSubAPIKey)
     resp, err := client.Get(url)
@@ -506,14 +519,30 @@ func RegisterReport(dynamoClient *dynamodb.Client, EDINETCode string, docID stri
   }
 	// TODO: S3 に xbrl ファイルを送信
 	// xbrlKey = 20060102/{DocID}/~~~~.xbrl
-	splitBySlash := strings.Split(parentPath, "/")
-	xbrlFile := splitBySlash[len(splitBySlash)-1]
-	// fmt.Println("parentPath: ", parentPath)
-	// fmt.Println("xbrlファイル名: ", xbrlFile)
-	xbrlKey := fmt.Sprintf("%s/%s/%s", dateKey, docID, xbrlFile)
-	fmt.Println("S3 に登録する xbrlファイルパス: ", xbrlKey)
-	// S3 送信処理
-	PutXBRLtoS3(docID, dateKey, xbrlKey, body)
+	if parentPath != "" {
+		splitBySlash := strings.Split(parentPath, "/")
+		xbrlFile := splitBySlash[len(splitBySlash)-1]
+		// fmt.Println("parentPath: ", parentPath)
+		// fmt.Println("xbrlファイル名: ", xbrlFile)
+		xbrlKey = fmt.Sprintf("%s/%s/%s", dateKey, docID, xbrlFile)
+		slog.Debug("S3 に登録する XBRL ファイル", "docID", docID, "key", xbrlKey)
+		// S3 送信処理 (送信できなかった XBRL ファイルは原本の一覧に含めない)
+		err = PutXBRLtoS3(docID, dateKey, xbrlKey, body)
+		if err != nil {
+			xbrlKey = ""
+		}
+	}
+	// 登録済みの書類 (GET_XBRL_FROM_S3 を使わない場合) は S3 に保存済みの XBRL ファイルを探す
+	if xbrlKey == "" && isDocRegistered {
+		xbrlKey = FindRegisteredXBRLKey(docID, dateKey)
+	}
+
+	// 原本の PDF を保存し、書類管理番号から原本を探せるようにする (/filings/{docID}/xbrl・pdf)
+	// 登録済みの書類も、一覧の登録前に保存されたものがあるため毎回確認する
+	pdfKey := PutPDFtoS3(client, docID, dateKey)
+	if xbrlKey != "" || pdfKey != "" {
+		PutFilingIndex(docID, dateKey, xbrlKey, pdfKey)
+	}
 
 	var xbrl internal.XBRL
 	err = xml.Unmarshal(body, &xbrl)
@@ -719,8 +748,21 @@ func RegisterReport(dynamoClient *dynamodb.Client, EDINETCode string, docID stri
   deleteFailedJsonItem(docID, dateKey, companyName)
   // レポートの登録処理完了後、invalid-summary.json から該当のデータを削除する
 
-	fmt.Printf("「%s」のレポート(%s)の登録処理完了⭐️\n", companyName, docID)
+	slog.Info("レポートの登録処理完了", "docID", docID, "edinetCode", EDINETCode, "companyName", companyName)
 	////////////////////////////////////////
+
+	// 後続のシステムに登録完了を通知する
+	err = api.PublishEvent(context.TODO(), api.EventFilingRegistered, map[string]string{
+		"edinetCode":  EDINETCode,
+		"docId":       docID,
+		"dateKey":     dateKey,
+		"companyName": companyName,
+		"periodStart": periodStart,
+		"periodEnd":   periodEnd,
+	})
+	if err != nil {
+		slog.Error("Webhook 送信エラー", "docID", docID, "edinetCode", EDINETCode, "error", err)
+	}
 }
 
 func UpdateSummary(doc *goquery.Document, docID string, dateKey string, summary *internal.Summary, fundamental *internal.Fundamental) {
@@ -971,7 +1013,7 @@ func ValidatePLSummary(plSummary internal.PLSummary) bool {
 func RegisterCompany(dynamoClient *dynamodb.Client, EDINETCode string, companyName string, isSummaryValid bool, isPLSummaryValid bool) {
 	foundItems, err := api.QueryByName(dynamoClient, tableName, companyName, EDINETCode)
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("QueryByName error", "edinetCode", EDINETCode, "companyName", companyName, "error", err)
 		return
 	}
 
@@ -979,7 +1021,7 @@ func RegisterCompany(dynamoClient *dynamodb.Client, EDINETCode string, companyNa
 		var company internal.Company
 		id, uuidErr := uuid.NewUUID()
 		if uuidErr != nil {
-			fmt.Println("uuid create error")
+			slog.Error("uuid create error", "edinetCode", EDINETCode, "error", uuidErr)
 			return
 		}
 		company.ID = id.String()
@@ -994,7 +1036,7 @@ func RegisterCompany(dynamoClient *dynamodb.Client, EDINETCode string, companyNa
 
 		item, err := attributevalue.MarshalMap(company)
 		if err != nil {
-			fmt.Println("MarshalMap err: ", err)
+			slog.Error("MarshalMap err", "edinetCode", EDINETCode, "error", err)
 			return
 		}
 
@@ -1004,11 +1046,10 @@ func RegisterCompany(dynamoClient *dynamodb.Client, EDINETCode string, companyNa
 		}
 		_, err = dynamoClient.PutItem(context.TODO(), input)
 		if err != nil {
-			fmt.Println("dynamoClient.PutItem err: ", err)
+			slog.Error("dynamoClient.PutItem err", "edinetCode", EDINETCode, "error", err)
 			return
 		}
-		doneMsg := fmt.Sprintf("「%s」をDBに新規登録しました ⭕️", companyName)
-		fmt.Println(doneMsg)
+		slog.Info("DBに新規登録しました", "edinetCode", EDINETCode, "companyName", companyName, "companyId", company.ID)
 	} else {
 		foundItem := foundItems[0]
 		if foundItem != nil {
@@ -1018,7 +1059,7 @@ func RegisterCompany(dynamoClient *dynamodb.Client, EDINETCode string, companyNa
 			// company型に UnmarshalMap
 			err = attributevalue.UnmarshalMap(foundItem, &company)
 			if err != nil {
-				fmt.Println("attributevalue.UnmarshalMap err: ", err)
+				slog.Error("attributevalue.UnmarshalMap err", "edinetCode", EDINETCode, "error", err)
 				return
 			}
 
@@ -1112,9 +1153,18 @@ func RegisterFundamental(dynamoClient *dynamodb.Client, docID string, dateKey st
 			return
 		}
 		///// ログを出さない場合はコメントアウト /////
-		uploadDoneMsg := fmt.Sprintf("「%s」のファンダメンタルズJSONを登録しました ⭕️ (ファイル名: %s)", fundamental.CompanyName, key)
-		fmt.Println(uploadDoneMsg)
+		slog.Info("ファンダメンタルズJSONを登録しました", "docID", docID, "edinetCode", EDINETCode, "companyName", fundamental.CompanyName, "key", key)
 		////////////////////////////////////////
+
+		err = api.PublishEvent(context.TODO(), api.EventFundamentalsUpdated, map[string]interface{}{
+			"edinetCode":  EDINETCode,
+			"docId":       docID,
+			"key":         key,
+			"fundamental": fundamental,
+		})
+		if err != nil {
+			slog.Error("Webhook 送信エラー", "docID", docID, "edinetCode", EDINETCode, "error", err)
+		}
 	}
 }
 
@@ -1189,6 +1239,8 @@ func CreateHTML(docID string, dateKey string, fileType, consolidatedBSMatches, s
 
 	// HTMLデータを加工
 	unescapedStr = FormatHtmlTable(unescapedStr)
+	// スクリプト・イベントハンドラー・外部の参照などを除く
+	unescapedStr = api.SanitizeReportHTML(unescapedStr)
 
 	// html ファイルとして書き出す
 	HTMLDirName := "HTML"
@@ -1212,7 +1264,6 @@ func CreateHTML(docID string, dateKey string, fileType, consolidatedBSMatches, s
 		if err != nil {
 			errMsg = "HTML ローカルディレクトリ作成エラー: "
 			registerFailedJson(docID, dateKey, errMsg+err.Error())
-			fmt.Println("Error creating directory:", err)
 			return nil, err
 		}
 	}
@@ -1288,6 +1339,8 @@ func CreateCFHTML(docID string, dateKey string, cfFileNamePattern, body string,
 	// 特定のエンティティをさらに手動でデコード
 	unescapedMatch = strings.ReplaceAll(unescapedMatch, "&apos;", "'")
 	unescapedMatch = FormatHtmlTable(unescapedMatch)
+	// スクリプト・イベントハンドラー・外部の参照などを除く
+	unescapedMatch = api.SanitizeReportHTML(unescapedMatch)
 
 	HTMLDirName := "HTML"
 	cfHTMLFileName := fmt.Sprintf("%s.html", cfFileNamePattern)
@@ -1461,20 +1514,12 @@ func PrintValidatedSummaryMsg(companyName string, fileName string, summary inter
 		summaryType = "CF計算書"
 	}
 
-	jsonBody, _ := json.MarshalIndent(summary, "", "  ")
-
-	var detailStr string
-	var validStr string
-	switch isValid {
-	case true:
-		validStr = "有効です⭕️"
-	case false:
-		validStr = "無効です❌"
-		detailStr = fmt.Sprintf("詳細:\n%v\n", string(jsonBody))
+	attrs := []any{"companyName", companyName, "summaryType", summaryType, "fileName", fileName, "valid", isValid}
+	if !isValid {
+		// 無効な場合は内容を出力する
+		attrs = append(attrs, "summary", summary)
 	}
-
-	msg := fmt.Sprintf("「%s」の%sサマリーJSON (%s) は%s %s", companyName, summaryType, fileName, validStr, detailStr)
-	println(msg)
+	slog.Info("サマリーJSONを検証しました", attrs...)
 }
 
 // TODO: 汎用ファイル送信処理
@@ -1552,17 +1597,7 @@ func PutFileToS3(docID string, dateKey string, EDINETCode string, companyName st
 			}
 
 			///// ログを出さない場合はコメントアウト /////
-			var reportTypeStr string
-			switch reportType {
-			case "BS":
-				reportTypeStr = "貸借対照表"
-			case "PL":
-				reportTypeStr = "損益計算書"
-			case "CF":
-				reportTypeStr = "CF計算書"
-			}
-			uploadDoneMsg := fmt.Sprintf("「%s」の%s%sを登録しました ⭕️ (ファイル名: %s)", companyName, reportTypeStr, extension, key)
-			fmt.Println(uploadDoneMsg)
+			slog.Info("ファイルを登録しました", "docID", docID, "edinetCode", EDINETCode, "companyName", companyName, "reportType", reportType, "extension", extension, "key", key)
 			////////////////////////////////////////
 		}
 	}
@@ -1646,7 +1681,7 @@ func FormatHtmlTable(htmlStr string) string {
 エラーが出た場合に docID といつ登録されたレポートなのかをjsonファイルに記録する
 */
 func registerFailedJson(docID string, dateKey string, errMsg string) {
-	fmt.Println(errMsg)
+	slog.Error("レポートの登録エラー", "docID", docID, "dateKey", dateKey, "error", errMsg)
 	// 取得から更新までをロック
 	mu.Lock()
   // 更新まで終わったらロック解除
@@ -1659,7 +1694,7 @@ func registerFailedJson(docID string, dateKey string, errMsg string) {
 		// ファイルがない場合は作成する
 		_, err := os.Create(failedJSONFile)
 		if err != nil {
-			fmt.Println("failed json create error: ", err)
+			slog.Error("failed json create error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 	}
@@ -1668,12 +1703,12 @@ func registerFailedJson(docID string, dateKey string, errMsg string) {
 	// 中身を取得
 	body, err := io.ReadAll(openFile)
 	if err != nil {
-		fmt.Println("failed json read error: ", err)
+		slog.Error("failed json read error", "docID", docID, "error", err)
 		// registerFailedJson(docID, dateKey, err.Error())
 	}
 	err = json.Unmarshal(body, &failedReports)
 	if err != nil {
-		fmt.Println("failed json unmarshal error: ", err)
+		slog.Error("failed json unmarshal error", "docID", docID, "error", err)
 		// registerFailedJson(docID, dateKey, err.Error())
 	}
 	// 配列の中に自分がいるか確認
@@ -1693,13 +1728,13 @@ func registerFailedJson(docID string, dateKey string, errMsg string) {
 		failedReports = append(failedReports, failedReport)
 		jsonBody, err := json.MarshalIndent(failedReports, "", "  ")
 		if err != nil {
-			fmt.Println("json MarshalIndent when trying to write failed json error: ", err)
+			slog.Error("failed json marshal error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 		// json を書き出す
 		err = os.WriteFile(failedJSONFile, jsonBody, 0666)
 		if err != nil {
-			fmt.Println("failed json write error: ", err)
+			slog.Error("failed json write error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 	}
@@ -1716,7 +1751,7 @@ func deleteFailedJsonItem(docID string, dateKey string, companyName string) {
 		// ファイルがない場合は作成する
 		_, err := os.Create(failedJSONFile)
 		if err != nil {
-			fmt.Println("failed json create error: ", err)
+			slog.Error("failed json create error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 	}
@@ -1725,12 +1760,12 @@ func deleteFailedJsonItem(docID string, dateKey string, companyName string) {
 	// 中身を取得
 	body, err := io.ReadAll(openFile)
 	if err != nil {
-		fmt.Println("failed json read error: ", err)
+		slog.Error("failed json read error", "docID", docID, "error", err)
 		// registerFailedJson(docID, dateKey, err.Error())
 	}
 	err = json.Unmarshal(body, &failedReports)
 	if err != nil {
-		fmt.Println("failed json unmarshal error: ", err)
+		slog.Error("failed json unmarshal error", "docID", docID, "error", err)
 		// registerFailedJson(docID, dateKey, err.Error())
 	}
   // 自分を取り除いたスライスを作成
@@ -1746,16 +1781,16 @@ func deleteFailedJsonItem(docID string, dateKey string, companyName string) {
 	}
   // failed.json に登録されていれば json を登録し直す
   if alreadyFailed {
-    fmt.Printf("failed.json から「%s」のレポート (%s) を削除したもので書き換えます❗️\n", companyName, docID)
+    slog.Info("failed.json からレポートを削除します", "docID", docID, "companyName", companyName)
     jsonBody, err := json.MarshalIndent(newFailedReports, "", "  ")
     if err != nil {
-      fmt.Println("json MarshalIndent when trying to write failed json error: ", err)
+      slog.Error("failed json marshal error", "docID", docID, "error", err)
       // registerFailedJson(docID, dateKey, err.Error())
     }
     // json を書き出す
     err = os.WriteFile(failedJSONFile, jsonBody, 0666)
     if err != nil {
-      fmt.Println("failed json write error: ", err)
+      slog.Error("failed json write error", "docID", docID, "error", err)
       // registerFailedJson(docID, dateKey, err.Error())
     }
   }
@@ -1777,7 +1812,7 @@ func registerInvalidSummaryJson(docID string, dateKey string, summaryType string
 		// ファイルがない場合は作成する
 		_, err := os.Create(invalidSummaryJSONFile)
 		if err != nil {
-			fmt.Println("failed json create error: ", err)
+			slog.Error("failed json create error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 	}
@@ -1786,12 +1821,12 @@ func registerInvalidSummaryJson(docID string, dateKey string, summaryType string
 	// 中身を取得
 	body, err := io.ReadAll(openFile)
 	if err != nil {
-		fmt.Println("failed json read error: ", err)
+		slog.Error("failed json read error", "docID", docID, "error", err)
 		// registerFailedJson(docID, dateKey, err.Error())
 	}
 	err = json.Unmarshal(body, &invalidSummaries)
 	if err != nil {
-		fmt.Println("failed json unmarshal error: ", err)
+		slog.Error("failed json unmarshal error", "docID", docID, "error", err)
 		// registerFailedJson(docID, dateKey, err.Error())
 	}
 	// 配列の中に自分がいるか確認
@@ -1813,13 +1848,13 @@ func registerInvalidSummaryJson(docID string, dateKey string, summaryType string
 		invalidSummaries = append(invalidSummaries, invalidSummary)
 		jsonBody, err := json.MarshalIndent(invalidSummaries, "", "  ")
 		if err != nil {
-			fmt.Println("json MarshalIndent when trying to write invalid summary error: ", err)
+			slog.Error("invalid summary marshal error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 		// json を書き出す
 		err = os.WriteFile(invalidSummaryJSONFile, jsonBody, 0666)
 		if err != nil {
-			fmt.Println("invalid summary write error: ", err)
+			slog.Error("invalid summary write error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 	}
@@ -1838,7 +1873,7 @@ func deleteInvalidSummaryJsonItem(docID string, dateKey string, summaryType stri
 		// ファイルがない場合は作成する
 		_, err := os.Create(invalidSummaryJSONFile)
 		if err != nil {
-			fmt.Println("failed json create error: ", err)
+			slog.Error("failed json create error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 	}
@@ -1847,12 +1882,12 @@ func deleteInvalidSummaryJsonItem(docID string, dateKey string, summaryType stri
 	// 中身を取得
 	body, err := io.ReadAll(openFile)
 	if err != nil {
-		fmt.Println("failed json read error: ", err)
+		slog.Error("failed json read error", "docID", docID, "error", err)
 		// registerFailedJson(docID, dateKey, err.Error())
 	}
 	err = json.Unmarshal(body, &invalidSummaries)
 	if err != nil {
-		fmt.Println("failed json unmarshal error: ", err)
+		slog.Error("failed json unmarshal error", "docID", docID, "error", err)
 		// registerFailedJson(docID, dateKey, err.Error())
 	}
 
@@ -1870,23 +1905,23 @@ func deleteInvalidSummaryJsonItem(docID string, dateKey string, summaryType stri
 	}
 	// invalid-summary.json に登録されていれば json を登録し直す
 	if alreadyFailed {
-    fmt.Printf("invalid-summary.json から「%s」のレポート (%s) を削除したもので書き換えます❗️\n", companyName, docID)
+    slog.Info("invalid-summary.json からレポートを削除します", "docID", docID, "companyName", companyName)
 
 		jsonBody, err := json.MarshalIndent(newInvalidSummaries, "", "  ")
 		if err != nil {
-			fmt.Println("json MarshalIndent when trying to write invalid summary error: ", err)
+			slog.Error("invalid summary marshal error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 		// json を書き出す
 		err = os.WriteFile(invalidSummaryJSONFile, jsonBody, 0666)
 		if err != nil {
-			fmt.Println("invalid summary write error: ", err)
+			slog.Error("invalid summary write error", "docID", docID, "error", err)
 			// registerFailedJson(docID, dateKey, err.Error())
 		}
 	}
 }
 
-func PutXBRLtoS3(docID string, dateKey string, key string, body []byte) {
+func PutXBRLtoS3(docID string, dateKey string, key string, body []byte) error {
 	// ファイルの存在チェック
 	existsFile, _ := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
 		Bucket: aws.String(EDINETBucketName),
@@ -1902,8 +1937,100 @@ func PutXBRLtoS3(docID string, dateKey string, key string, body []byte) {
 		if err != nil {
 			errMsg = "S3 への XBRL ファイル送信エラー: "
 			registerFailedJson(docID, dateKey, errMsg+err.Error())
-			return
+			return err
 		}
-    fmt.Printf("xbrlファイル(%s)をS3に送信しました⭐️\n", key)
+    slog.Info("XBRL ファイルを S3 に送信しました", "docID", docID, "key", key)
+	}
+	return nil
+}
+
+// {dateKey}/{docID}/ 配下に保存済みの XBRL ファイルのキーを返す (ない場合は空)
+func FindRegisteredXBRLKey(docID string, dateKey string) string {
+	output, err := s3Client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(EDINETBucketName),
+		Prefix: aws.String(fmt.Sprintf("%s/%s/", dateKey, docID)),
+	})
+	if err != nil {
+		slog.Warn("保存済みの XBRL ファイルの取得に失敗しました", "docID", docID, "error", err)
+		return ""
+	}
+	for _, content := range output.Contents {
+		if strings.HasSuffix(aws.ToString(content.Key), ".xbrl") {
+			return aws.ToString(content.Key)
+		}
+	}
+	return ""
+}
+
+// EDINET から PDF (type=2) を取得して S3 に保存し、キーを返す (PDF がない書類・取得に失敗した場合は空)
+func PutPDFtoS3(client *http.Client, docID string, dateKey string) string {
+	key := api.FilingPDFKey(dateKey, docID)
+	// ファイルの存在チェック
+	existsFile, _ := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(EDINETBucketName),
+		Key:    aws.String(key),
+	})
+	if existsFile != nil {
+		return key
+	}
+
+	apiTimes += 1
+	url := fmt.Sprintf("https://api.edinet-fsa.go.jp/api/v2/documents/%s?type=2&Subscription-Key=%s", docID, EDINETSubAPIKey)
+	resp, err := client.Get(url)
+	if err != nil {
+		// PDF は XBRL の登録に必要ないため、失敗しても書類の登録は続ける
+		slog.Warn("PDF の取得に失敗しました", "docID", docID, "error", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	// PDF がない書類はエラーの JSON が返る
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/pdf") {
+		slog.Warn("PDF がありません", "docID", docID, "status", resp.StatusCode, "contentType", resp.Header.Get("Content-Type"))
+		return ""
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.Warn("PDF の読み込みに失敗しました", "docID", docID, "error", err)
+		return ""
+	}
+
+	_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(EDINETBucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/pdf"),
+	})
+	if err != nil {
+		slog.Warn("S3 への PDF ファイル送信エラー", "docID", docID, "key", key, "error", err)
+		return ""
+	}
+	slog.Info("PDF ファイルを S3 に送信しました", "docID", docID, "key", key)
+	return key
+}
+
+// 書類管理番号から原本 (XBRL・PDF) のキーを探せるように filings/{docID}.json を保存する
+func PutFilingIndex(docID string, dateKey string, xbrlKey string, pdfKey string) {
+	filing := internal.Filing{
+		DocID:   docID,
+		DateKey: dateKey,
+		XBRLKey: xbrlKey,
+		PDFKey:  pdfKey,
+	}
+	body, err := json.Marshal(filing)
+	if err != nil {
+		slog.Warn("原本の一覧の作成に失敗しました", "docID", docID, "error", err)
+		return
+	}
+	key := api.FilingIndexKey(docID)
+	_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(EDINETBucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		slog.Warn("S3 への原本の一覧の送信エラー", "docID", docID, "key", key, "error", err)
+		return
 	}
+	slog.Debug("原本の一覧を S3 に送信しました", "docID", docID, "key", key)
 }
@@ -2,100 +2,136 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/joe-black-jb/compass-api/internal/api"
-	"github.com/joho/godotenv"
+	"github.com/joe-black-jb/compass-api/internal/config"
 )
 
-var dynamoClient *dynamodb.Client
-var s3Client *s3.Client
-var env string
+var conf *config.Config
 
 func init() {
-	fmt.Println("init ⭐️")
-
-	env = os.Getenv("ENV")
-
-	if env == "local" {
-		err := godotenv.Load()
-		if err != nil {
-			log.Fatal("Error loading .env file err: ", err)
-			return
-		}
+	var err error
+	conf, err = config.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
-	region := os.Getenv("REGION")
-
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	// 必須の設定が不足している場合は起動しない
+	err = conf.ValidateAPI()
 	if err != nil {
-		fmt.Println("Load default config error: %v", err)
-		return
+		log.Fatal(err)
+	}
+	err = api.Init(conf)
+	if err != nil {
+		log.Fatal(err)
 	}
-	dynamoClient = dynamodb.NewFromConfig(cfg)
-
-	s3Client = s3.NewFromConfig(cfg)
 }
 
 func main() {
-	fmt.Println("main ⭐️")
 	// DB接続
 	// database.Connect()
 
-	if env == "local" {
-		fmt.Println("start gin ⭐️")
-		// ローカルでは gin のルーターを起動
-		api.Router()
+	if conf.UseHTTPServer() {
+		slog.Info("start http server")
+		// Lambda 以外 (ローカル・コンテナなど) では gin のサーバーを起動し、SIGTERM で処理中のリクエストを待って終了する
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+		err := api.Serve(ctx)
+		if err != nil {
+			slog.Error("server failed", "error", err)
+			os.Exit(1)
+		}
 	} else {
-		fmt.Println("start lambda ⭐️")
+		slog.Info("start lambda")
 		// ハンドラー関数実行 (Lambda を使用する場合)
-		lambda.Start(handler)
+		// REST API 以外 (HTTP API・ALB・関数 URL) のイベントはアダプターで変換する
+		lambda.Start(api.WithLambdaEventAdapter(api.WithRequestLogging(api.WithMetrics(api.WithHealthCheck(api.WithIPRateLimit(api.WithAPIKey(api.WithAPIKeyRateLimit(handler))))))))
 	}
 }
 
 func handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	fmt.Printf("Received request: %v\n", req)
-
 	path := req.PathParameters["path"]
 	companyId := req.PathParameters["companyId"]
+	api.Logger(ctx).Debug("routing", "path", path, "companyId", companyId)
 
 	if companyId != "" {
-		fmt.Println("get company route")
-		return api.GetCompany(req, dynamoClient)
+		return api.GetCompany(req)
+	}
+
+	// 企業ごとのリソース (companies/{id}/news)
+	if strings.HasPrefix(path, "companies/") {
+		return api.Companies(ctx, req)
+	}
+
+	// 書類 (reports/{key}/raw)
+	if strings.HasPrefix(path, "reports/") {
+		return api.Reports(ctx, req)
+	}
+
+	// 提出書類の原本 (filings/{docID}/xbrl・pdf)
+	if strings.HasPrefix(path, "filings/") {
+		return api.Filings(ctx, req)
+	}
+
+	// ユーザーごとのリソース (watchlists/{id}/... のようにパスに ID を含む)
+	if path == "watchlists" || strings.HasPrefix(path, "watchlists/") {
+		return api.WithAuth(api.Watchlists)(ctx, req)
+	}
+	if path == "subscriptions" || strings.HasPrefix(path, "subscriptions/") {
+		return api.WithAuth(api.Subscriptions)(ctx, req)
+	}
+	if path == "alerts" || strings.HasPrefix(path, "alerts/") {
+		return api.WithAuth(api.Alerts)(ctx, req)
+	}
+	if path == "webhooks" || strings.HasPrefix(path, "webhooks/") {
+		return api.WithAuth(api.WithRole(api.RoleAnalyst, api.Webhooks))(ctx, req)
 	}
 
 	// Routing
 	switch path {
 	case "companies":
-		fmt.Println("companies route")
-		return api.GetCompanies(req, dynamoClient)
+		return api.GetCompanies(req)
 	case "search":
-		fmt.Println("search companies route")
-		return api.SearchCompaniesByName(req, dynamoClient)
+		return api.SearchCompaniesByName(req)
 	// case "company":
 	// 	fmt.Println("search company route")
-	// 	return api.GetCompany(req, dynamoClient)
+	// 	return api.GetCompany(req)
 	case "reports":
-		fmt.Println("search reports route")
-		return api.GetReports(req, dynamoClient)
+		return api.GetReports(req)
 	case "fundamentals":
-		fmt.Println("search fundamentals route")
-		return api.GetFundamentals(req, dynamoClient)
+		return api.GetFundamentals(req)
 	case "news":
-		fmt.Println("news route")
-		return api.GetLatestNews(req)
-	default:
-		fmt.Println("default")
+		return api.GetNews(ctx, req)
+	case "news/range":
+		return api.ListNewsEditions(ctx, req)
+	case "register":
+		return api.RegisterUser(ctx, req)
+	case "login":
+		return api.Login(ctx, req)
+	case "token/refresh":
+		return api.RefreshToken(ctx, req)
+	case "logout":
+		return api.Logout(ctx, req)
+	case "verify-email":
+		return api.VerifyEmail(ctx, req)
+	case "verify-email/resend":
+		return api.ResendVerification(ctx, req)
+	case "password/forgot":
+		return api.ForgotPassword(ctx, req)
+	case "password/reset":
+		return api.ResetPassword(ctx, req)
+	case "admin/users/unlock":
+		return api.WithAuth(api.WithRole(api.RoleAdmin, api.UnlockUser))(ctx, req)
+	case "admin/users/role":
+		return api.WithAuth(api.WithRole(api.RoleAdmin, api.UpdateUserRole))(ctx, req)
 	}
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Body:       string("OK"),
-	}, nil
+	api.Logger(ctx).Info("route not found", "path", path)
+	return api.NotFound(ctx, req)
 }
@@ -0,0 +1,525 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+// 1 ユーザーあたりの購読数の上限
+const maxSubscriptionsPerUser = 200
+
+// 書類の通知を受け取る EDINET コードの購読の保存先
+type SubscriptionStore interface {
+	ListSubscriptions(ctx context.Context, userID string) ([]string, error)
+	AddSubscription(ctx context.Context, userID string, EDINETCode string) error
+	RemoveSubscription(ctx context.Context, userID string, EDINETCode string) error
+	// EDINET コードを購読しているユーザー ID を返す
+	ListSubscribers(ctx context.Context, EDINETCode string) ([]string, error)
+}
+
+// 通知の保存先
+type AlertStore interface {
+	// 通知を作成する (同じ ID の通知が既にある場合は false)
+	CreateAlert(ctx context.Context, alert *internal.Alert) (bool, error)
+	// 新しい順に返す
+	ListAlerts(ctx context.Context, userID string, unreadOnly bool) ([]internal.Alert, error)
+	// ids が空の場合は全て既読にする
+	MarkAlertsRead(ctx context.Context, userID string, ids []string) error
+}
+
+// メモリ上で購読を保持する (ローカル用)
+type MemorySubscriptionStore struct {
+	mu            sync.RWMutex
+	subscriptions map[string]map[string]bool
+}
+
+func NewMemorySubscriptionStore() *MemorySubscriptionStore {
+	return &MemorySubscriptionStore{subscriptions: map[string]map[string]bool{}}
+}
+
+func (s *MemorySubscriptionStore) ListSubscriptions(ctx context.Context, userID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	codes := []string{}
+	for code := range s.subscriptions[userID] {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes, nil
+}
+
+func (s *MemorySubscriptionStore) AddSubscription(ctx context.Context, userID string, EDINETCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriptions[userID] == nil {
+		s.subscriptions[userID] = map[string]bool{}
+	}
+	s.subscriptions[userID][EDINETCode] = true
+	return nil
+}
+
+func (s *MemorySubscriptionStore) RemoveSubscription(ctx context.Context, userID string, EDINETCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions[userID], EDINETCode)
+	return nil
+}
+
+func (s *MemorySubscriptionStore) ListSubscribers(ctx context.Context, EDINETCode string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var userIDs []string
+	for userID, codes := range s.subscriptions {
+		if codes[EDINETCode] {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs, nil
+}
+
+/*
+DynamoDB に購読を保存する
+
+テーブル: パーティションキー userId (S)、ソートキー edinetCode (S)
+GSI EDINETCodeIndex: パーティションキー edinetCode (S)
+*/
+type DynamoSubscriptionStore struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func (s *DynamoSubscriptionStore) query(ctx context.Context, input *dynamodb.QueryInput, attr string) ([]string, error) {
+	var values []string
+	for {
+		output, err := s.Client.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range output.Items {
+			if v, ok := item[attr].(*types.AttributeValueMemberS); ok {
+				values = append(values, v.Value)
+			}
+		}
+		if output.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return values, nil
+}
+
+func (s *DynamoSubscriptionStore) ListSubscriptions(ctx context.Context, userID string) ([]string, error) {
+	codes, err := s.query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.TableName),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+	}, "edinetCode")
+	if codes == nil && err == nil {
+		codes = []string{}
+	}
+	return codes, err
+}
+
+func (s *DynamoSubscriptionStore) AddSubscription(ctx context.Context, userID string, EDINETCode string) error {
+	_, err := s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.TableName),
+		Item: map[string]types.AttributeValue{
+			"userId":     &types.AttributeValueMemberS{Value: userID},
+			"edinetCode": &types.AttributeValueMemberS{Value: EDINETCode},
+			"createdAt":  &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)},
+		},
+	})
+	return err
+}
+
+func (s *DynamoSubscriptionStore) RemoveSubscription(ctx context.Context, userID string, EDINETCode string) error {
+	_, err := s.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]types.AttributeValue{
+			"userId":     &types.AttributeValueMemberS{Value: userID},
+			"edinetCode": &types.AttributeValueMemberS{Value: EDINETCode},
+		},
+	})
+	return err
+}
+
+func (s *DynamoSubscriptionStore) ListSubscribers(ctx context.Context, EDINETCode string) ([]string, error) {
+	return s.query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.TableName),
+		IndexName:              aws.String("EDINETCodeIndex"),
+		KeyConditionExpression: aws.String("edinetCode = :edinetCode"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":edinetCode": &types.AttributeValueMemberS{Value: EDINETCode},
+		},
+	}, "userId")
+}
+
+// メモリ上で通知を保持する (ローカル用)
+type MemoryAlertStore struct {
+	mu     sync.Mutex
+	alerts map[string]map[string]internal.Alert
+}
+
+func NewMemoryAlertStore() *MemoryAlertStore {
+	return &MemoryAlertStore{alerts: map[string]map[string]internal.Alert{}}
+}
+
+func (s *MemoryAlertStore) CreateAlert(ctx context.Context, alert *internal.Alert) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.alerts[alert.UserID] == nil {
+		s.alerts[alert.UserID] = map[string]internal.Alert{}
+	}
+	if _, ok := s.alerts[alert.UserID][alert.ID]; ok {
+		return false, nil
+	}
+	s.alerts[alert.UserID][alert.ID] = *alert
+	return true, nil
+}
+
+func (s *MemoryAlertStore) ListAlerts(ctx context.Context, userID string, unreadOnly bool) ([]internal.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alerts := []internal.Alert{}
+	for _, alert := range s.alerts[userID] {
+		if unreadOnly && alert.Read {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	sortAlerts(alerts)
+	return alerts, nil
+}
+
+func (s *MemoryAlertStore) MarkAlertsRead(ctx context.Context, userID string, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(ids) == 0 {
+		for id := range s.alerts[userID] {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		if alert, ok := s.alerts[userID][id]; ok {
+			alert.Read = true
+			s.alerts[userID][id] = alert
+		}
+	}
+	return nil
+}
+
+func sortAlerts(alerts []internal.Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].CreatedAt.After(alerts[j].CreatedAt)
+	})
+}
+
+/*
+DynamoDB に通知を保存する
+
+テーブル: パーティションキー userId (S)、ソートキー id (S)
+*/
+type DynamoAlertStore struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func (s *DynamoAlertStore) CreateAlert(ctx context.Context, alert *internal.Alert) (bool, error) {
+	item, err := attributevalue.MarshalMap(alert)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *DynamoAlertStore) ListAlerts(ctx context.Context, userID string, unreadOnly bool) ([]internal.Alert, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.TableName),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+	if unreadOnly {
+		input.FilterExpression = aws.String("#read = :false")
+		input.ExpressionAttributeNames = map[string]string{"#read": "read"}
+		input.ExpressionAttributeValues[":false"] = &types.AttributeValueMemberBOOL{Value: false}
+	}
+	alerts := []internal.Alert{}
+	for {
+		output, err := s.Client.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		var batch []internal.Alert
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &batch)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, batch...)
+		if output.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	sortAlerts(alerts)
+	return alerts, nil
+}
+
+func (s *DynamoAlertStore) MarkAlertsRead(ctx context.Context, userID string, ids []string) error {
+	if len(ids) == 0 {
+		alerts, err := s.ListAlerts(ctx, userID, true)
+		if err != nil {
+			return err
+		}
+		for _, alert := range alerts {
+			ids = append(ids, alert.ID)
+		}
+	}
+	for _, id := range ids {
+		_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(s.TableName),
+			Key: map[string]types.AttributeValue{
+				"userId": &types.AttributeValueMemberS{Value: userID},
+				"id":     &types.AttributeValueMemberS{Value: id},
+			},
+			UpdateExpression:         aws.String("SET #read = :true"),
+			ConditionExpression:      aws.String("attribute_exists(id)"),
+			ExpressionAttributeNames: map[string]string{"#read": "read"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":true": &types.AttributeValueMemberBOOL{Value: true},
+			},
+		})
+		var conditionErr *types.ConditionalCheckFailedException
+		if err != nil && !errors.As(err, &conditionErr) {
+			return err
+		}
+	}
+	return nil
+}
+
+// 設定から購読の保存先を決める
+func newSubscriptionStore() SubscriptionStore {
+	if tableName := conf.SubscriptionTableName; tableName != "" {
+		return &DynamoSubscriptionStore{Client: dynamoClient, TableName: tableName}
+	}
+	return NewMemorySubscriptionStore()
+}
+
+// 設定から通知の保存先を決める
+func newAlertStore() AlertStore {
+	if tableName := conf.AlertTableName; tableName != "" {
+		return &DynamoAlertStore{Client: dynamoClient, TableName: tableName}
+	}
+	return NewMemoryAlertStore()
+}
+
+/*
+新しい書類を購読しているユーザーに通知する (バッチから呼び出す)
+
+通知の ID は docID とし、同じ書類を再度処理した場合は通知しない
+作成した通知の件数を返す
+*/
+func RecordFilingAlerts(ctx context.Context, report internal.Result) (int, error) {
+	userIDs, err := subscriptionStore.ListSubscribers(ctx, report.EdinetCode)
+	if err != nil {
+		return 0, err
+	}
+	created := 0
+	for _, userID := range userIDs {
+		ok, err := alertStore.CreateAlert(ctx, &internal.Alert{
+			UserID:         userID,
+			ID:             report.DocId,
+			EDINETCode:     report.EdinetCode,
+			DocID:          report.DocId,
+			DocTypeCode:    report.DocTypeCode,
+			CompanyName:    report.FilerName,
+			DocDescription: report.DocDescription,
+			SubmitDateTime: report.SubmitDateTime,
+			CreatedAt:      time.Now(),
+		})
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created++
+		}
+	}
+	return created, nil
+}
+
+func ListSubscriptionsProcessor(ctx context.Context, userID string) ([]string, error) {
+	return subscriptionStore.ListSubscriptions(ctx, userID)
+}
+
+func AddSubscriptionProcessor(ctx context.Context, userID string, EDINETCode string) error {
+	codes, err := normalizeEDINETCodes([]string{EDINETCode})
+	if err != nil {
+		return err
+	}
+	subscriptions, err := subscriptionStore.ListSubscriptions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(subscriptions) >= maxSubscriptionsPerUser {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("購読できる企業は %d 件までです", maxSubscriptionsPerUser))
+	}
+	return subscriptionStore.AddSubscription(ctx, userID, codes[0])
+}
+
+func RemoveSubscriptionProcessor(ctx context.Context, userID string, EDINETCode string) error {
+	return subscriptionStore.RemoveSubscription(ctx, userID, strings.ToUpper(strings.TrimSpace(EDINETCode)))
+}
+
+func ListAlertsProcessor(ctx context.Context, userID string, unreadOnly bool) ([]internal.Alert, error) {
+	return alertStore.ListAlerts(ctx, userID, unreadOnly)
+}
+
+func MarkAlertsReadProcessor(ctx context.Context, userID string, ids []string) error {
+	return alertStore.MarkAlertsRead(ctx, userID, ids)
+}
+
+func ListSubscriptionsGin(c *gin.Context) {
+	codes, err := ListSubscriptionsProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+func AddSubscriptionGin(c *gin.Context) {
+	err := AddSubscriptionProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Param("edinetCode"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "購読しました")
+}
+
+func RemoveSubscriptionGin(c *gin.Context) {
+	err := RemoveSubscriptionProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Param("edinetCode"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "購読を解除しました")
+}
+
+// 通知一覧 (デフォルトは未読のみ、?all=true で既読も含める)
+func ListAlertsGin(c *gin.Context) {
+	alerts, err := ListAlertsProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Query("all") != "true")
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+func MarkAlertsReadGin(c *gin.Context) {
+	var reqBody internal.MarkAlertsReadBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	err := MarkAlertsReadProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), reqBody.IDs)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "既読にしました")
+}
+
+/*
+購読のルーティング (Lambda、WithAuth の内側で使う)
+
+	GET    subscriptions
+	PUT    subscriptions/{EDINETコード}
+	DELETE subscriptions/{EDINETコード}
+*/
+func Subscriptions(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userID := UserIDFromContext(ctx)
+	segments := strings.Split(strings.Trim(req.PathParameters["path"], "/"), "/")
+
+	switch {
+	case len(segments) == 1 && req.HTTPMethod == http.MethodGet:
+		codes, err := ListSubscriptionsProcessor(ctx, userID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, codes)
+	case len(segments) == 2 && req.HTTPMethod == http.MethodPut:
+		err := AddSubscriptionProcessor(ctx, userID, segments[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, "購読しました")
+	case len(segments) == 2 && req.HTTPMethod == http.MethodDelete:
+		err := RemoveSubscriptionProcessor(ctx, userID, segments[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, "購読を解除しました")
+	}
+	return errorResponse(NewError(http.StatusNotFound, "Not Found"))
+}
+
+/*
+通知のルーティング (Lambda、WithAuth の内側で使う)
+
+	GET  alerts
+	POST alerts/read
+*/
+func Alerts(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userID := UserIDFromContext(ctx)
+	path := strings.Trim(req.PathParameters["path"], "/")
+
+	switch {
+	case path == "alerts" && req.HTTPMethod == http.MethodGet:
+		alerts, err := ListAlertsProcessor(ctx, userID, req.QueryStringParameters["all"] != "true")
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, alerts)
+	case path == "alerts/read" && req.HTTPMethod == http.MethodPost:
+		var reqBody internal.MarkAlertsReadBody
+		if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+			return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		}
+		err := MarkAlertsReadProcessor(ctx, userID, reqBody.IDs)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, "既読にしました")
+	}
+	return errorResponse(NewError(http.StatusNotFound, "Not Found"))
+}
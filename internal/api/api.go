@@ -4,53 +4,109 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"slices"
-	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/joe-black-jb/compass-api/internal"
+	"github.com/joe-black-jb/compass-api/internal/config"
 	"github.com/joe-black-jb/compass-api/internal/database"
-	"github.com/joho/godotenv"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+// 設定 (Init で置き換える)
+var conf = config.Default()
 var dynamoClient *dynamodb.Client
 var s3Client *s3.Client
-var latestFileKey = "latest/news.json"
+var apiKeyStore APIKeyStore
+var rateLimitStore RateLimitStore
+var tokenVerifier *TokenVerifier
+var tokenSigner *TokenSigner
+var tokenStore TokenStore
+var userRepository UserRepository
+var loginAttemptStore LoginAttemptStore
+var mailSender MailSender
+var watchlistRepository WatchlistRepository
+var subscriptionStore SubscriptionStore
+var alertStore AlertStore
+var webhookStore WebhookStore
+var webhookDispatcher *WebhookDispatcher
+var newsStore ObjectStore
+var reportStore ObjectStore
+
+// 提出書類の原本 (EDINET_BUCKET_NAME が未設定の場合は nil)
+var filingStore ObjectStore
 
-func init() {
-	env := os.Getenv("ENV")
+/*
+設定から AWS クライアント・各保存先を初期化する
 
-	if env == "local" {
-		err := godotenv.Load()
-		if err != nil {
-			log.Fatal("Error loading .env file err: ", err)
-			return
-		}
-	}
-	region := os.Getenv("REGION")
-	cfg, cfgErr := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
-	if cfgErr != nil {
-		fmt.Println("Load default config error: %v", cfgErr)
-		return
+API (cmd/compass-api) とバッチの起動時に config.Load・検証の後に呼び出す
+*/
+func Init(c *config.Config) error {
+	conf = c
+	// JSON 形式の構造化ログ (LOG_LEVEL でレベルを指定する)
+	slog.SetDefault(NewLogger(os.Stdout, parseLogLevel(conf.LogLevel)))
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithRegion(conf.Region))
+	if err != nil {
+		return fmt.Errorf("AWS 設定の読み込みエラー: %w", err)
 	}
+	// S3 / DynamoDB の呼び出しをメトリクスに記録する
+	cfg.APIOptions = append(cfg.APIOptions, WithAWSMetrics)
 	s3Client = s3.NewFromConfig(cfg)
 	dynamoClient = dynamodb.NewFromConfig(cfg)
+
+	store, err := newAPIKeyStore()
+	if err != nil {
+		return fmt.Errorf("API キーの読み込みエラー: %w", err)
+	}
+	apiKeyStore = store
+	rateLimitStore = newRateLimitStore()
+	tokenStore = newTokenStore()
+	userRepository = newUserRepository()
+	loginAttemptStore = newLoginAttemptStore()
+	watchlistRepository = newWatchlistRepository()
+	subscriptionStore = newSubscriptionStore()
+	alertStore = newAlertStore()
+	webhookStore = newWebhookStore()
+	webhookDispatcher = newWebhookDispatcher()
+	newsStore = NewNewsObjectStore(s3Client)
+	reportStore = &S3ObjectStore{Client: s3Client, Bucket: conf.BucketName}
+	if conf.EDINETBucketName != "" {
+		filingStore = &S3ObjectStore{Client: s3Client, Bucket: conf.EDINETBucketName}
+	}
+
+	newsCategoryDictionary, err = newNewsCategoryDictionary()
+	if err != nil {
+		return fmt.Errorf("ニュースのカテゴリ辞書の読み込みエラー: %w", err)
+	}
+
+	mailSender, err = newMailSender()
+	if err != nil {
+		return fmt.Errorf("メール送信設定の読み込みエラー: %w", err)
+	}
+
+	tokenVerifier, err = newTokenVerifier()
+	if err != nil {
+		return fmt.Errorf("JWT 検証設定の読み込みエラー: %w", err)
+	}
+	tokenSigner, err = newTokenSigner()
+	if err != nil {
+		return fmt.Errorf("JWT 署名設定の読み込みエラー: %w", err)
+	}
+	return nil
 }
 
 // TODO: バッチでDynamoDBの中身をS3に保存する
 // TODO: Dynamo Stream で DBの更新をトリガーにデータをS3に流す機能
 // TODO: GetCompanies を DB からではなく S3 から取るようにする
-func GetCompanies(req events.APIGatewayProxyRequest, dynamoClient *dynamodb.Client) (events.APIGatewayProxyResponse, error) {
+func GetCompanies(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	limit := req.QueryStringParameters["limit"]
 
 	companies, err := GetCompaniesProcessor(limit)
@@ -62,7 +118,7 @@ func GetCompanies(req events.APIGatewayProxyRequest, dynamoClient *dynamodb.Clie
 	}
 	body, err := json.Marshal(companies)
 	if err != nil {
-		fmt.Println("failed to marshal companies to json: ", err)
+		slog.Error("failed to marshal companies to json", "error", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusInternalServerError,
 			Body:       "json.Marshal Error",
@@ -77,9 +133,7 @@ func GetCompanies(req events.APIGatewayProxyRequest, dynamoClient *dynamodb.Clie
 	}, nil
 }
 
-func GetCompany(req events.APIGatewayProxyRequest, dynamoClient *dynamodb.Client) (events.APIGatewayProxyResponse, error) {
-	fmt.Println("==== GetCompany ====")
-
+func GetCompany(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// API Gateway で /{companyId} を指定する
 	companyId := req.PathParameters["companyId"]
 
@@ -97,7 +151,7 @@ func GetCompany(req events.APIGatewayProxyRequest, dynamoClient *dynamodb.Client
 	}, nil
 }
 
-func SearchCompaniesByName(req events.APIGatewayProxyRequest, dynamoClient *dynamodb.Client) (events.APIGatewayProxyResponse, error) {
+func SearchCompaniesByName(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	companyName := req.QueryStringParameters["companyName"]
 
 	companies, err := SearchCompaniesByNameProcessor(companyName)
@@ -141,7 +195,7 @@ func GetTitles(c *gin.Context) {
 		c.IndentedJSON(http.StatusOK, parents)
 		return
 	}
-	fmt.Println("親の数: ", len(parents))
+	slog.Debug("titles", "parents", len(parents))
 
 	c.IndentedJSON(http.StatusOK, Titles)
 }
@@ -197,7 +251,7 @@ func UpdateTitle(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
-	fmt.Println("Updated Title: ", title)
+	slog.Info("title updated", "titleId", title.ID)
 	c.JSON(http.StatusOK, title)
 }
 
@@ -219,7 +273,7 @@ func CreateTitle(c *gin.Context) {
 	var reqBody internal.CreateTitleBody
 	var title = &internal.Title{}
 	if err := c.ShouldBindJSON(&reqBody); err != nil {
-		fmt.Println("err: ", err)
+		slog.Info("invalid request body", "error", err)
 		c.JSON(http.StatusNotFound, err)
 		return
 	}
@@ -257,7 +311,7 @@ func CreateTitle(c *gin.Context) {
 		// 1. titles テーブルにレコード追加
 		if err := tx.Create(&title).Error; err != nil {
 			tx.Rollback()
-			fmt.Println("エラー内容: ", err)
+			slog.Error("create title failed", "error", err)
 			errObj := &internal.Error{}
 			errObj.Status = http.StatusInternalServerError
 			errObj.Message = "勘定項目の作成に失敗しました"
@@ -271,7 +325,7 @@ func CreateTitle(c *gin.Context) {
 		companyTitle.TitleID = int(*titleId)
 		if err := tx.Create(&companyTitle).Error; err != nil {
 			tx.Rollback()
-			fmt.Println("エラー内容: ", err)
+			slog.Error("create title failed", "error", err)
 			errObj := &internal.Error{}
 			errObj.Status = http.StatusInternalServerError
 			errObj.Message = "中間テーブルへの登録に失敗しました"
@@ -344,125 +398,28 @@ func DeleteTitle(c *gin.Context) {
 	c.JSON(http.StatusOK, deletedMsg)
 }
 
-func RegisterUser(c *gin.Context) {
+func RegisterUser(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var reqBody internal.RegisterUserBody
-	fmt.Println("ハッシュ化開始❗️")
-	if err := c.ShouldBindJSON(&reqBody); err != nil {
-		fmt.Println("err: ", err)
-		c.JSON(http.StatusNotFound, err)
-	}
-	var errors []string
-	if reqBody.Name == nil {
-		errors = append(errors, "名前")
-	}
-	if reqBody.Password == nil {
-		errors = append(errors, "パスワード")
-	}
-	if reqBody.Email == nil {
-		errors = append(errors, "メールアドレス")
-	}
-	if len(errors) > 0 {
-		errObj := &internal.Error{}
-		errObj.Status = http.StatusBadRequest
-		errObj.Message = fmt.Sprintf("未入力の項目があります。項目: %v", errors)
-		c.JSON(http.StatusBadRequest, errObj)
-		return
-	}
-	// メールアドレス重複チェック
-	users := &[]internal.User{}
-	database.Db.Where("email = ?", reqBody.Email).First(&users)
-	if len(*users) > 0 {
-		errObj := &internal.Error{}
-		errObj.Status = http.StatusBadRequest
-		errObj.Message = ("入力されたメールアドレスは既に登録されています")
-		c.JSON(http.StatusBadRequest, errObj)
-		return
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(*reqBody.Password), bcrypt.DefaultCost)
+	err := RegisterUserProcessor(ctx, reqBody)
 	if err != nil {
-		errObj := &internal.Error{}
-		errObj.Status = http.StatusInternalServerError
-		errObj.Message = "パスワードの暗号化処理に失敗しました"
-		c.JSON(http.StatusInternalServerError, errObj)
-		return
-	}
-	user := &internal.User{}
-	user.Name = *reqBody.Name
-	user.Email = *reqBody.Email
-	user.Password = hash
-	user.Admin = false
-
-	// DB登録
-	if err := database.Db.Create(&user).Error; err != nil {
-		errObj := &internal.Error{}
-		errObj.Status = http.StatusInternalServerError
-		errObj.Message = "ユーザ登録処理に失敗しました"
-		c.JSON(http.StatusInternalServerError, errObj)
-		return
+		return errorResponse(err)
 	}
-
-	c.JSON(http.StatusOK, "ユーザ登録に成功しました")
+	return jsonResponse(http.StatusOK, "ユーザ登録に成功しました")
 }
 
-func Login(c *gin.Context) {
-	fmt.Println("ログイン処理開始")
+func Login(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var reqBody internal.Credentials
-	user := &internal.User{}
-	if err := c.ShouldBindJSON(&reqBody); err != nil {
-		fmt.Println("err: ", err)
-		c.JSON(http.StatusNotFound, err)
-		return
-	}
-	fmt.Println("user: ", user)
-	if err := database.Db.Where("email = ?", reqBody.Email).First(user).Error; err != nil {
-		errObj := &internal.Error{}
-		errObj.Status = http.StatusBadRequest
-		errObj.Message = "入力されたメールアドレスは登録されていません"
-		c.JSON(http.StatusBadRequest, errObj)
-		return
-	}
-	if err := bcrypt.CompareHashAndPassword(user.Password, []byte(reqBody.Password)); err != nil {
-		fmt.Println("エラー発生: ", err)
-		errObj := &internal.Error{}
-		errObj.Status = http.StatusBadRequest
-		errObj.Message = "入力されたパスワードに誤りがあります"
-		c.JSON(http.StatusInternalServerError, errObj)
-		return
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
 	}
-	// jwt トークンの生成
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": user.Name,
-		"exp":      time.Now().Add(time.Hour * 24).Unix(),
-		"admin":    user.Admin,
-	})
-
-	// 秘密鍵の確認
-	jwtSecret := os.Getenv("SECRET_KEY")
-	if jwtSecret == "" {
-		c.JSON(http.StatusInternalServerError, "err")
-		return
-	}
-
-	// トークンの署名
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	loginResult, err := LoginProcessor(ctx, reqBody, req.RequestContext.Identity.SourceIP)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while generating token"})
-		return
+		return errorResponse(err)
 	}
-	fmt.Println("tokenString: ", tokenString)
-	// token.Raw = tokenString
-	// signature := strings.Split(tokenString, ".")[2]
-	// fmt.Println("signature: ", signature)
-	// token.Signature = signature
-
-	okObj := &internal.Ok{}
-	okObj.Status = http.StatusOK
-	okObj.Message = "認証に成功しました"
-
-	loginResult := internal.Login{}
-	loginResult.Username = user.Name
-	loginResult.Token = tokenString
-	c.JSON(http.StatusOK, loginResult)
+	return jsonResponse(http.StatusOK, loginResult)
 }
 
 func AuthUser(c *gin.Context) {
@@ -477,13 +434,25 @@ func AuthUser(c *gin.Context) {
 /*
 - S3 から EDINETコード 配下にある BS データが記載された HTML 一覧を取得する
 - HTML の中身を string で返してフロントで parse する
+- mode=url の場合は中身の代わりに署名付き URL を返す
 */
-func GetReports(req events.APIGatewayProxyRequest, client *dynamodb.Client) (events.APIGatewayProxyResponse, error) {
+func GetReports(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	EDINETCode := req.QueryStringParameters["EDINETCode"]
 	reportType := req.QueryStringParameters["reportType"]
 	extension := req.QueryStringParameters["extension"]
+	mode := req.QueryStringParameters["mode"]
 
-	fmt.Println("EDINETCode ⭐️: ", EDINETCode)
+	if err := validateReportMode(mode); err != nil {
+		return errorResponse(err)
+	}
+	// mode=url の場合は中身の代わりに署名付き URL を返す (Lambda のレスポンスサイズの上限を超えないように)
+	if mode == reportModeURL {
+		links, err := GetReportLinksProcessor(context.TODO(), EDINETCode, reportType, extension)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, links)
+	}
 
 	reportData, err := GetReportsProcessor(EDINETCode, reportType, extension)
 	if err != nil {
@@ -508,19 +477,19 @@ func GetReports(req events.APIGatewayProxyRequest, client *dynamodb.Client) (eve
 	}, nil
 }
 
-func GetFundamentals(req events.APIGatewayProxyRequest, client *dynamodb.Client) (events.APIGatewayProxyResponse, error) {
+func GetFundamentals(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	EDINETCode := req.QueryStringParameters["EDINETCode"]
 
 	// periodStart := c.Query("periodStart")
 
 	// S3 から BS HTML 一覧を取得
-	bucketName := os.Getenv("BUCKET_NAME")
+	bucketName := conf.BucketName
 	// プレフィックス (ディレクトリのようなもの)
 	prefix := fmt.Sprintf("%s/Fundamentals", EDINETCode)
 
 	fundamentals, err := GetFundamentalsProcessor(EDINETCode, bucketName, prefix)
 	if err != nil {
-		fmt.Println("Get Fundamentals error: ", err)
+		slog.Error("get fundamentals failed", "edinetCode", EDINETCode, "error", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusInternalServerError,
 			Body:       "Error",
@@ -541,22 +510,3 @@ func GetFundamentals(req events.APIGatewayProxyRequest, client *dynamodb.Client)
 		},
 	}, nil
 }
-
-func GetLatestNews(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	newsBucketName := os.Getenv("NEWS_BUCKET_NAME")
-
-	result, err := GetLatestNewsProcessor(newsBucketName, latestFileKey)
-	if err != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusInternalServerError,
-			Body:       "Error",
-		}, err
-	}
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Body:       result,
-		Headers: map[string]string{
-			"Content-type": "application/json",
-		},
-	}, nil
-}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+const apiKeyHeader = "x-api-key"
+const apiKeyContextKey contextKey = "apiKey"
+const usagePlanContextKey contextKey = "usagePlan"
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// API キーの保存先
+type APIKeyStore interface {
+	// ハッシュ化したキーで API キーを取得する (存在しない場合は ErrAPIKeyNotFound)
+	GetAPIKey(ctx context.Context, keyHash string) (*internal.APIKey, error)
+}
+
+// DynamoDB に保存された API キー (パーティションキーはキーのハッシュ)
+type DynamoAPIKeyStore struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func (s *DynamoAPIKeyStore) GetAPIKey(ctx context.Context, keyHash string) (*internal.APIKey, error) {
+	output, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: keyHash},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+	var apiKey internal.APIKey
+	err = attributevalue.UnmarshalMap(output.Item, &apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// ローカルの JSON ファイルに記載された API キー
+type FileAPIKeyStore struct {
+	keys map[string]internal.APIKey
+}
+
+/*
+JSON ファイルから API キーを読み込む
+
+	[{"key": "平文のキー", "name": "partner-a", "plan": "partner"}]
+
+key の代わりにハッシュ化済みの id を指定することもできる
+*/
+func NewFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		internal.APIKey
+		Key string `json:"key"`
+	}
+	err = json.Unmarshal(body, &entries)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]internal.APIKey{}
+	for _, entry := range entries {
+		apiKey := entry.APIKey
+		if entry.Key != "" {
+			apiKey.ID = HashAPIKey(entry.Key)
+		}
+		if apiKey.ID == "" {
+			return nil, fmt.Errorf("%s: key または id が設定されていない API キーがあります", path)
+		}
+		keys[apiKey.ID] = apiKey
+	}
+	return &FileAPIKeyStore{keys: keys}, nil
+}
+
+func (s *FileAPIKeyStore) GetAPIKey(ctx context.Context, keyHash string) (*internal.APIKey, error) {
+	apiKey, ok := s.keys[keyHash]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return &apiKey, nil
+}
+
+// 設定から API キーの保存先を決める (未設定の場合は nil を返し、ローカル環境ではキーの検証を行わない)
+func newAPIKeyStore() (APIKeyStore, error) {
+	if tableName := conf.APIKeyTableName; tableName != "" {
+		return &DynamoAPIKeyStore{Client: dynamoClient, TableName: tableName}, nil
+	}
+	if path := conf.APIKeyFile; path != "" {
+		return NewFileAPIKeyStore(path)
+	}
+	return nil, nil
+}
+
+// API キーを保存用にハッシュ化する
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// プラン名から利用プランを取得する (未知のプランはデフォルトプラン)
+func GetUsagePlan(name string) internal.UsagePlan {
+	if plan, ok := UsagePlans[name]; ok {
+		return plan
+	}
+	return UsagePlans[DefaultUsagePlan]
+}
+
+/*
+API キーを検証し、キーと利用プランを返す
+
+API キーの保存先が設定されていない場合、ローカル環境では検証を行わず nil を返し、それ以外では 503 を返す
+*/
+func AuthenticateAPIKey(ctx context.Context, key string) (*internal.APIKey, *internal.UsagePlan, error) {
+	if apiKeyStore == nil {
+		if conf.IsLocal() {
+			return nil, nil, nil
+		}
+		Logger(ctx).Error("API_KEY_TABLE_NAME / API_KEY_FILE が設定されていません")
+		return nil, nil, NewError(http.StatusServiceUnavailable, "API キーの保存先が設定されていません")
+	}
+	if key == "" {
+		return nil, nil, NewError(http.StatusUnauthorized, "API キーを指定してください")
+	}
+	apiKey, err := apiKeyStore.GetAPIKey(ctx, HashAPIKey(key))
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return nil, nil, NewError(http.StatusUnauthorized, "無効な API キーです")
+	}
+	if err != nil {
+		Logger(ctx).Error("GetAPIKey failed", "error", err)
+		return nil, nil, err
+	}
+	if apiKey.Disabled {
+		return nil, nil, NewError(http.StatusForbidden, "API キーが無効化されています")
+	}
+	plan := GetUsagePlan(apiKey.Plan)
+	return apiKey, &plan, nil
+}
+
+// コンテキストから API キーを取得する
+func APIKeyFromContext(ctx context.Context) (*internal.APIKey, *internal.UsagePlan) {
+	apiKey, _ := ctx.Value(apiKeyContextKey).(*internal.APIKey)
+	plan, _ := ctx.Value(usagePlanContextKey).(*internal.UsagePlan)
+	return apiKey, plan
+}
+
+// API キー認証ミドルウェア (gin)
+func APIKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey, plan, err := AuthenticateAPIKey(c.Request.Context(), c.GetHeader(apiKeyHeader))
+		if err != nil {
+			ginError(c, err)
+			return
+		}
+		if apiKey != nil {
+			ctx := context.WithValue(c.Request.Context(), apiKeyContextKey, apiKey)
+			ctx = context.WithValue(ctx, usagePlanContextKey, plan)
+			c.Request = c.Request.WithContext(ctx)
+			c.Set("apiKey", apiKey)
+			c.Set("usagePlan", plan)
+		}
+		c.Next()
+	}
+}
+
+// API キー認証ミドルウェア (Lambda)
+func WithAPIKey(next LambdaHandler) LambdaHandler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		// CORS のプリフライトリクエストにはキーが付与されない
+		if req.HTTPMethod == http.MethodOptions {
+			return next(ctx, req)
+		}
+		apiKey, plan, err := AuthenticateAPIKey(ctx, getHeader(req.Headers, apiKeyHeader))
+		if err != nil {
+			return errorResponse(err)
+		}
+		if apiKey != nil {
+			ctx = context.WithValue(ctx, apiKeyContextKey, apiKey)
+			ctx = context.WithValue(ctx, usagePlanContextKey, plan)
+		}
+		return next(ctx, req)
+	}
+}
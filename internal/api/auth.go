@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+var errInvalidRefreshToken = NewError(http.StatusUnauthorized, "無効なリフレッシュトークンです")
+
+// トークンの発行対象
+type tokenSubject struct {
+	UserID   string
+	Username string
+	Role     Role
+}
+
+// アクセストークンの有効期間 (ACCESS_TOKEN_TTL で変更可能)
+func accessTokenTTL() time.Duration {
+	return conf.AccessTokenTTL
+}
+
+// リフレッシュトークンの有効期間 (REFRESH_TOKEN_TTL で変更可能)
+func refreshTokenTTL() time.Duration {
+	return conf.RefreshTokenTTL
+}
+
+func generateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+/*
+アクセストークンとリフレッシュトークンを発行する
+
+familyID が空の場合は新しいトークンファミリーを作成する (ログイン時)
+*/
+func issueTokens(ctx context.Context, subject tokenSubject, familyID string) (*internal.Login, error) {
+	now := time.Now()
+	accessTTL := accessTokenTTL()
+	tokenString, err := tokenSigner.Sign(jwt.MapClaims{
+		"sub":      subject.UserID,
+		"username": subject.Username,
+		"role":     string(subject.Role),
+		"admin":    subject.Role == RoleAdmin,
+		"jti":      uuid.NewString(),
+		"exp":      now.Add(accessTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	if familyID == "" {
+		familyID = uuid.NewString()
+	}
+	err = tokenStore.SaveRefreshToken(ctx, &internal.RefreshToken{
+		ID:        HashAPIKey(refreshToken),
+		FamilyID:  familyID,
+		UserID:    subject.UserID,
+		Username:  subject.Username,
+		Role:      string(subject.Role),
+		ExpiresAt: now.Add(refreshTokenTTL()),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &internal.Login{
+		Username:     subject.Username,
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTTL.Seconds()),
+	}, nil
+}
+
+/*
+リフレッシュトークンをローテーションし、新しいトークンを発行する
+
+使用済みのトークンが再度使われた場合は漏洩とみなし、ファミリー全体を失効させる
+*/
+func RefreshTokenProcessor(ctx context.Context, refreshToken string) (*internal.Login, error) {
+	if refreshToken == "" {
+		return nil, NewError(http.StatusBadRequest, "リフレッシュトークンを指定してください")
+	}
+	id := HashAPIKey(refreshToken)
+	stored, err := tokenStore.GetRefreshToken(ctx, id)
+	if errors.Is(err, ErrTokenNotFound) {
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+	revoked, err := tokenStore.IsTokenFamilyRevoked(ctx, stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errInvalidRefreshToken
+	}
+	// パスワードの再設定などでユーザーのトークンを全て失効させた場合
+	revokedAt, err := tokenStore.UserTokensRevokedAt(ctx, stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !stored.CreatedAt.After(revokedAt) {
+		return nil, errInvalidRefreshToken
+	}
+
+	err = tokenStore.MarkRefreshTokenUsed(ctx, id)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		Logger(ctx).Warn("リフレッシュトークンの再利用を検知しました", "familyId", stored.FamilyID)
+		revokeErr := tokenStore.RevokeTokenFamily(ctx, stored.FamilyID, time.Now().Add(refreshTokenTTL()))
+		if revokeErr != nil {
+			return nil, revokeErr
+		}
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 権限の変更を反映するため、ユーザーを再取得してからトークンを発行する
+	user, err := userRepository.GetUserByID(ctx, stored.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	subject := tokenSubject{UserID: user.ID, Username: user.Name, Role: UserRole(user)}
+	return issueTokens(ctx, subject, stored.FamilyID)
+}
+
+/*
+ログアウト
+
+リフレッシュトークンのファミリーを失効させ、アクセストークンが指定されていれば jti を失効リストに追加する
+*/
+func LogoutProcessor(ctx context.Context, refreshToken string, authHeader string) error {
+	if refreshToken == "" {
+		return NewError(http.StatusBadRequest, "リフレッシュトークンを指定してください")
+	}
+	stored, err := tokenStore.GetRefreshToken(ctx, HashAPIKey(refreshToken))
+	if errors.Is(err, ErrTokenNotFound) {
+		return errInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+	err = tokenStore.RevokeTokenFamily(ctx, stored.FamilyID, time.Now().Add(refreshTokenTTL()))
+	if err != nil {
+		return err
+	}
+
+	if authHeader == "" {
+		return nil
+	}
+	claims, err := AuthenticateToken(ctx, authHeader)
+	if err != nil {
+		// アクセストークンが既に無効な場合は失効させる必要がない
+		return nil
+	}
+	return revokeAccessToken(ctx, claims)
+}
+
+// アクセストークンを有効期限まで失効リストに追加する
+func revokeAccessToken(ctx context.Context, claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil
+	}
+	expiresAt := time.Now().Add(accessTokenTTL())
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+	return tokenStore.RevokeAccessToken(ctx, jti, expiresAt)
+}
+
+// アクセストークンが失効していないか確認する
+func checkTokenRevoked(ctx context.Context, claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	if jti == "" || tokenStore == nil {
+		return nil
+	}
+	revoked, err := tokenStore.IsAccessTokenRevoked(ctx, jti)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return NewError(http.StatusUnauthorized, "Token revoked")
+	}
+	return nil
+}
+
+// JWT認証ミドルウェア (Lambda)
+func WithAuth(next LambdaHandler) LambdaHandler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		claims, err := AuthenticateToken(ctx, getHeader(req.Headers, "Authorization"))
+		if err != nil {
+			return errorResponse(err)
+		}
+		ctx = context.WithValue(ctx, claimsContextKey, claims)
+		return next(ctx, req)
+	}
+}
+
+// アクセストークンのユーザー ID (sub) を取得する
+func UserIDFromContext(ctx context.Context) string {
+	userID, _ := ClaimsFromContext(ctx)["sub"].(string)
+	return userID
+}
+
+func RefreshTokenGin(c *gin.Context) {
+	var reqBody internal.RefreshTokenBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	result, err := RefreshTokenProcessor(c.Request.Context(), reqBody.RefreshToken)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func LogoutGin(c *gin.Context) {
+	var reqBody internal.RefreshTokenBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	err := LogoutProcessor(c.Request.Context(), reqBody.RefreshToken, c.GetHeader("Authorization"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "ログアウトしました")
+}
+
+func RefreshToken(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var reqBody internal.RefreshTokenBody
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+	}
+	result, err := RefreshTokenProcessor(ctx, reqBody.RefreshToken)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, result)
+}
+
+func Logout(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var reqBody internal.RefreshTokenBody
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+	}
+	err := LogoutProcessor(ctx, reqBody.RefreshToken, getHeader(req.Headers, "Authorization"))
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, "ログアウトしました")
+}
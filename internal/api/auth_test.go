@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+// 失効リストの確認に失敗するトークンの保存先
+type failingTokenStore struct {
+	*MemoryTokenStore
+}
+
+func (s *failingTokenStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return false, errors.New("dynamodb: connection reset by peer")
+}
+
+// HS256 で署名・検証する
+func useTestTokens(t *testing.T, store TokenStore) {
+	t.Helper()
+	secret := []byte("test-secret")
+	prevSigner, prevVerifier, prevStore := tokenSigner, tokenVerifier, tokenStore
+	tokenSigner = &TokenSigner{Method: jwt.SigningMethodHS256, Key: secret}
+	tokenVerifier = &TokenVerifier{HMACSecret: secret}
+	tokenStore = store
+	t.Cleanup(func() { tokenSigner, tokenVerifier, tokenStore = prevSigner, prevVerifier, prevStore })
+}
+
+func testAccessToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	token, err := tokenSigner.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+func TestWithAuthMasksInternalError(t *testing.T) {
+	useTestTokens(t, &failingTokenStore{NewMemoryTokenStore()})
+	authHeader := testAccessToken(t, jwt.MapClaims{"sub": "user-1", "jti": "jti-1"})
+
+	next := func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		t.Error("認証に失敗したリクエストを処理しました")
+		return events.APIGatewayProxyResponse{}, nil
+	}
+	res, _ := WithAuth(next)(context.Background(), events.APIGatewayProxyRequest{Headers: map[string]string{"Authorization": authHeader}})
+	var body internal.Error
+	if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusInternalServerError || body.Message != "Error" {
+		t.Errorf("status = %d, body = %s", res.StatusCode, res.Body)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", authHeader)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusInternalServerError || body.Message != "Error" {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body)
+	}
+}
+
+func TestAuthenticateTokenRequiresSubject(t *testing.T) {
+	useTestTokens(t, NewMemoryTokenStore())
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   int
+	}{
+		{"sub あり", jwt.MapClaims{"sub": "user-1"}, 0},
+		{"sub なし", jwt.MapClaims{"username": "user"}, http.StatusUnauthorized},
+		{"sub が空", jwt.MapClaims{"sub": ""}, http.StatusUnauthorized},
+		{"sub が文字列でない", jwt.MapClaims{"sub": 1}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AuthenticateToken(context.Background(), testAccessToken(t, tt.claims))
+			if tt.want == 0 {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			if errorStatus(err) != tt.want {
+				t.Errorf("err = %v, want status %d", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package api
 
-import "regexp"
+import (
+	"regexp"
+
+	"github.com/joe-black-jb/compass-api/internal"
+)
 
 /*
 正常系
@@ -17,3 +21,13 @@ var OnlyNumRe *regexp.Regexp = regexp.MustCompile(`\d+`)
 
 // ※1 などを除外するためのパターン
 var AsteriskAndHalfWidthNumRe *regexp.Regexp = regexp.MustCompile(`※\d+`)
+
+// API キーの利用プラン
+var UsagePlans = map[string]internal.UsagePlan{
+	"free":     {Name: "free", RequestsPerSecond: 1, Burst: 5},
+	"standard": {Name: "standard", RequestsPerSecond: 5, Burst: 20},
+	"partner":  {Name: "partner", RequestsPerSecond: 20, Burst: 50},
+}
+
+// プラン未設定のキーに適用するプラン
+var DefaultUsagePlan = "free"
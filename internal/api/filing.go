@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+// 提出書類の原本の形式
+const (
+	FilingFormatXBRL = "xbrl"
+	FilingFormatPDF  = "pdf"
+)
+
+// 原本の返し方 (download)
+const (
+	// API が中身を返す (HTTP サーバーのデフォルト)
+	filingDownloadStream = "stream"
+	// 署名付き URL にリダイレクトする (Lambda のデフォルト、レスポンスサイズの上限があるため)
+	filingDownloadRedirect = "redirect"
+)
+
+var (
+	// 書類管理番号 (例: S100ABCD)
+	docIDPattern = regexp.MustCompile(`^[A-Z0-9]{8}$`)
+	// 提出日 (YYYYMMDD)
+	filingDatePattern = regexp.MustCompile(`^[0-9]{8}$`)
+)
+
+// 原本の保存場所の一覧のキー
+func FilingIndexKey(docID string) string {
+	return fmt.Sprintf("filings/%s.json", docID)
+}
+
+// PDF のキー
+func FilingPDFKey(dateKey string, docID string) string {
+	return fmt.Sprintf("%s/%s/%s.pdf", dateKey, docID, docID)
+}
+
+// {dateKey}/{docID}/ 配下のファイルから原本の保存場所を決める (一覧の登録前に保存された書類用)
+func filingFromKeys(docID string, dateKey string, keys []string) *internal.Filing {
+	filing := &internal.Filing{DocID: docID, DateKey: dateKey}
+	for _, key := range keys {
+		switch path.Ext(key) {
+		case ".xbrl":
+			if filing.XBRLKey == "" {
+				filing.XBRLKey = key
+			}
+		case ".pdf":
+			filing.PDFKey = key
+		}
+	}
+	if filing.XBRLKey == "" && filing.PDFKey == "" {
+		return nil
+	}
+	return filing
+}
+
+/*
+書類管理番号から原本の保存場所を探す
+
+書類バッチが登録した filings/{docID}.json を使う。ない場合は date (提出日 YYYYMMDD) を指定すれば {date}/{docID}/ 配下から探す
+*/
+func findFiling(ctx context.Context, docID string, date string) (*internal.Filing, error) {
+	body, err := filingStore.GetObject(ctx, FilingIndexKey(docID))
+	if err == nil {
+		var filing internal.Filing
+		if err := json.Unmarshal(body, &filing); err != nil {
+			Logger(ctx).Error("unmarshal filing index failed", "docId", docID, "error", err)
+			return nil, err
+		}
+		return &filing, nil
+	}
+	if !errors.Is(err, ErrObjectNotFound) {
+		Logger(ctx).Error("get filing index failed", "docId", docID, "error", err)
+		return nil, err
+	}
+	if date == "" {
+		return nil, nil
+	}
+
+	keys, err := filingStore.ListObjects(ctx, fmt.Sprintf("%s/%s/", date, docID))
+	if err != nil {
+		Logger(ctx).Error("list filing objects failed", "docId", docID, "date", date, "error", err)
+		return nil, err
+	}
+	return filingFromKeys(docID, date, keys), nil
+}
+
+// 原本のキーを返す
+func filingKey(ctx context.Context, docID string, format string, date string) (string, error) {
+	if filingStore == nil {
+		return "", NewError(http.StatusServiceUnavailable, "原本の保存先が設定されていません")
+	}
+	if !docIDPattern.MatchString(docID) {
+		return "", NewError(http.StatusBadRequest, "書類管理番号が正しくありません")
+	}
+	if date != "" && !filingDatePattern.MatchString(date) {
+		return "", NewError(http.StatusBadRequest, "date は YYYYMMDD 形式で指定してください")
+	}
+	filing, err := findFiling(ctx, docID, date)
+	if err != nil {
+		return "", err
+	}
+	if filing == nil {
+		return "", NewError(http.StatusNotFound, "書類が見つかりません")
+	}
+	key := filing.XBRLKey
+	if format == FilingFormatPDF {
+		key = filing.PDFKey
+	}
+	if key == "" {
+		return "", NewError(http.StatusNotFound, strings.ToUpper(format)+" が見つかりません")
+	}
+	return key, nil
+}
+
+func validateFilingDownload(download string) error {
+	switch download {
+	case "", filingDownloadStream, filingDownloadRedirect:
+		return nil
+	}
+	return NewError(http.StatusBadRequest, "download には stream または redirect を指定してください")
+}
+
+// 原本の署名付き URL を返す (FILING_URL_TTL の間だけ有効)
+func GetFilingURLProcessor(ctx context.Context, docID string, format string, date string) (string, error) {
+	key, err := filingKey(ctx, docID, format, date)
+	if err != nil {
+		return "", err
+	}
+	presigner, ok := filingStore.(ObjectPresigner)
+	if !ok {
+		return "", NewError(http.StatusBadRequest, "この保存先では download=redirect は使えません")
+	}
+	url, err := presigner.PresignGetObject(ctx, key, conf.FilingURLTTL)
+	if err != nil {
+		Logger(ctx).Error("presign filing failed", "docId", docID, "key", key, "error", err)
+		return "", err
+	}
+	return url, nil
+}
+
+// 原本を開く (Body は呼び出し側で閉じる)。ファイル名も返す
+func OpenFilingProcessor(ctx context.Context, docID string, format string, date string) (*Object, string, error) {
+	key, err := filingKey(ctx, docID, format, date)
+	if err != nil {
+		return nil, "", err
+	}
+	object, err := filingStore.OpenObject(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, "", NewError(http.StatusNotFound, "書類が見つかりません")
+	}
+	if err != nil {
+		Logger(ctx).Error("open filing failed", "docId", docID, "key", key, "error", err)
+		return nil, "", err
+	}
+	return object, path.Base(key), nil
+}
+
+// 原本のレスポンスヘッダー (PDF はブラウザで表示し、XBRL はダウンロードさせる)
+func filingHeaders(object *Object, format string, fileName string) map[string]string {
+	disposition := "attachment"
+	if format == FilingFormatPDF {
+		disposition = "inline"
+	}
+	return map[string]string{
+		"Content-Type":           object.ContentType,
+		"Content-Disposition":    fmt.Sprintf("%s; filename=%q", disposition, fileName),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, max-age=3600",
+	}
+}
+
+func getFilingGin(c *gin.Context, format string) {
+	docID := c.Param("docID")
+	date := c.Query("date")
+	download := c.Query("download")
+	if err := validateFilingDownload(download); err != nil {
+		ginError(c, err)
+		return
+	}
+	if download == filingDownloadRedirect {
+		url, err := GetFilingURLProcessor(c.Request.Context(), docID, format, date)
+		if err != nil {
+			ginError(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+
+	object, fileName, err := OpenFilingProcessor(c.Request.Context(), docID, format, date)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	defer object.Body.Close()
+	headers := filingHeaders(object, format, fileName)
+	delete(headers, "Content-Type")
+	c.DataFromReader(http.StatusOK, object.Size, object.ContentType, object.Body, headers)
+}
+
+func GetFilingXBRLGin(c *gin.Context) {
+	getFilingGin(c, FilingFormatXBRL)
+}
+
+func GetFilingPDFGin(c *gin.Context) {
+	getFilingGin(c, FilingFormatPDF)
+}
+
+// 原本を返す (Lambda)。download=stream の場合は中身を返すが、Lambda のレスポンスサイズの上限を超える書類は取得できない
+func GetFiling(ctx context.Context, docID string, format string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	date := req.QueryStringParameters["date"]
+	download := req.QueryStringParameters["download"]
+	if err := validateFilingDownload(download); err != nil {
+		return errorResponse(err)
+	}
+	if download != filingDownloadStream {
+		url, err := GetFilingURLProcessor(ctx, docID, format, date)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusFound,
+			Headers:    map[string]string{"Location": url},
+		}, nil
+	}
+
+	object, fileName, err := OpenFilingProcessor(ctx, docID, format, date)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer object.Body.Close()
+	body, err := io.ReadAll(object.Body)
+	if err != nil {
+		Logger(ctx).Error("read filing failed", "docId", docID, "error", err)
+		return errorResponse(err)
+	}
+	headers := filingHeaders(object, format, fileName)
+	headers["Content-Length"] = strconv.Itoa(len(body))
+	if isTextContentType(object.ContentType) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Headers: headers, Body: string(body)}, nil
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode:      http.StatusOK,
+		Headers:         headers,
+		Body:            base64.StdEncoding.EncodeToString(body),
+		IsBase64Encoded: true,
+	}, nil
+}
+
+// filings/{docID}/... のルーティング (xbrl / pdf)
+func Filings(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	segments := strings.Split(strings.Trim(req.PathParameters["path"], "/"), "/")
+
+	if len(segments) == 3 && req.HTTPMethod == http.MethodGet {
+		switch segments[2] {
+		case FilingFormatXBRL, FilingFormatPDF:
+			return GetFiling(ctx, segments[1], segments[2], req)
+		}
+	}
+	return errorResponse(NewError(http.StatusNotFound, "Not Found"))
+}
@@ -3,9 +3,9 @@ package api
 import (
 	"fmt"
 	"net/http"
-	"os"
 
 	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
 )
 
 func GetCompaniesGin(c *gin.Context) {
@@ -39,6 +39,21 @@ func GetReportsGin(c *gin.Context) {
 	EDINETCode := c.Query("EDINETCode")
 	reportType := c.Query("reportType")
 	extension := c.Query("extension")
+	mode := c.Query("mode")
+
+	if err := validateReportMode(mode); err != nil {
+		ginError(c, err)
+		return
+	}
+	if mode == reportModeURL {
+		links, err := GetReportLinksProcessor(c.Request.Context(), EDINETCode, reportType, extension)
+		if err != nil {
+			ginError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, links)
+		return
+	}
 
 	reportData, err := GetReportsProcessor(EDINETCode, reportType, extension)
 	if err != nil {
@@ -49,7 +64,7 @@ func GetReportsGin(c *gin.Context) {
 
 func GetFundamentalsGin(c *gin.Context) {
 	EDINETCode := c.Query("EDINETCode")
-	bucketName := os.Getenv("BUCKET_NAME")
+	bucketName := conf.BucketName
 	// プレフィックス (ディレクトリのようなもの)
 	prefix := fmt.Sprintf("%s/Fundamentals", EDINETCode)
 	fundamentals, err := GetFundamentalsProcessor(EDINETCode, bucketName, prefix)
@@ -59,12 +74,30 @@ func GetFundamentalsGin(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, fundamentals)
 }
 
-func GetLatestNewsGin(c *gin.Context) {
-	newsBucketName := os.Getenv("NEWS_BUCKET_NAME")
+func RegisterUserGin(c *gin.Context) {
+	var reqBody internal.RegisterUserBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	err := RegisterUserProcessor(c.Request.Context(), reqBody)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "ユーザ登録に成功しました")
+}
 
-	result, err := GetLatestNewsProcessor(newsBucketName, latestFileKey)
+func LoginGin(c *gin.Context) {
+	var reqBody internal.Credentials
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	loginResult, err := LoginProcessor(c.Request.Context(), reqBody, c.ClientIP())
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+		ginError(c, err)
+		return
 	}
-	c.IndentedJSON(http.StatusOK, result)
+	c.JSON(http.StatusOK, loginResult)
 }
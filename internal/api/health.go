@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+// 依存先ごとの確認結果 (/readyz は認証なしで公開するため、エラーの内容は含めずログにのみ出力する)
+type DependencyStatus struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// /readyz のレスポンス
+type ReadinessResult struct {
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks"`
+}
+
+// 依存先の確認 (エラーを返した場合は利用不可)
+type readinessCheck func(ctx context.Context) error
+
+func readinessChecks() map[string]readinessCheck {
+	return map[string]readinessCheck{
+		"config":          checkConfig,
+		"companies_table": checkCompaniesTable,
+		"reports_bucket":  checkReportsBucket,
+		"news_bucket":     checkNewsBucket,
+	}
+}
+
+// 必須の設定が揃っているか
+func checkConfig(ctx context.Context) error {
+	return conf.ValidateAPI()
+}
+
+func checkCompaniesTable(ctx context.Context) error {
+	_, err := dynamoClient.Scan(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(conf.CompaniesTableName),
+		Limit:     aws.Int32(1),
+	})
+	return err
+}
+
+func checkReportsBucket(ctx context.Context) error {
+	_, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(conf.BucketName),
+	})
+	return err
+}
+
+func checkNewsBucket(ctx context.Context) error {
+	if dir := conf.NewsLocalDir; dir != "" {
+		_, err := os.Stat(dir)
+		return err
+	}
+	_, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(conf.NewsBucketName),
+	})
+	return err
+}
+
+/*
+/readyz の結果を READINESS_CACHE_TTL (デフォルト 5s) の間キャッシュする
+
+/readyz は認証・レート制限の対象外のため、リクエストのたびに DynamoDB・S3 を呼び出さないようにする
+同時のリクエストは 1 件の確認の結果を待つ
+*/
+type readinessCache struct {
+	mu        sync.Mutex
+	check     func(ctx context.Context) ReadinessResult
+	result    ReadinessResult
+	checkedAt time.Time
+}
+
+func (c *readinessCache) get(ctx context.Context, ttl time.Duration) ReadinessResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < ttl {
+		return c.result
+	}
+	// 最初のリクエストが切断されても、他のリクエストに返す結果は最後まで確認する
+	c.result = c.check(context.WithoutCancel(ctx))
+	c.checkedAt = time.Now()
+	return c.result
+}
+
+var readiness = &readinessCache{check: checkReadiness}
+
+func ReadinessProcessor(ctx context.Context) ReadinessResult {
+	return readiness.get(ctx, conf.ReadinessCacheTTL)
+}
+
+/*
+依存先を並行して確認する
+
+READINESS_TIMEOUT (デフォルト 3s) 以内に応答しない依存先は利用不可とする
+*/
+func checkReadiness(ctx context.Context) ReadinessResult {
+	ctx, cancel := context.WithTimeout(ctx, conf.ReadinessTimeout)
+	defer cancel()
+
+	result := ReadinessResult{Status: healthStatusOK, Checks: map[string]DependencyStatus{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range readinessChecks() {
+		wg.Add(1)
+		go func(name string, check readinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			status := DependencyStatus{Status: healthStatusOK, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = healthStatusUnavailable
+				Logger(ctx).Warn("readiness check failed", "check", name, "error", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			result.Checks[name] = status
+			if err != nil {
+				result.Status = healthStatusUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	return result
+}
+
+func readinessStatusCode(result ReadinessResult) int {
+	if result.Status != healthStatusOK {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+func HealthzGin(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": healthStatusOK})
+}
+
+func ReadyzGin(c *gin.Context) {
+	result := ReadinessProcessor(c.Request.Context())
+	c.JSON(readinessStatusCode(result), result)
+}
+
+func Healthz(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return jsonResponse(http.StatusOK, map[string]string{"status": healthStatusOK})
+}
+
+func Readyz(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	result := ReadinessProcessor(ctx)
+	return jsonResponse(readinessStatusCode(result), result)
+}
+
+// ヘルスチェック用のルーティング (API キー認証・レート制限の前に処理する)
+func WithHealthCheck(next LambdaHandler) LambdaHandler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		switch req.PathParameters["path"] {
+		case "healthz":
+			return Healthz(ctx, req)
+		case "readyz":
+			return Readyz(ctx, req)
+		}
+		return next(ctx, req)
+	}
+}
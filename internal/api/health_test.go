@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadinessCache(t *testing.T) {
+	var checks atomic.Int32
+	cache := &readinessCache{check: func(ctx context.Context) ReadinessResult {
+		checks.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return ReadinessResult{Status: healthStatusOK}
+	}}
+
+	// 同時のリクエストは 1 件の確認の結果を使う
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result := cache.get(context.Background(), time.Minute); result.Status != healthStatusOK {
+				t.Errorf("result = %+v", result)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := checks.Load(); got != 1 {
+		t.Errorf("依存先を %d 回確認しました, want 1", got)
+	}
+
+	// 有効期限が切れた後は再度確認する
+	cache.get(context.Background(), 0)
+	if got := checks.Load(); got != 2 {
+		t.Errorf("依存先を %d 回確認しました, want 2", got)
+	}
+}
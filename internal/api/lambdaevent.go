@@ -0,0 +1,354 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Lambda の呼び出し元
+const (
+	lambdaEventRESTAPI     = "rest_api"
+	lambdaEventHTTPAPI     = "http_api"
+	lambdaEventALB         = "alb"
+	lambdaEventFunctionURL = "function_url"
+)
+
+var errUnsupportedLambdaEvent = errors.New("unsupported lambda event")
+
+// イベントの種類の判定に使う項目
+type lambdaEventProbe struct {
+	Version        string `json:"version"`
+	HTTPMethod     string `json:"httpMethod"`
+	RequestContext struct {
+		ELB        json.RawMessage `json:"elb"`
+		HTTP       json.RawMessage `json:"http"`
+		DomainName string          `json:"domainName"`
+	} `json:"requestContext"`
+}
+
+// イベントの種類を判定する (REST API / HTTP API (v2) / ALB / Function URL)
+func detectLambdaEvent(payload []byte) (string, error) {
+	var probe lambdaEventProbe
+	err := json.Unmarshal(payload, &probe)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnsupportedLambdaEvent, err)
+	}
+	switch {
+	case len(probe.RequestContext.ELB) > 0:
+		return lambdaEventALB, nil
+	case probe.Version == "2.0" && len(probe.RequestContext.HTTP) > 0:
+		// Function URL のドメインは <url-id>.lambda-url.<region>.on.aws
+		if strings.Contains(probe.RequestContext.DomainName, ".lambda-url.") {
+			return lambdaEventFunctionURL, nil
+		}
+		return lambdaEventHTTPAPI, nil
+	case probe.HTTPMethod != "":
+		return lambdaEventRESTAPI, nil
+	}
+	return "", errUnsupportedLambdaEvent
+}
+
+/*
+API Gateway (REST API) 以外のイベントを REST API のイベントに変換してハンドラーを呼び出すアダプター
+
+	API Gateway REST API (v1):     そのまま渡す
+	API Gateway HTTP API (v2):     APIGatewayV2HTTPRequest / APIGatewayV2HTTPResponse
+	ALB のターゲットグループ:       ALBTargetGroupRequest / ALBTargetGroupResponse
+	Lambda の関数 URL:             LambdaFunctionURLRequest / LambdaFunctionURLResponse
+
+ハンドラーが参照する PathParameters["path"] には先頭の / を除いたパスを設定する
+*/
+func WithLambdaEventAdapter(next LambdaHandler) func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+		eventType, err := detectLambdaEvent(payload)
+		if err != nil {
+			return nil, err
+		}
+		switch eventType {
+		case lambdaEventHTTPAPI:
+			var req events.APIGatewayV2HTTPRequest
+			if err := json.Unmarshal(payload, &req); err != nil {
+				return nil, err
+			}
+			res, err := next(ctx, fromHTTPAPIRequest(req))
+			return toHTTPAPIResponse(res), err
+		case lambdaEventFunctionURL:
+			var req events.LambdaFunctionURLRequest
+			if err := json.Unmarshal(payload, &req); err != nil {
+				return nil, err
+			}
+			res, err := next(ctx, fromFunctionURLRequest(req))
+			return toFunctionURLResponse(res), err
+		case lambdaEventALB:
+			var req events.ALBTargetGroupRequest
+			if err := json.Unmarshal(payload, &req); err != nil {
+				return nil, err
+			}
+			res, err := next(ctx, fromALBRequest(req))
+			// マルチバリューヘッダーが有効なターゲットグループには multiValueHeaders で返す
+			return toALBResponse(res, req.MultiValueHeaders != nil), err
+		}
+		var req events.APIGatewayProxyRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+// ハンドラーのルーティングに使うパス (先頭の / を除く)
+func routePath(path string) string {
+	return strings.Trim(path, "/")
+}
+
+// v2 のクエリ (同じキーはカンマ区切り) をマルチバリューに変換する
+func multiValueFromRawQuery(rawQuery string) map[string][]string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// クッキーの配列を Cookie ヘッダーとしてハンドラーに渡す
+func withCookieHeader(headers map[string]string, cookies []string) map[string]string {
+	if len(cookies) == 0 {
+		return headers
+	}
+	result := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		result[k] = v
+	}
+	result["cookie"] = strings.Join(cookies, "; ")
+	return result
+}
+
+func fromHTTPAPIRequest(req events.APIGatewayV2HTTPRequest) events.APIGatewayProxyRequest {
+	// {proxy+} のルートはパスパラメーター、$default 以外のステージでは rawPath の先頭にステージ名が付く
+	path := req.PathParameters["proxy"]
+	if path == "" {
+		path = req.RawPath
+		if stage := req.RequestContext.Stage; stage != "" && stage != "$default" {
+			path = strings.TrimPrefix(path, "/"+stage)
+		}
+	}
+	pathParameters := map[string]string{}
+	for k, v := range req.PathParameters {
+		pathParameters[k] = v
+	}
+	pathParameters["path"] = routePath(path)
+
+	return events.APIGatewayProxyRequest{
+		Resource:                        req.RouteKey,
+		Path:                            req.RawPath,
+		HTTPMethod:                      req.RequestContext.HTTP.Method,
+		Headers:                         withCookieHeader(req.Headers, req.Cookies),
+		QueryStringParameters:           req.QueryStringParameters,
+		MultiValueQueryStringParameters: multiValueFromRawQuery(req.RawQueryString),
+		PathParameters:                  pathParameters,
+		StageVariables:                  req.StageVariables,
+		Body:                            req.Body,
+		IsBase64Encoded:                 req.IsBase64Encoded,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			AccountID:  req.RequestContext.AccountID,
+			APIID:      req.RequestContext.APIID,
+			DomainName: req.RequestContext.DomainName,
+			Stage:      req.RequestContext.Stage,
+			RequestID:  req.RequestContext.RequestID,
+			HTTPMethod: req.RequestContext.HTTP.Method,
+			Path:       req.RequestContext.HTTP.Path,
+			Protocol:   req.RequestContext.HTTP.Protocol,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  req.RequestContext.HTTP.SourceIP,
+				UserAgent: req.RequestContext.HTTP.UserAgent,
+			},
+		},
+	}
+}
+
+func fromFunctionURLRequest(req events.LambdaFunctionURLRequest) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		Path:                            req.RawPath,
+		HTTPMethod:                      req.RequestContext.HTTP.Method,
+		Headers:                         withCookieHeader(req.Headers, req.Cookies),
+		QueryStringParameters:           req.QueryStringParameters,
+		MultiValueQueryStringParameters: multiValueFromRawQuery(req.RawQueryString),
+		PathParameters:                  map[string]string{"path": routePath(req.RawPath)},
+		Body:                            req.Body,
+		IsBase64Encoded:                 req.IsBase64Encoded,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			AccountID:  req.RequestContext.AccountID,
+			APIID:      req.RequestContext.APIID,
+			DomainName: req.RequestContext.DomainName,
+			RequestID:  req.RequestContext.RequestID,
+			HTTPMethod: req.RequestContext.HTTP.Method,
+			Path:       req.RequestContext.HTTP.Path,
+			Protocol:   req.RequestContext.HTTP.Protocol,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  req.RequestContext.HTTP.SourceIP,
+				UserAgent: req.RequestContext.HTTP.UserAgent,
+			},
+		},
+	}
+}
+
+// ALB はクエリをデコードせずに渡す
+func unescapeQuery(value string) string {
+	if unescaped, err := url.QueryUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
+}
+
+/*
+ALB のリクエストのクライアント IP
+
+ALB は接続元の IP を X-Forwarded-For の末尾に追加する。それより前の値はクライアントが送ったもの (偽装できる) のため使わない
+*/
+func albClientIP(headers map[string]string) string {
+	forwarded := strings.Split(getHeader(headers, "X-Forwarded-For"), ",")
+	return strings.TrimSpace(forwarded[len(forwarded)-1])
+}
+
+func fromALBRequest(req events.ALBTargetGroupRequest) events.APIGatewayProxyRequest {
+	headers := req.Headers
+	if headers == nil && req.MultiValueHeaders != nil {
+		// マルチバリューヘッダーが有効な場合は最後の値を使う (API Gateway と同じ)
+		headers = map[string]string{}
+		for k, values := range req.MultiValueHeaders {
+			if len(values) > 0 {
+				headers[k] = values[len(values)-1]
+			}
+		}
+	}
+
+	var query map[string]string
+	var multiValueQuery map[string][]string
+	if req.MultiValueQueryStringParameters != nil {
+		query = map[string]string{}
+		multiValueQuery = map[string][]string{}
+		for k, values := range req.MultiValueQueryStringParameters {
+			key := unescapeQuery(k)
+			for _, v := range values {
+				multiValueQuery[key] = append(multiValueQuery[key], unescapeQuery(v))
+			}
+			if len(values) > 0 {
+				query[key] = unescapeQuery(values[len(values)-1])
+			}
+		}
+	} else if req.QueryStringParameters != nil {
+		query = map[string]string{}
+		multiValueQuery = map[string][]string{}
+		for k, v := range req.QueryStringParameters {
+			key, value := unescapeQuery(k), unescapeQuery(v)
+			query[key] = value
+			multiValueQuery[key] = []string{value}
+		}
+	}
+
+	sourceIP := albClientIP(headers)
+
+	return events.APIGatewayProxyRequest{
+		Path:                            req.Path,
+		HTTPMethod:                      req.HTTPMethod,
+		Headers:                         headers,
+		MultiValueHeaders:               req.MultiValueHeaders,
+		QueryStringParameters:           query,
+		MultiValueQueryStringParameters: multiValueQuery,
+		PathParameters:                  map[string]string{"path": routePath(req.Path)},
+		Body:                            req.Body,
+		IsBase64Encoded:                 req.IsBase64Encoded,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			HTTPMethod: req.HTTPMethod,
+			Path:       req.Path,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  sourceIP,
+				UserAgent: getHeader(headers, "User-Agent"),
+			},
+		},
+	}
+}
+
+// ヘッダーを Set-Cookie (v2 / Function URL はクッキーの配列で返す) とそれ以外に分ける
+func splitResponseCookies(res events.APIGatewayProxyResponse) (map[string]string, []string) {
+	headers := map[string]string{}
+	var cookies []string
+	for k, v := range res.Headers {
+		if strings.EqualFold(k, "Set-Cookie") {
+			cookies = append(cookies, v)
+			continue
+		}
+		headers[k] = v
+	}
+	for k, values := range res.MultiValueHeaders {
+		if strings.EqualFold(k, "Set-Cookie") {
+			cookies = append(cookies, values...)
+			continue
+		}
+		headers[k] = strings.Join(values, ", ")
+	}
+	return headers, cookies
+}
+
+func toHTTPAPIResponse(res events.APIGatewayProxyResponse) events.APIGatewayV2HTTPResponse {
+	headers, cookies := splitResponseCookies(res)
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode:      res.StatusCode,
+		Headers:         headers,
+		Body:            res.Body,
+		IsBase64Encoded: res.IsBase64Encoded,
+		Cookies:         cookies,
+	}
+}
+
+func toFunctionURLResponse(res events.APIGatewayProxyResponse) events.LambdaFunctionURLResponse {
+	headers, cookies := splitResponseCookies(res)
+	return events.LambdaFunctionURLResponse{
+		StatusCode:      res.StatusCode,
+		Headers:         headers,
+		Body:            res.Body,
+		IsBase64Encoded: res.IsBase64Encoded,
+		Cookies:         cookies,
+	}
+}
+
+func toALBResponse(res events.APIGatewayProxyResponse, multiValue bool) events.ALBTargetGroupResponse {
+	status := res.StatusCode
+	if status == 0 {
+		// ハンドラーがエラーのみ返した場合
+		status = http.StatusInternalServerError
+	}
+	albRes := events.ALBTargetGroupResponse{
+		StatusCode:        status,
+		StatusDescription: fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Body:              res.Body,
+		IsBase64Encoded:   res.IsBase64Encoded,
+	}
+	if !multiValue {
+		albRes.Headers = map[string]string{}
+		for k, v := range res.Headers {
+			albRes.Headers[k] = v
+		}
+		for k, values := range res.MultiValueHeaders {
+			if len(values) > 0 {
+				albRes.Headers[k] = values[len(values)-1]
+			}
+		}
+		return albRes
+	}
+	albRes.MultiValueHeaders = map[string][]string{}
+	for k, v := range res.Headers {
+		albRes.MultiValueHeaders[k] = []string{v}
+	}
+	for k, values := range res.MultiValueHeaders {
+		albRes.MultiValueHeaders[k] = values
+	}
+	return albRes
+}
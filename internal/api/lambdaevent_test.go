@@ -0,0 +1,154 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestDetectLambdaEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{"REST API", `{"httpMethod":"GET","path":"/companies"}`, lambdaEventRESTAPI, false},
+		{"HTTP API", `{"version":"2.0","requestContext":{"domainName":"abc.execute-api.ap-northeast-1.amazonaws.com","http":{"method":"GET"}}}`, lambdaEventHTTPAPI, false},
+		{"関数 URL", `{"version":"2.0","requestContext":{"domainName":"abc.lambda-url.ap-northeast-1.on.aws","http":{"method":"GET"}}}`, lambdaEventFunctionURL, false},
+		{"ALB", `{"httpMethod":"GET","requestContext":{"elb":{"targetGroupArn":"arn"}}}`, lambdaEventALB, false},
+		{"未対応", `{"Records":[]}`, "", true},
+		{"JSON 以外", `not json`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detectLambdaEvent([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromALBRequestSourceIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		forwardedFor   string
+		multiValueOnly bool
+		want           string
+	}{
+		{"ALB が追加した接続元のみ", "203.0.113.10", false, "203.0.113.10"},
+		{"クライアントが送った値は使わない", "1.1.1.1, 203.0.113.10", false, "203.0.113.10"},
+		{"空白を除く", "1.1.1.1,2.2.2.2 ,  203.0.113.10 ", false, "203.0.113.10"},
+		{"マルチバリューヘッダー", "1.1.1.1, 203.0.113.10", true, "203.0.113.10"},
+		{"ヘッダーなし", "", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.ALBTargetGroupRequest{HTTPMethod: "GET", Path: "/companies"}
+			if tt.multiValueOnly {
+				req.MultiValueHeaders = map[string][]string{"x-forwarded-for": {tt.forwardedFor}}
+			} else {
+				req.Headers = map[string]string{"x-forwarded-for": tt.forwardedFor}
+			}
+			got := fromALBRequest(req).RequestContext.Identity.SourceIP
+			if got != tt.want {
+				t.Errorf("SourceIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromALBRequestQuery(t *testing.T) {
+	req := fromALBRequest(events.ALBTargetGroupRequest{
+		HTTPMethod: "GET",
+		Path:       "/search/",
+		MultiValueQueryStringParameters: map[string][]string{
+			"companyName": {"%E3%83%88%E3%83%A8%E3%82%BF"},
+			"tag":         {"a", "b%20c"},
+		},
+	})
+	if got := req.PathParameters["path"]; got != "search" {
+		t.Errorf("path = %q, want %q", got, "search")
+	}
+	if got := req.QueryStringParameters["companyName"]; got != "トヨタ" {
+		t.Errorf("companyName = %q, want %q", got, "トヨタ")
+	}
+	// 同じキーは最後の値 (API Gateway と同じ)
+	if got := req.QueryStringParameters["tag"]; got != "b c" {
+		t.Errorf("tag = %q, want %q", got, "b c")
+	}
+	if got := req.MultiValueQueryStringParameters["tag"]; !reflect.DeepEqual(got, []string{"a", "b c"}) {
+		t.Errorf("multi-value tag = %v", got)
+	}
+}
+
+func TestFromHTTPAPIRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		rawPath        string
+		stage          string
+		pathParameters map[string]string
+		want           string
+	}{
+		{"$default ステージ", "/companies/abc/news", "$default", nil, "companies/abc/news"},
+		{"ステージ名を除く", "/prod/companies/abc", "prod", nil, "companies/abc"},
+		{"{proxy+} のパスパラメーター", "/prod/reports", "prod", map[string]string{"proxy": "reports"}, "reports"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.APIGatewayV2HTTPRequest{
+				Version:        "2.0",
+				RawPath:        tt.rawPath,
+				RawQueryString: "from=2026-10-12&category=a&category=b",
+				Cookies:        []string{"a=1", "b=2"},
+				Headers:        map[string]string{"x-api-key": "key"},
+				PathParameters: tt.pathParameters,
+				RequestContext: events.APIGatewayV2HTTPRequestContext{
+					Stage: tt.stage,
+					HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+						Method:   "GET",
+						SourceIP: "198.51.100.7",
+					},
+				},
+			}
+			got := fromHTTPAPIRequest(req)
+			if got.PathParameters["path"] != tt.want {
+				t.Errorf("path = %q, want %q", got.PathParameters["path"], tt.want)
+			}
+			if got.HTTPMethod != "GET" {
+				t.Errorf("HTTPMethod = %q", got.HTTPMethod)
+			}
+			if got.RequestContext.Identity.SourceIP != "198.51.100.7" {
+				t.Errorf("SourceIP = %q", got.RequestContext.Identity.SourceIP)
+			}
+			if got.Headers["cookie"] != "a=1; b=2" || got.Headers["x-api-key"] != "key" {
+				t.Errorf("Headers = %v", got.Headers)
+			}
+			if !reflect.DeepEqual(got.MultiValueQueryStringParameters["category"], []string{"a", "b"}) {
+				t.Errorf("multi-value category = %v", got.MultiValueQueryStringParameters["category"])
+			}
+		})
+	}
+}
+
+func TestToHTTPAPIResponseCookies(t *testing.T) {
+	res := toHTTPAPIResponse(events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		MultiValueHeaders: map[string][]string{
+			"Set-Cookie": {"a=1; HttpOnly", "b=2; Secure"},
+		},
+		Body: "{}",
+	})
+	if !reflect.DeepEqual(res.Cookies, []string{"a=1; HttpOnly", "b=2; Secure"}) {
+		t.Errorf("Cookies = %v", res.Cookies)
+	}
+	if _, ok := res.MultiValueHeaders["Set-Cookie"]; ok {
+		t.Errorf("Set-Cookie がヘッダーに残っています: %v", res.MultiValueHeaders)
+	}
+}
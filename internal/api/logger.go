@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// クライアントから受け付けるリクエスト ID (ログの改ざんや肥大化を防ぐため、英数字とハイフンの 64 文字まで)
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+const requestIDContextKey contextKey = "requestID"
+
+const redacted = "[REDACTED]"
+
+/*
+ログに出力しないキー (ヘッダー名・属性名、大文字小文字は区別しない)
+
+token / password / secret を含むキーも伏せる
+*/
+var sensitiveLogKeys = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+	"x-api-key":     true,
+}
+
+func isSensitiveLogKey(key string) bool {
+	key = strings.ToLower(key)
+	if sensitiveLogKeys[key] {
+		return true
+	}
+	return strings.Contains(key, "token") || strings.Contains(key, "password") || strings.Contains(key, "secret")
+}
+
+// 秘匿情報を伏せる
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if isSensitiveLogKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// ヘッダー・クエリをログ用に変換する (Authorization やトークンは伏せる)
+func redactValues(values map[string]string) map[string]string {
+	result := make(map[string]string, len(values))
+	for k, v := range values {
+		if isSensitiveLogKey(k) {
+			v = redacted
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// LOG_LEVEL (debug / info / warn / error、デフォルト info)
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// JSON 形式のロガーを作成する
+func NewLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}))
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// リクエスト ID 付きのロガーを返す
+func Logger(ctx context.Context) *slog.Logger {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// リクエスト ID を決める (クライアントの X-Request-Id、API Gateway のリクエスト ID、どちらもなければ生成する)
+func lambdaRequestID(req events.APIGatewayProxyRequest) string {
+	if id := getHeader(req.Headers, requestIDHeader); requestIDPattern.MatchString(id) {
+		return id
+	}
+	if req.RequestContext.RequestID != "" {
+		return req.RequestContext.RequestID
+	}
+	return uuid.NewString()
+}
+
+// リクエスト ID を設定し、リクエストをログに出力するミドルウェア (Lambda)
+func WithRequestLogging(next LambdaHandler) LambdaHandler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		start := time.Now()
+		requestID := lambdaRequestID(req)
+		ctx = ContextWithRequestID(ctx, requestID)
+		logger := Logger(ctx)
+		logger.Debug("request received",
+			"method", req.HTTPMethod,
+			"path", req.Path,
+			"query", redactValues(req.QueryStringParameters),
+			"headers", redactValues(req.Headers),
+		)
+
+		res, err := next(ctx, req)
+		setHeaders(&res, map[string]string{requestIDHeader: requestID})
+
+		status := res.StatusCode
+		if err != nil && status < http.StatusBadRequest {
+			status = http.StatusInternalServerError
+		}
+		attrs := []any{
+			"method", req.HTTPMethod,
+			"path", req.Path,
+			"status", status,
+			"latency_ms", time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			logger.Error("request failed", append(attrs, "error", err)...)
+		} else {
+			logger.Info("request completed", attrs...)
+		}
+		return res, err
+	}
+}
+
+// リクエスト ID を設定し、リクエストをログに出力するミドルウェア (gin)
+func RequestLoggerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		ctx := ContextWithRequestID(c.Request.Context(), requestID)
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(requestIDHeader, requestID)
+
+		c.Next()
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+		Logger(ctx).Info("request completed", attrs...)
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestLambdaRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"UUID", "0b6f2c1e-5d4a-4f7e-9c3b-2a1d0e9f8c7b", "0b6f2c1e-5d4a-4f7e-9c3b-2a1d0e9f8c7b"},
+		{"英数字とハイフン", "abc-123", "abc-123"},
+		{"ヘッダーなし", "", "apigw-id"},
+		{"改行を含む", "abc\n{\"level\":\"ERROR\"}", "apigw-id"},
+		{"記号を含む", "abc def", "apigw-id"},
+		{"64 文字", strings.Repeat("a", 64), strings.Repeat("a", 64)},
+		{"65 文字", strings.Repeat("a", 65), "apigw-id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.APIGatewayProxyRequest{
+				Headers:        map[string]string{"X-Request-Id": tt.header},
+				RequestContext: events.APIGatewayProxyRequestContext{RequestID: "apigw-id"},
+			}
+			if got := lambdaRequestID(req); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+// ログイン失敗の記録の保存先
+type LoginAttemptStore interface {
+	// 記録を取得する (存在しない場合は失敗回数 0 の記録を返す)
+	GetLoginAttempt(ctx context.Context, id string) (*internal.LoginAttempt, error)
+	// 記録を読み込み、update で変更して保存する
+	UpdateLoginAttempt(ctx context.Context, id string, update func(attempt *internal.LoginAttempt)) (*internal.LoginAttempt, error)
+	DeleteLoginAttempt(ctx context.Context, id string) error
+}
+
+// メモリ上でログイン失敗を記録する (ローカル用)
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]internal.LoginAttempt
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: map[string]internal.LoginAttempt{}}
+}
+
+func (s *MemoryLoginAttemptStore) GetLoginAttempt(ctx context.Context, id string) (*internal.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempt, ok := s.attempts[id]
+	if !ok {
+		attempt = internal.LoginAttempt{ID: id}
+	}
+	return &attempt, nil
+}
+
+func (s *MemoryLoginAttemptStore) UpdateLoginAttempt(ctx context.Context, id string, update func(attempt *internal.LoginAttempt)) (*internal.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempt, ok := s.attempts[id]
+	if !ok {
+		attempt = internal.LoginAttempt{ID: id}
+	}
+	update(&attempt)
+	s.attempts[id] = attempt
+	return &attempt, nil
+}
+
+func (s *MemoryLoginAttemptStore) DeleteLoginAttempt(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, id)
+	return nil
+}
+
+/*
+DynamoDB にログイン失敗を記録する
+
+同時に失敗した場合に回数を取りこぼさないよう version 属性で楽観ロックをかける
+
+テーブル: パーティションキー id (S)、TTL 属性 ttl
+*/
+type DynamoLoginAttemptStore struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func (s *DynamoLoginAttemptStore) get(ctx context.Context, id string) (*internal.LoginAttempt, string, error) {
+	output, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.TableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	attempt := internal.LoginAttempt{ID: id}
+	if output.Item == nil {
+		return &attempt, "", nil
+	}
+	err = attributevalue.UnmarshalMap(output.Item, &attempt)
+	if err != nil {
+		return nil, "", err
+	}
+	var version string
+	if v, ok := output.Item["version"].(*types.AttributeValueMemberN); ok {
+		version = v.Value
+	}
+	return &attempt, version, nil
+}
+
+func (s *DynamoLoginAttemptStore) GetLoginAttempt(ctx context.Context, id string) (*internal.LoginAttempt, error) {
+	attempt, _, err := s.get(ctx, id)
+	return attempt, err
+}
+
+func (s *DynamoLoginAttemptStore) UpdateLoginAttempt(ctx context.Context, id string, update func(attempt *internal.LoginAttempt)) (*internal.LoginAttempt, error) {
+	for i := 0; i < 3; i++ {
+		attempt, version, err := s.get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		update(attempt)
+
+		item, err := attributevalue.MarshalMap(attempt)
+		if err != nil {
+			return nil, err
+		}
+		nextVersion := 1
+		if version != "" {
+			current, _ := strconv.Atoi(version)
+			nextVersion = current + 1
+		}
+		item["version"] = &types.AttributeValueMemberN{Value: strconv.Itoa(nextVersion)}
+		expiresAt := attempt.LastFailedAt
+		if attempt.LockedUntil.After(expiresAt) {
+			expiresAt = attempt.LockedUntil
+		}
+		item["ttl"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Add(24*time.Hour).Unix(), 10)}
+
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(s.TableName),
+			Item:      item,
+		}
+		if version == "" {
+			input.ConditionExpression = aws.String("attribute_not_exists(id)")
+		} else {
+			input.ConditionExpression = aws.String("version = :version")
+			input.ExpressionAttributeValues = map[string]types.AttributeValue{
+				":version": &types.AttributeValueMemberN{Value: version},
+			}
+		}
+		_, err = s.Client.PutItem(ctx, input)
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return attempt, nil
+	}
+	return nil, fmt.Errorf("login attempt update conflict: %s", id)
+}
+
+func (s *DynamoLoginAttemptStore) DeleteLoginAttempt(ctx context.Context, id string) error {
+	_, err := s.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	return err
+}
+
+// 設定からログイン失敗の記録の保存先を決める
+func newLoginAttemptStore() LoginAttemptStore {
+	if tableName := conf.LoginAttemptTableName; tableName != "" {
+		return &DynamoLoginAttemptStore{Client: dynamoClient, TableName: tableName}
+	}
+	return NewMemoryLoginAttemptStore()
+}
+
+// ログイン失敗時の制限
+type loginAttemptPolicy struct {
+	MaxFailures int           // ロックまでの失敗回数
+	Window      time.Duration // 失敗回数を数える期間
+	Lockout     time.Duration // ロック期間
+}
+
+// アカウントごとの制限 (LOGIN_MAX_FAILURES, LOGIN_LOCKOUT_DURATION で変更可能)
+func accountLoginPolicy() loginAttemptPolicy {
+	return loginAttemptPolicy{MaxFailures: conf.LoginMaxFailures, Window: 15 * time.Minute, Lockout: conf.LoginLockoutDuration}
+}
+
+// IP ごとの制限 (LOGIN_MAX_IP_FAILURES で変更可能)
+func ipLoginPolicy() loginAttemptPolicy {
+	policy := accountLoginPolicy()
+	policy.MaxFailures = conf.LoginMaxIPFailures
+	return policy
+}
+
+func accountAttemptKey(email string) string {
+	return "account#" + NormalizeEmail(email)
+}
+
+func ipAttemptKey(clientIP string) string {
+	return "ip#" + clientIP
+}
+
+var errLoginLocked = NewError(http.StatusTooManyRequests, "ログインの試行回数が上限を超えました。しばらくしてから再度お試しください")
+
+// 失敗回数に応じた待ち時間 (250ms から倍々に増やし、最大 5 秒)
+func loginFailureDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := 250 * time.Millisecond << min(failures-1, 5)
+	return min(delay, 5*time.Second)
+}
+
+/*
+ログインを試行できるか確認する
+
+アカウントまたは IP がロックされている場合はエラー、そうでなければ失敗回数に応じて待機する
+IP が分からない場合は IP ごとの制限を行わない (全ての該当クライアントが 1 つの記録を共有しないように)
+*/
+func checkLoginAllowed(ctx context.Context, email string, clientIP string) error {
+	now := time.Now()
+	account, err := loginAttemptStore.GetLoginAttempt(ctx, accountAttemptKey(email))
+	if err != nil {
+		return err
+	}
+	if now.Before(account.LockedUntil) {
+		return errLoginLocked
+	}
+	if clientIP != "" {
+		ip, err := loginAttemptStore.GetLoginAttempt(ctx, ipAttemptKey(clientIP))
+		if err != nil {
+			return err
+		}
+		if now.Before(ip.LockedUntil) {
+			return errLoginLocked
+		}
+	}
+	failures := account.Failures
+	if now.Sub(account.LastFailedAt) > accountLoginPolicy().Window {
+		failures = 0
+	}
+	// リクエストが中断された場合は待機をやめる
+	timer := time.NewTimer(loginFailureDelay(failures))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ログイン失敗を記録し、上限に達した場合はロックする
+func recordLoginFailure(ctx context.Context, email string, clientIP string) error {
+	record := func(id string, policy loginAttemptPolicy) error {
+		_, err := loginAttemptStore.UpdateLoginAttempt(ctx, id, func(attempt *internal.LoginAttempt) {
+			now := time.Now()
+			if now.Sub(attempt.LastFailedAt) > policy.Window {
+				attempt.Failures = 0
+			}
+			attempt.Failures++
+			attempt.LastFailedAt = now
+			if attempt.Failures >= policy.MaxFailures {
+				attempt.LockedUntil = now.Add(policy.Lockout)
+				attempt.Failures = 0
+				Logger(ctx).Warn("ログインをロックしました", "attemptId", id, "lockedUntil", attempt.LockedUntil.Format(time.RFC3339))
+			}
+		})
+		return err
+	}
+	err := record(accountAttemptKey(email), accountLoginPolicy())
+	if err != nil || clientIP == "" {
+		return err
+	}
+	return record(ipAttemptKey(clientIP), ipLoginPolicy())
+}
+
+// ログイン成功時にアカウントの失敗記録を削除する
+func resetLoginFailures(ctx context.Context, email string) error {
+	return loginAttemptStore.DeleteLoginAttempt(ctx, accountAttemptKey(email))
+}
+
+/*
+アカウント・IP のロックを解除する (管理者用)
+
+IP ごとの失敗記録はアカウントと別に保存しているため、IP を指定した場合はその記録も削除する
+*/
+func UnlockUserProcessor(ctx context.Context, email string, clientIP string) error {
+	if email == "" && clientIP == "" {
+		return NewError(http.StatusBadRequest, "メールアドレスまたは IP アドレスを指定してください")
+	}
+	if clientIP != "" && net.ParseIP(clientIP) == nil {
+		return NewError(http.StatusBadRequest, "IP アドレスが正しくありません")
+	}
+	if email != "" {
+		err := resetLoginFailures(ctx, email)
+		if err != nil {
+			return err
+		}
+	}
+	if clientIP != "" {
+		return loginAttemptStore.DeleteLoginAttempt(ctx, ipAttemptKey(clientIP))
+	}
+	return nil
+}
+
+func UnlockUserGin(c *gin.Context) {
+	var reqBody internal.UnlockUserBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	err := UnlockUserProcessor(c.Request.Context(), reqBody.Email, reqBody.IP)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "ロックを解除しました")
+}
+
+func UnlockUser(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var reqBody internal.UnlockUserBody
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+	}
+	err := UnlockUserProcessor(ctx, reqBody.Email, reqBody.IP)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, "ロックを解除しました")
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+func useLoginAttemptStore(t *testing.T) *MemoryLoginAttemptStore {
+	t.Helper()
+	store := NewMemoryLoginAttemptStore()
+	prev := loginAttemptStore
+	loginAttemptStore = store
+	t.Cleanup(func() { loginAttemptStore = prev })
+	return store
+}
+
+func TestCheckLoginAllowedStopsWaitingOnCancel(t *testing.T) {
+	useLoginAttemptStore(t)
+	for i := 0; i < 3; i++ {
+		if err := recordLoginFailure(context.Background(), "user@example.com", "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 失敗 3 回で 1 秒待つが、リクエストが中断された時点で戻る
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := checkLoginAllowed(ctx, "user@example.com", "192.0.2.1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("%v 待ちました", elapsed)
+	}
+}
+
+func TestLoginFailuresWithoutClientIP(t *testing.T) {
+	store := useLoginAttemptStore(t)
+	// IP が分からない場合は IP ごとの記録を作らない
+	if err := recordLoginFailure(context.Background(), "user@example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.attempts[ipAttemptKey("")]; ok {
+		t.Error("空の IP の失敗を記録しました")
+	}
+
+	// 既に空の IP がロックされていても、IP の分からない他のクライアントはログインできる
+	store.attempts[ipAttemptKey("")] = internal.LoginAttempt{ID: ipAttemptKey(""), LockedUntil: time.Now().Add(time.Hour)}
+	if err := checkLoginAllowed(context.Background(), "other@example.com", ""); err != nil {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 送信するメール
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// メールの送信先
+type MailSender interface {
+	SendMail(ctx context.Context, mail Mail) error
+}
+
+// SMTP サーバー経由でメールを送信する
+type SMTPMailSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPMailSender) SendMail(ctx context.Context, mail Mail) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	msg := buildMailMessage(s.From, mail)
+	return smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{mail.To}, msg)
+}
+
+func buildMailMessage(from string, mail Mail) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// メールを送信せずに書き出す (開発・テスト用)
+type WriterMailSender struct {
+	mu     sync.Mutex
+	Writer io.Writer
+	From   string
+}
+
+func (s *WriterMailSender) SendMail(ctx context.Context, mail Mail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.Writer, "%s\r\n\r\n", buildMailMessage(s.From, mail))
+	return err
+}
+
+var errMailNotConfigured = errors.New("SMTP_HOST が設定されていないためメールを送信できません")
+
+// メールを送信しない (SMTP_HOST のないバッチ用。API は起動時の設定の検証で SMTP_HOST を必須にしている)
+type disabledMailSender struct{}
+
+func (disabledMailSender) SendMail(ctx context.Context, mail Mail) error {
+	return errMailNotConfigured
+}
+
+/*
+設定からメールの送信先を決める
+
+	SMTP_HOST が設定されている場合    SMTP で送信する
+	MAIL_OUTPUT_FILE が設定されている場合 ファイルに追記する (ローカル環境のみ)
+	いずれもない場合                  標準出力に書き出す (ローカル環境のみ)
+
+ローカル環境以外ではトークン付きのリンクをログなどに書き出さないよう、SMTP でのみ送信する
+*/
+func newMailSender() (MailSender, error) {
+	from := conf.MailFrom
+	if host := conf.SMTPHost; host != "" {
+		return &SMTPMailSender{
+			Host:     host,
+			Port:     conf.SMTPPort,
+			Username: conf.SMTPUsername,
+			Password: conf.SMTPPassword,
+			From:     from,
+		}, nil
+	}
+	if !conf.IsLocal() {
+		return disabledMailSender{}, nil
+	}
+	if path := conf.MailOutputFile; path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return &WriterMailSender{Writer: file, From: from}, nil
+	}
+	return &WriterMailSender{Writer: os.Stdout, From: from}, nil
+}
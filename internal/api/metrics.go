@@ -0,0 +1,444 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// レイテンシのヒストグラムのバケット (秒)
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestLabels struct {
+	Route  string
+	Method string
+	Status string
+}
+
+type awsCallLabels struct {
+	Service   string
+	Operation string
+}
+
+type histogram struct {
+	counts []int64 // latencyBuckets ごとの件数 (累積ではない)
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(latencyBuckets))
+	}
+	for i, bucket := range latencyBuckets {
+		if v <= bucket {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type cacheCounts struct {
+	hits   int64
+	misses int64
+}
+
+// API のメトリクス (プロセス内で集計する)
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[requestLabels]int64
+	latencies map[requestLabels]*histogram
+	awsCalls  map[awsCallLabels]int64
+	awsErrors map[awsCallLabels]int64
+	caches    map[string]*cacheCounts
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  map[requestLabels]int64{},
+		latencies: map[requestLabels]*histogram{},
+		awsCalls:  map[awsCallLabels]int64{},
+		awsErrors: map[awsCallLabels]int64{},
+		caches:    map[string]*cacheCounts{},
+	}
+}
+
+var metrics = NewMetrics()
+
+// リクエストの件数とレイテンシを記録する
+func (m *Metrics) ObserveRequest(route string, method string, status int, latency time.Duration) {
+	labels := requestLabels{Route: route, Method: method, Status: strconv.Itoa(status)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[labels]++
+	h, ok := m.latencies[labels]
+	if !ok {
+		h = &histogram{}
+		m.latencies[labels] = h
+	}
+	h.observe(latency.Seconds())
+}
+
+// S3 / DynamoDB の呼び出しを記録する
+func (m *Metrics) ObserveAWSCall(service string, operation string, err error) {
+	labels := awsCallLabels{Service: service, Operation: operation}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.awsCalls[labels]++
+	if err != nil {
+		m.awsErrors[labels]++
+	}
+}
+
+// キャッシュのヒット・ミスを記録する
+func (m *Metrics) ObserveCache(name string, hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.caches[name]
+	if !ok {
+		c = &cacheCounts{}
+		m.caches[name] = c
+	}
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatLabels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escapeLabelValue(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedRequestLabels[V any](m map[requestLabels]V) []requestLabels {
+	keys := make([]requestLabels, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Route != keys[j].Route {
+			return keys[i].Route < keys[j].Route
+		}
+		if keys[i].Method != keys[j].Method {
+			return keys[i].Method < keys[j].Method
+		}
+		return keys[i].Status < keys[j].Status
+	})
+	return keys
+}
+
+func sortedAWSCallLabels(m map[awsCallLabels]int64) []awsCallLabels {
+	keys := make([]awsCallLabels, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Service != keys[j].Service {
+			return keys[i].Service < keys[j].Service
+		}
+		return keys[i].Operation < keys[j].Operation
+	})
+	return keys
+}
+
+// Prometheus のテキスト形式で出力する
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP compass_http_requests_total Number of HTTP requests by route, method and status.\n")
+	b.WriteString("# TYPE compass_http_requests_total counter\n")
+	for _, l := range sortedRequestLabels(m.requests) {
+		fmt.Fprintf(&b, "compass_http_requests_total%s %d\n", formatLabels("route", l.Route, "method", l.Method, "status", l.Status), m.requests[l])
+	}
+
+	b.WriteString("# HELP compass_http_request_duration_seconds HTTP request latency by route, method and status.\n")
+	b.WriteString("# TYPE compass_http_request_duration_seconds histogram\n")
+	for _, l := range sortedRequestLabels(m.latencies) {
+		h := m.latencies[l]
+		var cumulative int64
+		for i, bucket := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "compass_http_request_duration_seconds_bucket%s %d\n", formatLabels("route", l.Route, "method", l.Method, "status", l.Status, "le", formatFloat(bucket)), cumulative)
+		}
+		fmt.Fprintf(&b, "compass_http_request_duration_seconds_bucket%s %d\n", formatLabels("route", l.Route, "method", l.Method, "status", l.Status, "le", "+Inf"), h.count)
+		fmt.Fprintf(&b, "compass_http_request_duration_seconds_sum%s %s\n", formatLabels("route", l.Route, "method", l.Method, "status", l.Status), formatFloat(h.sum))
+		fmt.Fprintf(&b, "compass_http_request_duration_seconds_count%s %d\n", formatLabels("route", l.Route, "method", l.Method, "status", l.Status), h.count)
+	}
+
+	b.WriteString("# HELP compass_aws_calls_total Number of AWS API calls by service and operation.\n")
+	b.WriteString("# TYPE compass_aws_calls_total counter\n")
+	for _, l := range sortedAWSCallLabels(m.awsCalls) {
+		fmt.Fprintf(&b, "compass_aws_calls_total%s %d\n", formatLabels("service", l.Service, "operation", l.Operation), m.awsCalls[l])
+	}
+	b.WriteString("# HELP compass_aws_call_errors_total Number of failed AWS API calls by service and operation.\n")
+	b.WriteString("# TYPE compass_aws_call_errors_total counter\n")
+	for _, l := range sortedAWSCallLabels(m.awsErrors) {
+		fmt.Fprintf(&b, "compass_aws_call_errors_total%s %d\n", formatLabels("service", l.Service, "operation", l.Operation), m.awsErrors[l])
+	}
+
+	names := make([]string, 0, len(m.caches))
+	for name := range m.caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b.WriteString("# HELP compass_cache_requests_total Number of cache lookups by cache and result.\n")
+	b.WriteString("# TYPE compass_cache_requests_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "compass_cache_requests_total%s %d\n", formatLabels("cache", name, "result", "hit"), m.caches[name].hits)
+		fmt.Fprintf(&b, "compass_cache_requests_total%s %d\n", formatLabels("cache", name, "result", "miss"), m.caches[name].misses)
+	}
+	b.WriteString("# HELP compass_cache_hit_ratio Ratio of cache hits to lookups.\n")
+	b.WriteString("# TYPE compass_cache_hit_ratio gauge\n")
+	for _, name := range names {
+		c := m.caches[name]
+		fmt.Fprintf(&b, "compass_cache_hit_ratio%s %s\n", formatLabels("cache", name), formatFloat(float64(c.hits)/float64(c.hits+c.misses)))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// EMF に出力する AWS 呼び出し・キャッシュの累計
+type metricsTotals struct {
+	awsCalls    map[string]int64
+	awsErrors   map[string]int64
+	cacheHits   int64
+	cacheMisses int64
+}
+
+func (m *Metrics) totals() metricsTotals {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := metricsTotals{awsCalls: map[string]int64{}, awsErrors: map[string]int64{}}
+	for l, n := range m.awsCalls {
+		t.awsCalls[l.Service] += n
+	}
+	for l, n := range m.awsErrors {
+		t.awsErrors[l.Service] += n
+	}
+	for _, c := range m.caches {
+		t.cacheHits += c.hits
+		t.cacheMisses += c.misses
+	}
+	return t
+}
+
+/*
+AWS SDK の呼び出しを記録するミドルウェア
+
+	cfg.APIOptions = append(cfg.APIOptions, WithAWSMetrics)
+*/
+func WithAWSMetrics(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CompassMetrics", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		metrics.ObserveAWSCall(awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx), err)
+		return out, metadata, err
+	}), middleware.After)
+}
+
+// リクエストの件数・レイテンシを記録するミドルウェア (gin)
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveRequest(route, c.Request.Method, c.Writer.Status(), time.Since(start))
+	}
+}
+
+func MetricsGin(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	err := metrics.WritePrometheus(c.Writer)
+	if err != nil {
+		Logger(c.Request.Context()).Error("metrics write failed", "error", err)
+	}
+}
+
+/*
+Lambda のルート (gin の c.FullPath() に相当する)
+
+:id は 1 つ、* は 1 つ以上のセグメントに一致する。main の handler にルートを追加した場合はここにも追加する
+*/
+var lambdaRouteTemplates = []string{
+	"/",
+	"/healthz",
+	"/readyz",
+	"/companies",
+	"/companies/:id",
+	"/companies/:id/news",
+	"/companies/:id/overview",
+	"/search",
+	"/reports",
+	"/reports/*/raw",
+	"/filings/:id/xbrl",
+	"/filings/:id/pdf",
+	"/fundamentals",
+	"/news",
+	"/news/range",
+	"/register",
+	"/login",
+	"/token/refresh",
+	"/logout",
+	"/verify-email",
+	"/verify-email/resend",
+	"/password/forgot",
+	"/password/reset",
+	"/admin/users/unlock",
+	"/admin/users/role",
+	"/watchlists",
+	"/watchlists/:id",
+	"/watchlists/:id/summary",
+	"/subscriptions",
+	"/subscriptions/:id",
+	"/alerts",
+	"/alerts/read",
+	"/webhooks",
+	"/webhooks/:id",
+	"/webhooks/:id/deliveries",
+}
+
+func matchRouteTemplate(template []string, segments []string) bool {
+	if len(template) == 0 {
+		return len(segments) == 0
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	switch template[0] {
+	case "*":
+		for i := 1; i <= len(segments); i++ {
+			if matchRouteTemplate(template[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	case ":id":
+		return segments[0] != "" && matchRouteTemplate(template[1:], segments[1:])
+	}
+	return template[0] == segments[0] && matchRouteTemplate(template[1:], segments[1:])
+}
+
+/*
+メトリクスのラベル用にパスをルートに置き換える (watchlists/{id}/summary → /watchlists/:id/summary)
+
+ルートに一致しないパスは unmatched とする (任意のパスでラベル・CloudWatch のメトリクスが増えないように)
+*/
+func routeTemplate(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for _, template := range lambdaRouteTemplates {
+		if matchRouteTemplate(strings.Split(strings.Trim(template, "/"), "/"), segments) {
+			return template
+		}
+	}
+	return "unmatched"
+}
+
+/*
+リクエストのメトリクスを CloudWatch Embedded Metric Format (EMF) でログに出力するミドルウェア (Lambda)
+
+Lambda の実行環境は同時に 1 リクエストのみ処理するため、AWS 呼び出し・キャッシュは前後の累計の差分をそのリクエストの値とする
+*/
+func WithMetrics(next LambdaHandler) LambdaHandler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		start := time.Now()
+		before := metrics.totals()
+		res, err := next(ctx, req)
+		latency := time.Since(start)
+
+		status := res.StatusCode
+		if err != nil && status < http.StatusBadRequest {
+			status = http.StatusInternalServerError
+		}
+		route := routeTemplate(req.PathParameters["path"])
+		metrics.ObserveRequest(route, req.HTTPMethod, status, latency)
+
+		after := metrics.totals()
+		fmt.Println(emfRecord(conf.MetricsNamespace, route, req.HTTPMethod, status, latency, before, after))
+		return res, err
+	}
+}
+
+func emfRecord(namespace string, route string, method string, status int, latency time.Duration, before metricsTotals, after metricsTotals) string {
+	type metricDefinition struct {
+		Name string `json:"Name"`
+		Unit string `json:"Unit"`
+	}
+	definitions := []metricDefinition{
+		{Name: "Requests", Unit: "Count"},
+		{Name: "Latency", Unit: "Milliseconds"},
+		{Name: "CacheHits", Unit: "Count"},
+		{Name: "CacheMisses", Unit: "Count"},
+	}
+	record := map[string]interface{}{
+		"Route":       route,
+		"Method":      method,
+		"Status":      strconv.Itoa(status),
+		"Requests":    1,
+		"Latency":     float64(latency.Microseconds()) / 1000,
+		"CacheHits":   after.cacheHits - before.cacheHits,
+		"CacheMisses": after.cacheMisses - before.cacheMisses,
+	}
+	for _, service := range []string{"S3", "DynamoDB"} {
+		definitions = append(definitions,
+			metricDefinition{Name: service + "Calls", Unit: "Count"},
+			metricDefinition{Name: service + "Errors", Unit: "Count"},
+		)
+		record[service+"Calls"] = after.awsCalls[service] - before.awsCalls[service]
+		record[service+"Errors"] = after.awsErrors[service] - before.awsErrors[service]
+	}
+	record["_aws"] = map[string]interface{}{
+		"Timestamp": time.Now().UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{
+			{
+				"Namespace":  namespace,
+				"Dimensions": [][]string{{"Route", "Status"}},
+				"Metrics":    definitions,
+			},
+		},
+	}
+	body, err := json.Marshal(record)
+	if err != nil {
+		return ""
+	}
+	return string(body)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestRouteTemplate(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "/"},
+		{"companies", "/companies"},
+		{"/news/range/", "/news/range"},
+		{"watchlists/wl-01/summary", "/watchlists/:id/summary"},
+		{"watchlists/abc", "/watchlists/:id"},
+		{"alerts/read", "/alerts/read"},
+		{"reports/E00001/BS/2024.html/raw", "/reports/*/raw"},
+		{"reports/E00001%2FBS%2F2024.html/raw", "/reports/*/raw"},
+		{"filings/S100ABCD/pdf", "/filings/:id/pdf"},
+		// ルートに一致しないパスはラベルを増やさない
+		{"wp-admin", "unmatched"},
+		{"random/path", "unmatched"},
+		{"watchlists/wl-01/other", "unmatched"},
+		{"reports/raw", "unmatched"},
+		{"filings//pdf", "unmatched"},
+	}
+	for _, tt := range tests {
+		if got := routeTemplate(tt.path); got != tt.want {
+			t.Errorf("routeTemplate(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWithMetricsUnmatchedRoute(t *testing.T) {
+	prev := metrics
+	metrics = NewMetrics()
+	t.Cleanup(func() { metrics = prev })
+
+	handler := WithMetrics(func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusNotFound}, nil
+	})
+	for _, path := range []string{"a", "b", "c/d", "e-f"} {
+		req := events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, PathParameters: map[string]string{"path": path}}
+		if _, err := handler(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(metrics.requests) != 1 || metrics.requests[requestLabels{Route: "unmatched", Method: http.MethodGet, Status: "404"}] != 4 {
+		t.Errorf("requests = %v", metrics.requests)
+	}
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+/*
+ニュースの保存先のキー
+
+	latest/news.json             最新の版
+	news/{YYYY-MM-DD}/{am|pm}.json 日付・午前午後ごとの版
+*/
+const latestNewsKey = "latest/news.json"
+
+const newsDateLayout = "2006-01-02"
+
+// 一度に取得できる期間
+const maxNewsRangeDays = 92
+
+// ニュースの日付は日本時間で扱う
+var newsLocation = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+var errNewsNotFound = NewError(http.StatusNotFound, "指定した日付のニュースはありません")
+
+// ニュースの版の一覧の要素
+type NewsEdition struct {
+	Date string `json:"date"`
+	AmPm string `json:"am_pm"`
+}
+
+func NewsEditionKey(date string, ampm string) string {
+	return fmt.Sprintf("news/%s/%s.json", date, ampm)
+}
+
+// 現在時刻の版 (日付と午前・午後)
+func CurrentNewsEdition(now time.Time) NewsEdition {
+	now = now.In(newsLocation)
+	ampm := "am"
+	if now.Hour() >= 12 {
+		ampm = "pm"
+	}
+	return NewsEdition{Date: now.Format(newsDateLayout), AmPm: ampm}
+}
+
+func parseNewsDate(date string) (time.Time, error) {
+	t, err := time.ParseInLocation(newsDateLayout, date, newsLocation)
+	if err != nil {
+		return time.Time{}, NewError(http.StatusBadRequest, "日付は YYYY-MM-DD の形式で指定してください")
+	}
+	return t, nil
+}
+
+func validateAmPm(ampm string) error {
+	if ampm != "am" && ampm != "pm" {
+		return NewError(http.StatusBadRequest, "ampm は am または pm を指定してください")
+	}
+	return nil
+}
+
+/*
+ニュースの版を保存する (ニュースバッチから呼び出す)
+
+日付・午前午後ごとのキーに保存し、最新の版も更新する
+*/
+func PutNewsEdition(ctx context.Context, store ObjectStore, result internal.NewsResult) error {
+	if _, err := parseNewsDate(result.DateStr); err != nil {
+		return fmt.Errorf("invalid date_str: %q", result.DateStr)
+	}
+	if err := validateAmPm(result.AmPm); err != nil {
+		return fmt.Errorf("invalid am_pm: %q", result.AmPm)
+	}
+	body, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = store.PutObject(ctx, NewsEditionKey(result.DateStr, result.AmPm), body, "application/json")
+	if err != nil {
+		return err
+	}
+	return store.PutObject(ctx, latestNewsKey, body, "application/json")
+}
+
+func getNewsObject(ctx context.Context, key string) (*internal.NewsResult, error) {
+	body, err := newsStore.GetObject(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, errNewsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var newsResult internal.NewsResult
+	err = json.Unmarshal(body, &newsResult)
+	if err != nil {
+		return nil, err
+	}
+	return &newsResult, nil
+}
+
+/*
+ニュースを取得する
+
+	date, ampm とも未指定  最新の版
+	date のみ指定         その日の最新の版 (pm がなければ am)
+	date, ampm を指定     その版
+
+category を指定した場合はそのカテゴリの記事に絞り込む
+*/
+func GetNewsProcessor(ctx context.Context, date string, ampm string, category string) (*internal.NewsResult, error) {
+	if category != "" {
+		if err := validateNewsCategory(category); err != nil {
+			return nil, err
+		}
+	}
+	newsResult, err := getNewsEdition(ctx, date, ampm)
+	if err != nil {
+		return nil, err
+	}
+	if category != "" {
+		newsResult.NewsList = filterNewsByCategory(newsResult.NewsList, category)
+	}
+	return newsResult, nil
+}
+
+func getNewsEdition(ctx context.Context, date string, ampm string) (*internal.NewsResult, error) {
+	if date == "" {
+		if ampm != "" {
+			return nil, NewError(http.StatusBadRequest, "ampm を指定する場合は date も指定してください")
+		}
+		return getNewsObject(ctx, latestNewsKey)
+	}
+	if _, err := parseNewsDate(date); err != nil {
+		return nil, err
+	}
+	if ampm != "" {
+		if err := validateAmPm(ampm); err != nil {
+			return nil, err
+		}
+		return getNewsObject(ctx, NewsEditionKey(date, ampm))
+	}
+	newsResult, err := getNewsObject(ctx, NewsEditionKey(date, "pm"))
+	if errors.Is(err, errNewsNotFound) {
+		return getNewsObject(ctx, NewsEditionKey(date, "am"))
+	}
+	return newsResult, err
+}
+
+/*
+期間内のニュースの版を新しい順に返す
+
+from, to は YYYY-MM-DD (未指定の場合は直近 7 日間)
+*/
+func ListNewsEditionsProcessor(ctx context.Context, from string, to string) ([]NewsEdition, error) {
+	toDate := time.Now().In(newsLocation)
+	if to != "" {
+		var err error
+		toDate, err = parseNewsDate(to)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fromDate := toDate.AddDate(0, 0, -6)
+	if from != "" {
+		var err error
+		fromDate, err = parseNewsDate(from)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fromStr, toStr := fromDate.Format(newsDateLayout), toDate.Format(newsDateLayout)
+	if fromStr > toStr {
+		return nil, NewError(http.StatusBadRequest, "from は to 以前の日付を指定してください")
+	}
+	if toDate.Sub(fromDate) > maxNewsRangeDays*24*time.Hour {
+		return nil, NewError(http.StatusBadRequest, fmt.Sprintf("期間は %d 日以内で指定してください", maxNewsRangeDays))
+	}
+
+	// 月ごとのプレフィックスで一覧を取得する
+	editions := []NewsEdition{}
+	month := time.Date(fromDate.Year(), fromDate.Month(), 1, 0, 0, 0, 0, newsLocation)
+	for !month.After(toDate) {
+		keys, err := newsStore.ListObjects(ctx, "news/"+month.Format("2006-01"))
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			// news/{YYYY-MM-DD}/{am|pm}.json
+			parts := strings.Split(strings.TrimSuffix(key, ".json"), "/")
+			if len(parts) != 3 || validateAmPm(parts[2]) != nil {
+				continue
+			}
+			if parts[1] < fromStr || parts[1] > toStr {
+				continue
+			}
+			editions = append(editions, NewsEdition{Date: parts[1], AmPm: parts[2]})
+		}
+		month = month.AddDate(0, 1, 0)
+	}
+	// キーは昇順のため逆順にする
+	for i, j := 0, len(editions)-1; i < j; i, j = i+1, j-1 {
+		editions[i], editions[j] = editions[j], editions[i]
+	}
+	return editions, nil
+}
+
+func GetNewsGin(c *gin.Context) {
+	result, err := GetNewsProcessor(c.Request.Context(), c.Query("date"), c.Query("ampm"), c.Query("category"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, result)
+}
+
+func ListNewsEditionsGin(c *gin.Context) {
+	editions, err := ListNewsEditionsProcessor(c.Request.Context(), c.Query("from"), c.Query("to"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, editions)
+}
+
+func GetNews(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	result, err := GetNewsProcessor(ctx, req.QueryStringParameters["date"], req.QueryStringParameters["ampm"], req.QueryStringParameters["category"])
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, result)
+}
+
+func ListNewsEditions(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	editions, err := ListNewsEditionsProcessor(ctx, req.QueryStringParameters["from"], req.QueryStringParameters["to"])
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, editions)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joe-black-jb/compass-api/internal"
+	"golang.org/x/text/width"
+)
+
+// ニュースのカテゴリ
+const (
+	NewsCategoryEarnings  = "earnings"
+	NewsCategoryMAndA     = "m_and_a"
+	NewsCategoryGuidance  = "guidance"
+	NewsCategoryDividends = "dividends"
+)
+
+// カテゴリごとのキーワード (日本語・英語、英字の大文字・小文字は区別しない)
+type NewsCategoryDictionary map[string][]string
+
+var DefaultNewsCategoryDictionary = NewsCategoryDictionary{
+	NewsCategoryEarnings: {
+		"決算", "業績", "四半期", "売上高", "営業利益", "経常利益", "純利益", "増益", "減益", "増収", "減収", "黒字", "赤字",
+		"earnings", "quarterly results", "revenue", "operating profit", "net income", "net profit",
+	},
+	NewsCategoryMAndA: {
+		"買収", "合併", "経営統合", "子会社化", "完全子会社", "株式取得", "事業譲渡", "公開買付", "TOB", "MBO", "M&A",
+		"acquisition", "acquire", "merger", "takeover", "tender offer", "buyout",
+	},
+	NewsCategoryGuidance: {
+		"業績予想", "通期予想", "見通し", "上方修正", "下方修正", "計画を修正",
+		"guidance", "forecast", "outlook",
+	},
+	NewsCategoryDividends: {
+		"配当", "増配", "減配", "復配", "無配", "株主還元",
+		"dividend", "payout",
+	},
+}
+
+var newsCategoryDictionary = DefaultNewsCategoryDictionary
+
+/*
+設定からカテゴリの辞書を読み込む
+
+NEWS_CATEGORY_FILE に JSON ({"カテゴリ": ["キーワード", ...]}) を指定した場合はその辞書を使う
+*/
+func newNewsCategoryDictionary() (NewsCategoryDictionary, error) {
+	path := conf.NewsCategoryFile
+	if path == "" {
+		return DefaultNewsCategoryDictionary, nil
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var dictionary NewsCategoryDictionary
+	err = json.Unmarshal(body, &dictionary)
+	if err != nil {
+		return nil, err
+	}
+	for category, keywords := range dictionary {
+		if category == "" || len(keywords) == 0 {
+			return nil, fmt.Errorf("category %q has no keywords", category)
+		}
+	}
+	return dictionary, nil
+}
+
+// 比較用に表記を揃える (英数字を半角・小文字にする)
+func normalizeCategoryText(text string) string {
+	return strings.ToLower(width.Fold.String(text))
+}
+
+func isASCIIAlnum(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('0' <= b && b <= '9')
+}
+
+/*
+キーワードが含まれるか判定する
+
+英数字のキーワードは単語の先頭から一致する場合のみ (dividend は dividends に一致し、tob は october に一致しない)
+3 文字以下の英数字のキーワード (TOB、M&A など) は単語全体が一致する場合のみ
+*/
+func containsKeyword(text string, keyword string) bool {
+	if keyword == "" {
+		return false
+	}
+	if !isASCIIAlnum(keyword[0]) {
+		return strings.Contains(text, keyword)
+	}
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], keyword)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(keyword)
+		if (start == 0 || !isASCIIAlnum(text[start-1])) && (len(keyword) > 3 || end == len(text) || !isASCIIAlnum(text[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// 記事のタイトル・要約に含まれるキーワードからカテゴリを返す (カテゴリ名の昇順)
+func (d NewsCategoryDictionary) Categorize(news internal.NewsData) []string {
+	text := normalizeCategoryText(news.Title + " " + news.Summary)
+	var categories []string
+	for category, keywords := range d {
+		for _, keyword := range keywords {
+			if containsKeyword(text, normalizeCategoryText(keyword)) {
+				categories = append(categories, category)
+				break
+			}
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// ニュース記事にカテゴリを設定する (ニュースバッチから呼び出す)
+func CategorizeNews(newsList []internal.NewsData) {
+	for i := range newsList {
+		newsList[i].Categories = newsCategoryDictionary.Categorize(newsList[i])
+	}
+}
+
+func validateNewsCategory(category string) error {
+	if _, ok := newsCategoryDictionary[category]; !ok {
+		categories := make([]string, 0, len(newsCategoryDictionary))
+		for c := range newsCategoryDictionary {
+			categories = append(categories, c)
+		}
+		sort.Strings(categories)
+		return NewError(http.StatusBadRequest, fmt.Sprintf("category は %s のいずれかを指定してください", strings.Join(categories, ", ")))
+	}
+	return nil
+}
+
+func newsHasCategory(news internal.NewsData, category string) bool {
+	categories := news.Categories
+	if len(categories) == 0 {
+		// カテゴリ設定前に保存された記事はその場で分類する
+		categories = newsCategoryDictionary.Categorize(news)
+	}
+	for _, c := range categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// カテゴリに該当する記事に絞り込む
+func filterNewsByCategory(newsList []internal.NewsData, category string) []internal.NewsData {
+	filtered := []internal.NewsData{}
+	for _, news := range newsList {
+		if newsHasCategory(news, category) {
+			filtered = append(filtered, news)
+		}
+	}
+	return filtered
+}
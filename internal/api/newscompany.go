@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
+	"golang.org/x/text/width"
+)
+
+// 略称を作る際に企業名から除く法人格
+var legalEntityNames = []string{"株式会社", "（株）", "㈱", "有限会社", "（有）", "合同会社"}
+
+// 誤検知を避けるため、これより短い略称では照合しない
+const minShortNameLength = 2
+
+/*
+記事中の銘柄コード
+
+	(7203) / (7203.T)
+	コード: 7203 / 証券コード7203 / TSE:7203 / 東証プライム:7203
+	7203.T
+*/
+var tickerPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\(([0-9]{3}[0-9A-Z])(?:\.T)?\)`),
+	regexp.MustCompile(`(?i)(?:コード|code|TSE|東証[^\s:()0-9]*)\s*:?\s*([0-9]{3}[0-9A-Z])(?:[^0-9A-Za-z]|$)`),
+	regexp.MustCompile(`\b([0-9]{3}[0-9A-Z])\.T\b`),
+}
+
+// 企業名から法人格を除いた略称
+func ShortCompanyName(name string) string {
+	short := NormalizeCompanyName(name)
+	for _, legalEntityName := range legalEntityNames {
+		short = strings.ReplaceAll(short, legalEntityName, "")
+	}
+	return strings.TrimSpace(short)
+}
+
+// 証券コード (5 桁) から銘柄コード (4 桁) を取り出す
+func tickerFromSecurityCode(securityCode string) string {
+	code := strings.TrimSpace(securityCode)
+	if len(code) == 5 {
+		return code[:4]
+	}
+	if len(code) == 4 {
+		return code
+	}
+	return ""
+}
+
+type newsCompanyEntry struct {
+	company internal.NewsCompany
+	names   []string
+}
+
+// ニュース記事と企業を照合する (企業名・略称・銘柄コード)
+type NewsCompanyMatcher struct {
+	entries  []newsCompanyEntry
+	byTicker map[string][]internal.NewsCompany
+}
+
+func NewNewsCompanyMatcher(companies []internal.Company) *NewsCompanyMatcher {
+	matcher := &NewsCompanyMatcher{byTicker: map[string][]internal.NewsCompany{}}
+	for _, company := range companies {
+		newsCompany := internal.NewsCompany{ID: company.ID, Name: company.Name, EDINETCode: company.EDINETCode}
+		var names []string
+		if name := NormalizeCompanyName(company.Name); name != "" {
+			names = append(names, name)
+		}
+		if short := ShortCompanyName(company.Name); utf8.RuneCountInString(short) >= minShortNameLength && (len(names) == 0 || short != names[0]) {
+			names = append(names, short)
+		}
+		if len(names) > 0 {
+			matcher.entries = append(matcher.entries, newsCompanyEntry{company: newsCompany, names: names})
+		}
+		if ticker := tickerFromSecurityCode(company.SecurityCode); ticker != "" {
+			matcher.byTicker[ticker] = append(matcher.byTicker[ticker], newsCompany)
+		}
+	}
+	return matcher
+}
+
+// 記事のタイトル・要約で言及されている企業を返す
+func (m *NewsCompanyMatcher) Match(news internal.NewsData) []internal.NewsCompany {
+	text := news.Title + " " + news.Summary
+	// 企業名は企業検索と同じく全角に揃えて照合する
+	normalized := NormalizeCompanyName(text)
+
+	var matched []internal.NewsCompany
+	seen := map[string]bool{}
+	add := func(company internal.NewsCompany) {
+		if seen[company.ID] {
+			return
+		}
+		seen[company.ID] = true
+		matched = append(matched, company)
+	}
+	for _, entry := range m.entries {
+		for _, name := range entry.names {
+			if strings.Contains(normalized, name) {
+				add(entry.company)
+				break
+			}
+		}
+	}
+	// 銘柄コードは半角に揃えて照合する
+	folded := width.Fold.String(text)
+	for _, pattern := range tickerPatterns {
+		for _, match := range pattern.FindAllStringSubmatch(folded, -1) {
+			for _, company := range m.byTicker[strings.ToUpper(match[1])] {
+				add(company)
+			}
+		}
+	}
+	return matched
+}
+
+// ニュース記事に言及されている企業を設定する (ニュースバッチから呼び出す)
+func LinkNewsCompanies(newsList []internal.NewsData, companies []internal.Company) {
+	matcher := NewNewsCompanyMatcher(companies)
+	for i := range newsList {
+		newsList[i].Companies = matcher.Match(newsList[i])
+	}
+}
+
+func newsMentionsCompany(news internal.NewsData, company internal.Company, matcher *NewsCompanyMatcher) bool {
+	if len(news.Companies) == 0 {
+		// 企業の紐づけ前に保存された記事はその場で照合する
+		return len(matcher.Match(news)) > 0
+	}
+	for _, newsCompany := range news.Companies {
+		if newsCompany.ID == company.ID {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+企業に関するニュースを新しい順に返す
+
+from, to は /news/range と同じ (未指定の場合は直近 7 日間)、category を指定した場合はそのカテゴリの記事に絞り込む
+*/
+func GetCompanyNewsProcessor(ctx context.Context, companyID string, from string, to string, category string) ([]internal.CompanyNews, error) {
+	if category != "" {
+		if err := validateNewsCategory(category); err != nil {
+			return nil, err
+		}
+	}
+	company, err := GetCompanyProcessor(companyID)
+	if err != nil {
+		return nil, err
+	}
+	if company.ID == "" {
+		return nil, NewError(http.StatusNotFound, "企業が見つかりません")
+	}
+	return listCompanyNews(ctx, company, from, to, category)
+}
+
+func listCompanyNews(ctx context.Context, company internal.Company, from string, to string, category string) ([]internal.CompanyNews, error) {
+	editions, err := ListNewsEditionsProcessor(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*internal.NewsResult, len(editions))
+	var wg sync.WaitGroup
+	// S3 への同時リクエスト数
+	sem := make(chan struct{}, 8)
+	for i, edition := range editions {
+		wg.Add(1)
+		go func(i int, edition NewsEdition) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			result, err := getNewsObject(ctx, NewsEditionKey(edition.Date, edition.AmPm))
+			if err != nil {
+				Logger(ctx).Error("get news edition failed", "date", edition.Date, "ampm", edition.AmPm, "error", err)
+				return
+			}
+			results[i] = result
+		}(i, edition)
+	}
+	wg.Wait()
+
+	matcher := NewNewsCompanyMatcher([]internal.Company{company})
+	newsList := []internal.CompanyNews{}
+	seen := map[string]bool{}
+	// 版は新しい順のため、同じ記事は最も新しい版のものを返す
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		for _, news := range result.NewsList {
+			if seen[news.Link] || !newsMentionsCompany(news, company, matcher) {
+				continue
+			}
+			if category != "" && !newsHasCategory(news, category) {
+				continue
+			}
+			seen[news.Link] = true
+			newsList = append(newsList, internal.CompanyNews{
+				NewsData: news,
+				Date:     editions[i].Date,
+				AmPm:     editions[i].AmPm,
+			})
+		}
+	}
+	return newsList, nil
+}
+
+func GetCompanyNewsGin(c *gin.Context) {
+	newsList, err := GetCompanyNewsProcessor(c.Request.Context(), c.Param("id"), c.Query("from"), c.Query("to"), c.Query("category"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, newsList)
+}
+
+// companies/{id}/... のルーティング (news / overview)
+func Companies(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	segments := strings.Split(strings.Trim(req.PathParameters["path"], "/"), "/")
+
+	switch {
+	case len(segments) == 2 && req.HTTPMethod == http.MethodGet:
+		// REST API では /{companyId} のリソースで GetCompany を呼び出すが、HTTP API・ALB・関数 URL ではパスから企業を取得する
+		company, err := GetCompanyProcessor(segments[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, company)
+	case len(segments) == 3 && segments[2] == "news" && req.HTTPMethod == http.MethodGet:
+		newsList, err := GetCompanyNewsProcessor(ctx, segments[1], req.QueryStringParameters["from"], req.QueryStringParameters["to"], req.QueryStringParameters["category"])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, newsList)
+	case len(segments) == 3 && segments[2] == "overview" && req.HTTPMethod == http.MethodGet:
+		overview, err := GetCompanyOverviewProcessor(ctx, segments[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, overview)
+	}
+	return errorResponse(NewError(http.StatusNotFound, "Not Found"))
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/joe-black-jb/compass-api/internal"
+	"golang.org/x/net/html/charset"
+)
+
+// ニュースの要約の最大文字数
+const newsSummaryLength = 200
+
+// フィードの記事
+type NewsFeedEntry struct {
+	Title       string
+	Link        string
+	Summary     string
+	PublishedAt time.Time
+}
+
+// RSS 2.0
+type rssFeed struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+// RSS 2.0 / RSS 1.0 の記事
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+// RSS 1.0 (RDF)
+type rdfFeed struct {
+	Items []rssItem `xml:"item"`
+}
+
+// Atom
+type atomFeed struct {
+	Entries []struct {
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+func newFeedDecoder(body []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(strings.NewReader(string(body)))
+	// Shift_JIS などの UTF-8 以外のフィードに対応する
+	decoder.CharsetReader = charset.NewReaderLabel
+	return decoder
+}
+
+// ルート要素で RSS 2.0 / RSS 1.0 / Atom を判別して記事を取り出す
+func ParseNewsFeed(body []byte) ([]NewsFeedEntry, error) {
+	root, err := feedRootElement(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []NewsFeedEntry
+	switch root {
+	case "rss":
+		var feed rssFeed
+		if err := newFeedDecoder(body).Decode(&feed); err != nil {
+			return nil, err
+		}
+		for _, item := range feed.Channel.Items {
+			entries = append(entries, rssEntry(item))
+		}
+	case "RDF":
+		var feed rdfFeed
+		if err := newFeedDecoder(body).Decode(&feed); err != nil {
+			return nil, err
+		}
+		for _, item := range feed.Items {
+			entries = append(entries, rssEntry(item))
+		}
+	case "feed":
+		var feed atomFeed
+		if err := newFeedDecoder(body).Decode(&feed); err != nil {
+			return nil, err
+		}
+		for _, entry := range feed.Entries {
+			var link string
+			for _, l := range entry.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = l.Href
+					break
+				}
+			}
+			summary := entry.Summary
+			if summary == "" {
+				summary = entry.Content
+			}
+			published := entry.Published
+			if published == "" {
+				published = entry.Updated
+			}
+			entries = append(entries, NewsFeedEntry{
+				Title:       strings.TrimSpace(entry.Title),
+				Link:        strings.TrimSpace(link),
+				Summary:     summary,
+				PublishedAt: parseFeedTime(published),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported feed format: <%s>", root)
+	}
+	return entries, nil
+}
+
+func feedRootElement(body []byte) (string, error) {
+	decoder := newFeedDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return "", errors.New("empty feed")
+		}
+		if err != nil {
+			return "", err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func rssEntry(item rssItem) NewsFeedEntry {
+	published := item.PubDate
+	if published == "" {
+		published = item.Date
+	}
+	return NewsFeedEntry{
+		Title:       strings.TrimSpace(item.Title),
+		Link:        strings.TrimSpace(item.Link),
+		Summary:     item.Description,
+		PublishedAt: parseFeedTime(published),
+	}
+}
+
+// RSS (RFC 1123) と Atom / Dublin Core (RFC 3339) の日時を解析する
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// 重複判定用にリンクを正規化する (フラグメントを除く)
+func normalizeNewsLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	u.Fragment = ""
+	u.Host = strings.ToLower(u.Host)
+	return u.String()
+}
+
+// 要約から HTML タグを除き、一定の長さに切り詰める
+func plainNewsSummary(summary string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(summary))
+	if err == nil {
+		summary = doc.Text()
+	}
+	summary = strings.Join(strings.Fields(summary), " ")
+	runes := []rune(summary)
+	if len(runes) > newsSummaryLength {
+		summary = string(runes[:newsSummaryLength]) + "…"
+	}
+	return summary
+}
+
+// 新しい順に並べ、リンクで重複を除いた NewsData の一覧を作る
+func BuildNewsList(entries []NewsFeedEntry, maxItems int) []internal.NewsData {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].PublishedAt.After(entries[j].PublishedAt)
+	})
+	seen := map[string]bool{}
+	newsList := []internal.NewsData{}
+	for _, entry := range entries {
+		if entry.Title == "" || entry.Link == "" {
+			continue
+		}
+		link := normalizeNewsLink(entry.Link)
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		newsList = append(newsList, internal.NewsData{
+			Title:   entry.Title,
+			Summary: plainNewsSummary(entry.Summary),
+			Link:    link,
+		})
+		if len(newsList) >= maxItems {
+			break
+		}
+	}
+	return newsList
+}
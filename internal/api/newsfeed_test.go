@@ -0,0 +1,191 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ニュースバッチのオフライン確認用のフィード
+func readNewsFeed(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("..", "..", "newsBatch", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestFeedRootElement(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		want    string
+		wantErr bool
+	}{
+		{"RSS 2.0", readNewsFeed(t, "rss.xml"), "rss", false},
+		{"RSS 1.0", readNewsFeed(t, "rdf.xml"), "RDF", false},
+		{"Atom", readNewsFeed(t, "atom.xml"), "feed", false},
+		{"空", []byte(`<?xml version="1.0"?>`), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := feedRootElement(tt.body)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %q, err = %v", got, err)
+			}
+		})
+	}
+}
+
+func TestParseNewsFeed(t *testing.T) {
+	jst := time.FixedZone("", 9*60*60)
+	tests := []struct {
+		file      string
+		wantLinks []string
+		wantTimes []time.Time
+	}{
+		{
+			file:      "rss.xml",
+			wantLinks: []string{"https://example.com/news/0001", "https://example.com/news/0002#top", "https://example.com/news/0003"},
+			wantTimes: []time.Time{
+				time.Date(2024, 10, 18, 15, 30, 0, 0, jst),
+				time.Date(2024, 10, 18, 11, 0, 0, 0, jst),
+				time.Date(2024, 10, 18, 9, 0, 0, 0, jst),
+			},
+		},
+		{
+			// dc:date を使う
+			file:      "rdf.xml",
+			wantLinks: []string{"https://example.net/articles/100"},
+			wantTimes: []time.Time{time.Date(2024, 10, 18, 13, 0, 0, 0, jst)},
+		},
+		{
+			// published がなければ updated を使う
+			file:      "atom.xml",
+			wantLinks: []string{"https://example.org/en/sony-buyback", "https://example.com/news/0002"},
+			wantTimes: []time.Time{
+				time.Date(2024, 10, 18, 16, 0, 0, 0, jst),
+				time.Date(2024, 10, 18, 10, 0, 0, 0, jst),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			entries, err := ParseNewsFeed(readNewsFeed(t, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.wantLinks) {
+				t.Fatalf("%d 件, want %d", len(entries), len(tt.wantLinks))
+			}
+			for i, entry := range entries {
+				if entry.Title == "" || entry.Summary == "" {
+					t.Errorf("entries[%d] = %+v", i, entry)
+				}
+				if entry.Link != tt.wantLinks[i] {
+					t.Errorf("entries[%d].Link = %q, want %q", i, entry.Link, tt.wantLinks[i])
+				}
+				if !entry.PublishedAt.Equal(tt.wantTimes[i]) {
+					t.Errorf("entries[%d].PublishedAt = %v, want %v", i, entry.PublishedAt, tt.wantTimes[i])
+				}
+			}
+		})
+	}
+
+	if _, err := ParseNewsFeed([]byte(`<html><body></body></html>`)); err == nil {
+		t.Error("フィード以外を読み込みました")
+	}
+}
+
+func TestParseFeedTime(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{"Fri, 18 Oct 2024 15:30:00 +0900", time.Date(2024, 10, 18, 6, 30, 0, 0, time.UTC)},
+		{"Fri, 18 Oct 2024 06:30:00 GMT", time.Date(2024, 10, 18, 6, 30, 0, 0, time.UTC)},
+		{"2024-10-18T13:00:00+09:00", time.Date(2024, 10, 18, 4, 0, 0, 0, time.UTC)},
+		{" Tue, 1 Oct 2024 09:00:00 +0900 ", time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024/10/18", time.Time{}},
+		{"", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := parseFeedTime(tt.input); !got.Equal(tt.want) {
+			t.Errorf("parseFeedTime(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeNewsLink(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"https://example.com/news/0002#top", "https://example.com/news/0002"},
+		{"https://EXAMPLE.com/news/0002", "https://example.com/news/0002"},
+		{"https://example.com/news?id=1#a", "https://example.com/news?id=1"},
+		{"https://example.com/News/0002", "https://example.com/News/0002"},
+	}
+	for _, tt := range tests {
+		if got := normalizeNewsLink(tt.input); got != tt.want {
+			t.Errorf("normalizeNewsLink(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestBuildNewsList(t *testing.T) {
+	var entries []NewsFeedEntry
+	for _, file := range []string{"rss.xml", "rdf.xml", "atom.xml"} {
+		parsed, err := ParseNewsFeed(readNewsFeed(t, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, parsed...)
+	}
+	// タイトルまたはリンクのない記事は除く
+	entries = append(entries, NewsFeedEntry{Title: "リンクなし", PublishedAt: time.Now()}, NewsFeedEntry{Link: "https://example.com/untitled", PublishedAt: time.Now()})
+
+	// 新しい順に並べ、0002 はフラグメントの有無に関わらず新しい RSS の 1 件だけ残す
+	newsList := BuildNewsList(append([]NewsFeedEntry{}, entries...), 50)
+	wantLinks := []string{
+		"https://example.org/en/sony-buyback",
+		"https://example.com/news/0001",
+		"https://example.net/articles/100",
+		"https://example.com/news/0002",
+		"https://example.com/news/0003",
+	}
+	if len(newsList) != len(wantLinks) {
+		t.Fatalf("%d 件, want %d: %+v", len(newsList), len(wantLinks), newsList)
+	}
+	for i, news := range newsList {
+		if news.Link != wantLinks[i] {
+			t.Errorf("newsList[%d].Link = %q, want %q", i, news.Link, wantLinks[i])
+		}
+	}
+	if got := newsList[3].Title; got != "ソフトバンクグループ、社債を発行" {
+		t.Errorf("重複した記事のうち %q を残しました", got)
+	}
+	// 要約から HTML タグを除く
+	if got := newsList[1].Summary; got != "トヨタ自動車は2025年3月期の連結業績予想を上方修正した。" {
+		t.Errorf("Summary = %q", got)
+	}
+
+	// 件数の上限
+	newsList = BuildNewsList(append([]NewsFeedEntry{}, entries...), 2)
+	if len(newsList) != 2 || newsList[0].Link != wantLinks[0] || newsList[1].Link != wantLinks[1] {
+		t.Errorf("newsList = %+v", newsList)
+	}
+}
+
+func TestPlainNewsSummary(t *testing.T) {
+	if got := plainNewsSummary("<p>a\n  <b>b</b></p>"); got != "a b" {
+		t.Errorf("got %q", got)
+	}
+	long := strings.Repeat("あ", newsSummaryLength+1)
+	if got := plainNewsSummary(long); got != strings.Repeat("あ", newsSummaryLength)+"…" {
+		t.Errorf("切り詰めていません: %d 文字", len([]rune(got)))
+	}
+}
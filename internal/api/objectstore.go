@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// ファイルの保存先 (S3 バケット、またはローカル用のディレクトリ)
+type ObjectStore interface {
+	// 存在しない場合は ErrObjectNotFound
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	// 中身を読み込まずに開く (存在しない場合は ErrObjectNotFound)。Body は呼び出し側で閉じる
+	OpenObject(ctx context.Context, key string) (*Object, error)
+	PutObject(ctx context.Context, key string, body []byte, contentType string) error
+	// prefix に一致するキーを昇順で返す
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+}
+
+// 開いたファイル
+type Object struct {
+	Body        io.ReadCloser
+	ContentType string
+	// 不明な場合は -1
+	Size int64
+}
+
+// 署名付き URL を発行できる保存先 (S3 のみ)
+type ObjectPresigner interface {
+	PresignGetObject(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// mime パッケージが知らない拡張子の Content-Type
+var objectContentTypes = map[string]string{
+	".xbrl": "application/xml",
+}
+
+// キーの拡張子から Content-Type を決める (不明な場合は保存時の Content-Type)
+func objectContentType(key string, stored string) string {
+	if contentType, ok := objectContentTypes[path.Ext(key)]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+	if stored != "" {
+		return stored
+	}
+	return "application/octet-stream"
+}
+
+// S3 バケットをファイルの保存先にする
+type S3ObjectStore struct {
+	Client *s3.Client
+	Bucket string
+}
+
+func (s *S3ObjectStore) GetObject(ctx context.Context, key string) ([]byte, error) {
+	output, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return io.ReadAll(output.Body)
+}
+
+func (s *S3ObjectStore) OpenObject(ctx context.Context, key string) (*Object, error) {
+	output, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	size := int64(-1)
+	if output.ContentLength != nil {
+		size = *output.ContentLength
+	}
+	return &Object{
+		Body:        output.Body,
+		ContentType: objectContentType(key, aws.ToString(output.ContentType)),
+		Size:        size,
+	}, nil
+}
+
+func (s *S3ObjectStore) PresignGetObject(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	request, err := s3.NewPresignClient(s.Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+	return request.URL, nil
+}
+
+func (s *S3ObjectStore) PutObject(ctx context.Context, key string, body []byte, contentType string) error {
+	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (s *S3ObjectStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Contents {
+			keys = append(keys, aws.ToString(item.Key))
+		}
+	}
+	return keys, nil
+}
+
+// ローカルのディレクトリをファイルの保存先にする (開発・テスト用)
+type LocalObjectStore struct {
+	Dir string
+}
+
+func (s *LocalObjectStore) path(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(key))
+}
+
+func (s *LocalObjectStore) GetObject(ctx context.Context, key string) ([]byte, error) {
+	body, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return body, err
+}
+
+func (s *LocalObjectStore) OpenObject(ctx context.Context, key string) (*Object, error) {
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, ErrObjectNotFound
+	}
+	return &Object{Body: file, ContentType: objectContentType(key, ""), Size: info.Size()}, nil
+}
+
+func (s *LocalObjectStore) PutObject(ctx context.Context, key string, body []byte, contentType string) error {
+	path := s.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0o644)
+}
+
+func (s *LocalObjectStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+/*
+設定からニュースの保存先を決める
+
+NEWS_LOCAL_DIR が設定されている場合はローカルのディレクトリ、そうでなければ NEWS_BUCKET_NAME のバケット
+*/
+func NewNewsObjectStore(client *s3.Client) ObjectStore {
+	if dir := conf.NewsLocalDir; dir != "" {
+		return &LocalObjectStore{Dir: dir}
+	}
+	return &S3ObjectStore{Client: client, Bucket: conf.NewsBucketName}
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+// 企業の概要の項目名 (取得に失敗した場合に CompanyOverview.Missing に入れる)
+const (
+	overviewBS           = "bs"
+	overviewPL           = "pl"
+	overviewCF           = "cf"
+	overviewFundamentals = "fundamentals"
+	overviewPeriods      = "periods"
+	overviewNews         = "news"
+)
+
+var overviewReportTypes = []string{"BS", "PL", "CF"}
+
+// 書類のキー ({EDINETコード}/{BS|PL|CF}/{EDINETコード}-{docID}-{BS|PL|CF}-from-{期首}-to-{期末}.json)
+type reportKey struct {
+	Key         string
+	ReportType  string
+	PeriodStart string
+	PeriodEnd   string
+}
+
+func parseReportKey(key string) (reportKey, bool) {
+	segments := strings.Split(key, "/")
+	if len(segments) != 3 || !strings.HasSuffix(key, ".json") {
+		return reportKey{}, false
+	}
+	name := strings.TrimSuffix(segments[2], ".json")
+	from := strings.LastIndex(name, "-from-")
+	to := strings.LastIndex(name, "-to-")
+	if from < 0 || to < from {
+		return reportKey{}, false
+	}
+	return reportKey{
+		Key:         key,
+		ReportType:  segments[1],
+		PeriodStart: name[from+len("-from-") : to],
+		PeriodEnd:   name[to+len("-to-"):],
+	}, true
+}
+
+/*
+書類の一覧から種類ごとの最新の書類と、書類がある期間 (期末の新しい順) を返す
+*/
+func summarizeReportKeys(keys []string) (map[string]reportKey, []internal.ReportPeriod) {
+	latest := map[string]reportKey{}
+	periods := map[[2]string]*internal.ReportPeriod{}
+	for _, key := range keys {
+		report, ok := parseReportKey(key)
+		if !ok {
+			continue
+		}
+		switch report.ReportType {
+		case "BS", "PL", "CF":
+		default:
+			continue
+		}
+		if current, ok := latest[report.ReportType]; !ok || report.PeriodEnd > current.PeriodEnd || (report.PeriodEnd == current.PeriodEnd && report.Key > current.Key) {
+			latest[report.ReportType] = report
+		}
+		period, ok := periods[[2]string{report.PeriodStart, report.PeriodEnd}]
+		if !ok {
+			period = &internal.ReportPeriod{PeriodStart: report.PeriodStart, PeriodEnd: report.PeriodEnd}
+			periods[[2]string{report.PeriodStart, report.PeriodEnd}] = period
+		}
+		if !slices.Contains(period.Reports, report.ReportType) {
+			period.Reports = append(period.Reports, report.ReportType)
+		}
+	}
+
+	result := make([]internal.ReportPeriod, 0, len(periods))
+	for _, period := range periods {
+		sort.Strings(period.Reports)
+		result = append(result, *period)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].PeriodEnd != result[j].PeriodEnd {
+			return result[i].PeriodEnd > result[j].PeriodEnd
+		}
+		return result[i].PeriodStart > result[j].PeriodStart
+	})
+	return latest, result
+}
+
+func getReportJSON(ctx context.Context, key string, v interface{}) error {
+	body, err := reportStore.GetObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// 企業の財務指標を期末の古い順に返す
+func listFundamentals(ctx context.Context, EDINETCode string) ([]internal.Fundamental, error) {
+	keys, err := reportStore.ListObjects(ctx, EDINETCode+"/Fundamentals/")
+	if err != nil {
+		return nil, err
+	}
+	fundamentals := make([]internal.Fundamental, len(keys))
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	// S3 への同時リクエスト数
+	sem := make(chan struct{}, 8)
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = getReportJSON(ctx, key, &fundamentals[i])
+		}(i, key)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(fundamentals, func(i, j int) bool {
+		return fundamentals[i].PeriodEnd < fundamentals[j].PeriodEnd
+	})
+	return fundamentals, nil
+}
+
+/*
+企業の概要 (企業・最新の BS / PL / CF・財務指標・書類がある期間・直近のニュース) をまとめて取得する
+
+各項目は並行して取得し、OVERVIEW_TIMEOUT (デフォルト 3s) 以内に取得できなかった項目は Missing に入れて残りを返す
+*/
+func GetCompanyOverviewProcessor(ctx context.Context, companyID string) (*internal.CompanyOverview, error) {
+	company, err := GetCompanyProcessor(companyID)
+	if err != nil {
+		return nil, err
+	}
+	if company.ID == "" {
+		return nil, NewError(http.StatusNotFound, "企業が見つかりません")
+	}
+	return buildCompanyOverview(ctx, company), nil
+}
+
+func buildCompanyOverview(ctx context.Context, company internal.Company) *internal.CompanyOverview {
+	companyID := company.ID
+	overview := &internal.CompanyOverview{Company: company, Missing: []string{}}
+	var mu sync.Mutex
+	missing := func(name string, err error) {
+		Logger(ctx).Warn("get company overview failed", "companyId", companyID, "item", name, "error", err)
+		mu.Lock()
+		defer mu.Unlock()
+		overview.Missing = append(overview.Missing, name)
+	}
+
+	var wg sync.WaitGroup
+	run := func(f func(ctx context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, conf.OverviewTimeout)
+			defer cancel()
+			f(ctx)
+		}()
+	}
+
+	run(func(ctx context.Context) {
+		keys, err := reportStore.ListObjects(ctx, company.EDINETCode+"/")
+		if err != nil {
+			for _, name := range []string{overviewBS, overviewPL, overviewCF, overviewPeriods} {
+				missing(name, err)
+			}
+			return
+		}
+		latest, periods := summarizeReportKeys(keys)
+		mu.Lock()
+		overview.Periods = periods
+		mu.Unlock()
+
+		var reportWG sync.WaitGroup
+		for _, reportType := range overviewReportTypes {
+			report, ok := latest[reportType]
+			if !ok {
+				continue
+			}
+			reportWG.Add(1)
+			go func(report reportKey) {
+				defer reportWG.Done()
+				var target interface{}
+				switch report.ReportType {
+				case "BS":
+					target = &internal.Summary{}
+				case "PL":
+					target = &internal.PLSummary{}
+				case "CF":
+					target = &internal.CFSummary{}
+				}
+				err := getReportJSON(ctx, report.Key, target)
+				if err != nil {
+					missing(strings.ToLower(report.ReportType), err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				switch v := target.(type) {
+				case *internal.Summary:
+					overview.BS = v
+				case *internal.PLSummary:
+					overview.PL = v
+				case *internal.CFSummary:
+					overview.CF = v
+				}
+			}(report)
+		}
+		reportWG.Wait()
+	})
+
+	run(func(ctx context.Context) {
+		fundamentals, err := listFundamentals(ctx, company.EDINETCode)
+		if err != nil {
+			missing(overviewFundamentals, err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		overview.Fundamentals = fundamentals
+	})
+
+	run(func(ctx context.Context) {
+		newsList, err := listCompanyNews(ctx, company, "", "", "")
+		if err == nil {
+			// 時間内に取得できなかった版は除かれるため、途中で打ち切られた場合は取得できなかったものとする
+			err = ctx.Err()
+		}
+		if err != nil {
+			missing(overviewNews, err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		overview.News = newsList
+	})
+
+	wg.Wait()
+	if overview.Fundamentals == nil && !slices.Contains(overview.Missing, overviewFundamentals) {
+		overview.Fundamentals = []internal.Fundamental{}
+	}
+	if overview.Periods == nil && !slices.Contains(overview.Missing, overviewPeriods) {
+		overview.Periods = []internal.ReportPeriod{}
+	}
+	sort.Strings(overview.Missing)
+	return overview
+}
+
+func GetCompanyOverviewGin(c *gin.Context) {
+	overview, err := GetCompanyOverviewProcessor(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, overview)
+}
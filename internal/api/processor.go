@@ -6,21 +6,23 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os"
+	"log/slog"
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
 	"github.com/joe-black-jb/compass-api/internal"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func GetCompaniesProcessor(limit string) ([]internal.Company, error) {
-	fmt.Println("==== GetCompanies ====")
 	var companies []internal.Company
 	// pagination 用
 	var lastEvaluatedKey map[string]types.AttributeValue
@@ -28,7 +30,7 @@ func GetCompaniesProcessor(limit string) ([]internal.Company, error) {
 	if limit == "" {
 		for {
 			scanInput := &dynamodb.ScanInput{
-				TableName: aws.String("compass_companies"),
+				TableName: aws.String(conf.CompaniesTableName),
 				Limit:     aws.Int32(50),
 			}
 			if lastEvaluatedKey != nil {
@@ -36,7 +38,7 @@ func GetCompaniesProcessor(limit string) ([]internal.Company, error) {
 			}
 			result, err := dynamoClient.Scan(context.TODO(), scanInput)
 			if err != nil {
-				fmt.Println("scan err: ", err)
+				slog.Error("scan companies failed", "error", err)
 				return nil, err
 			}
 
@@ -44,7 +46,7 @@ func GetCompaniesProcessor(limit string) ([]internal.Company, error) {
 			// 取得したアイテムを Company 構造体に変換
 			err = attributevalue.UnmarshalListOfMaps(result.Items, &batch)
 			if err != nil {
-				fmt.Println("unMarshal err: ", err)
+				slog.Error("unmarshal companies failed", "error", err)
 				return nil, err
 			}
 
@@ -62,12 +64,12 @@ func GetCompaniesProcessor(limit string) ([]internal.Company, error) {
 		}
 		limitInt32 := int32(limitInt)
 		scanInput := &dynamodb.ScanInput{
-			TableName: aws.String("compass_companies"),
+			TableName: aws.String(conf.CompaniesTableName),
 			Limit:     aws.Int32(limitInt32),
 		}
 		result, err := dynamoClient.Scan(context.TODO(), scanInput)
 		if err != nil {
-			fmt.Println("scan err: ", err)
+			slog.Error("scan companies failed", "error", err)
 			return nil, err
 		}
 
@@ -75,7 +77,7 @@ func GetCompaniesProcessor(limit string) ([]internal.Company, error) {
 		// 取得したアイテムを Place 構造体に変換
 		err = attributevalue.UnmarshalListOfMaps(result.Items, &batch)
 		if err != nil {
-			fmt.Println("unMarshal err: ", err)
+			slog.Error("unmarshal companies failed", "error", err)
 			return nil, err
 		}
 		companies = append(companies, batch...)
@@ -88,18 +90,16 @@ func GetCompanyProcessor(companyId string) (internal.Company, error) {
 	var company internal.Company
 
 	getItemInput := &dynamodb.GetItemInput{
-		TableName: aws.String("compass_companies"),
+		TableName: aws.String(conf.CompaniesTableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: companyId}, // 取得したい id の値を指定
 		},
 	}
 	getItemOutput, err := dynamoClient.GetItem(context.TODO(), getItemInput)
 	if err != nil {
-		getItemNgMsg := fmt.Sprintf("「%s」getItem error: %v", company.Name, err)
-		fmt.Println(getItemNgMsg)
+		slog.Error("get company failed", "companyId", companyId, "error", err)
 		return internal.Company{}, err
 	}
-	fmt.Println("getItemOutput ⭐️: ", getItemOutput)
 	err = attributevalue.UnmarshalMap(getItemOutput.Item, &company)
 	if err != nil {
 		return internal.Company{}, err
@@ -111,7 +111,7 @@ func SearchCompaniesByNameProcessor(companyName string) ([]internal.Company, err
 	if companyName == "" {
 		return nil, errors.New("企業名を指定してください")
 	}
-	companies, err := ScanCompaniesByName(dynamoClient, "compass_companies", companyName)
+	companies, err := ScanCompaniesByName(dynamoClient, conf.CompaniesTableName, companyName)
 	if err != nil {
 		return nil, err
 	}
@@ -119,74 +119,27 @@ func SearchCompaniesByNameProcessor(companyName string) ([]internal.Company, err
 }
 
 func GetReportsProcessor(EDINETCode string, reportType string, extension string) ([]internal.ReportData, error) {
-	// S3 から BS HTML 一覧を取得
-	bucketName := os.Getenv("BUCKET_NAME")
-	// プレフィックス (ディレクトリのようなもの)
-	prefix := fmt.Sprintf("%s/", EDINETCode)
-
-	// ListObjectsV2Inputを使って、特定のプレフィックスにマッチするオブジェクトをリストアップ
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucketName),
-		Prefix: aws.String(prefix),
-	}
-
-	// オブジェクト一覧を取得
-	result, err := s3Client.ListObjectsV2(context.TODO(), input)
+	ctx := context.TODO()
+	keys, err := listReportKeys(ctx, EDINETCode, reportType, extension)
 	if err != nil {
-		log.Fatalf("failed to list objects, %v", err)
-	}
-
-	var keys []string
-	// .htmlファイルだけをフィルタリング
-	for _, item := range result.Contents {
-		key := *item.Key
-		if extension == "html" {
-			if strings.HasSuffix(key, ".html") {
-				splitFileName := strings.Split(key, "/")
-				if len(splitFileName) >= 2 {
-					fileType := splitFileName[1] // BS or PL
-					if (reportType == "BS" && fileType == "BS") || (reportType == "PL" && fileType == "PL") || (reportType == "CF" && fileType == "CF") {
-						keys = append(keys, key)
-					}
-				}
-			}
-		}
-		if extension == "json" {
-			if strings.HasSuffix(key, ".json") {
-				splitFileName := strings.Split(key, "/")
-				if len(splitFileName) >= 2 {
-					fileType := splitFileName[1] // BS or PL
-					if (reportType == "BS" && fileType == "BS") || (reportType == "PL" && fileType == "PL") || (reportType == "CF" && fileType == "CF") {
-						keys = append(keys, key)
-					}
-				}
-			}
-		}
+		return nil, err
 	}
-	// fmt.Println("取得対象ファイル: ", keys)
 
 	var reportData []internal.ReportData
 	// レポートファイルの中身を取得
 	for _, key := range keys {
-		input := &s3.GetObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(key),
-		}
-		// オブジェクトを取得
-		result, err := s3Client.GetObject(context.TODO(), input)
-		if err != nil {
-			log.Fatalf("failed to get object, %v", err)
-		}
-		// fmt.Println("取得した object: ", result)
-		fmt.Println("取得した object の Body: ", result.Body)
-		body, err := io.ReadAll(result.Body)
+		body, err := reportStore.GetObject(ctx, key)
 		if err != nil {
-			fmt.Println("io.ReadAll err: ", err)
+			slog.Error("read report failed", "key", key, "error", err)
 			return nil, err
 		}
 		var data internal.ReportData
 		data.FileName = key
 		data.Data = string(body)
+		if strings.HasSuffix(key, ".html") {
+			// 取り込み前に保存された HTML もあるため、返す時にも無害化する
+			data.Data = SanitizeReportHTML(data.Data)
+		}
 		reportData = append(reportData, data)
 	}
 	return reportData, nil
@@ -203,7 +156,7 @@ func GetFundamentalsProcessor(EDINETCode string, bucketName string, prefix strin
 	result, err := s3Client.ListObjectsV2(context.TODO(), input)
 	if err != nil {
 		// log.Fatalf("failed to list objects, %v", err)
-		fmt.Println("failed to list fundamentals objects: ", err)
+		slog.Error("failed to list fundamentals objects", "edinetCode", EDINETCode, "error", err)
 	}
 
 	// var keys []string
@@ -212,25 +165,24 @@ func GetFundamentalsProcessor(EDINETCode string, bucketName string, prefix strin
 	for _, item := range result.Contents {
 		var fundamental internal.Fundamental
 		key := *item.Key
-		fmt.Println("getObject key: ", key)
+		slog.Debug("get fundamental", "key", key)
 		// key を指定し json ファイルを取得
 		result, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
 			Bucket: aws.String(bucketName),
 			Key:    aws.String(key),
 		})
 		if err != nil {
-			fmt.Println("s3 getObject err: ", err)
+			slog.Error("get fundamental failed", "key", key, "error", err)
 			return nil, err
 		}
 		body, err := io.ReadAll(result.Body)
 		if err != nil {
-			fmt.Println("getObject io.ReadAll err: ", err)
+			slog.Error("read fundamental failed", "key", key, "error", err)
 			return nil, err
 		}
-		fmt.Println("string(body): ", string(body))
 		err = json.Unmarshal(body, &fundamental)
 		if err != nil {
-			fmt.Println("s3 getObject Unmarshal err: ", err)
+			slog.Error("unmarshal fundamental failed", "key", key, "error", err)
 			return nil, err
 		}
 		fundamentals = append(fundamentals, fundamental)
@@ -238,29 +190,98 @@ func GetFundamentalsProcessor(EDINETCode string, bucketName string, prefix strin
 	return fundamentals, nil
 }
 
-func GetLatestNewsProcessor(newsBucketName string, latestFileKey string) (string, error) {
-	output, err := GetS3Object(s3Client, newsBucketName, latestFileKey)
-	if err != nil {
-		return "", err
+func RegisterUserProcessor(ctx context.Context, reqBody internal.RegisterUserBody) error {
+	var missing []string
+	if reqBody.Name == nil {
+		missing = append(missing, "名前")
+	}
+	if reqBody.Password == nil {
+		missing = append(missing, "パスワード")
+	}
+	if reqBody.Email == nil {
+		missing = append(missing, "メールアドレス")
+	}
+	if len(missing) > 0 {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("未入力の項目があります。項目: %v", missing))
 	}
-	if output == nil {
-		return "", errors.New("No Data")
+	if !isValidEmail(*reqBody.Email) {
+		return NewError(http.StatusBadRequest, "メールアドレスの形式が正しくありません")
 	}
-	body, err := io.ReadAll(output.Body)
+	hash, err := bcrypt.GenerateFromPassword([]byte(*reqBody.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return "", err
+		return NewError(http.StatusInternalServerError, "パスワードの暗号化処理に失敗しました")
+	}
+	now := time.Now()
+	user := &internal.User{
+		ID:        uuid.NewString(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Name:      *reqBody.Name,
+		Email:     NormalizeEmail(*reqBody.Email),
+		Password:  hash,
+		Role:      string(RoleViewer),
+		Status:    UserStatusPending,
 	}
-	defer output.Body.Close()
 
-	var newsData internal.NewsResult
-	err = json.Unmarshal(body, &newsData)
+	// DB登録 (メールアドレスの重複は条件付き書き込みで検出する)
+	err = userRepository.CreateUser(ctx, user)
+	if errors.Is(err, ErrEmailAlreadyExists) {
+		return NewError(http.StatusBadRequest, "入力されたメールアドレスは既に登録されています")
+	}
 	if err != nil {
-		return "", err
+		Logger(ctx).Error("CreateUser failed", "error", err)
+		return NewError(http.StatusInternalServerError, "ユーザ登録処理に失敗しました")
+	}
+
+	// 確認メールの送信に失敗した場合は再送してもらう
+	if err := sendVerificationMail(ctx, user); err != nil {
+		Logger(ctx).Error("sendVerificationMail failed", "userId", user.ID, "error", err)
 	}
+	return nil
+}
+
+// 未登録のメールアドレスでも応答時間が変わらないよう比較に使うハッシュ
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("compass-dummy-password"), bcrypt.DefaultCost)
 
-	jsonBody, err := json.MarshalIndent(newsData, "", "  ")
+var errInvalidCredentials = NewError(http.StatusUnauthorized, "メールアドレスまたはパスワードが正しくありません")
+
+/*
+ログイン
+
+失敗回数はアカウントと IP ごとに記録し、上限に達した場合は一定時間ロックする
+メールアドレスの登録有無が分からないよう、失敗時のメッセージは共通にする
+*/
+func LoginProcessor(ctx context.Context, reqBody internal.Credentials, clientIP string) (*internal.Login, error) {
+	err := checkLoginAllowed(ctx, reqBody.Email, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	user, err := userRepository.GetUserByEmail(ctx, reqBody.Email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	hash := dummyPasswordHash
+	if user != nil {
+		hash = user.Password
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(reqBody.Password)); err != nil || user == nil {
+		if recordErr := recordLoginFailure(ctx, reqBody.Email, clientIP); recordErr != nil {
+			Logger(ctx).Error("recordLoginFailure failed", "error", recordErr)
+		}
+		return nil, errInvalidCredentials
+	}
+	if err := resetLoginFailures(ctx, reqBody.Email); err != nil {
+		Logger(ctx).Error("resetLoginFailures failed", "error", err)
+	}
+	if user.Status == UserStatusPending {
+		return nil, NewError(http.StatusForbidden, "メールアドレスの確認が完了していません")
+	}
+	// アクセストークンとリフレッシュトークンの発行
+	subject := tokenSubject{UserID: user.ID, Username: user.Name, Role: UserRole(user)}
+	loginResult, err := issueTokens(ctx, subject, "")
 	if err != nil {
-		return "", err
+		Logger(ctx).Error("issueTokens failed", "userId", user.ID, "error", err)
+		return nil, NewError(http.StatusInternalServerError, "Error while generating token")
 	}
-	return string(jsonBody), nil
+	return loginResult, nil
 }
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+)
+
+// トークンバケットの設定 (1秒あたりの補充数とバケットの容量)
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// トークン取得結果
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAt    time.Time     // バケットが満タンに戻る時刻
+	RetryAfter time.Duration // 拒否された場合に次のトークンが補充されるまでの時間
+}
+
+// レート制限を適用するバケット
+type RateLimitBucket struct {
+	Key   string
+	Limit RateLimit
+}
+
+// レート制限の状態の保存先
+type RateLimitStore interface {
+	/*
+		全てのバケットにトークンがある場合のみ、それぞれから 1 つずつ取得する (結果は buckets の順)
+
+		いずれかのバケットで拒否された場合は、どのバケットのトークンも消費しない
+	*/
+	Take(ctx context.Context, buckets []RateLimitBucket) ([]RateLimitResult, error)
+}
+
+// 経過時間分のトークンを補充する
+func refillTokens(tokens float64, updatedAt time.Time, now time.Time, limit RateLimit) float64 {
+	burst := float64(limit.Burst)
+	if updatedAt.IsZero() {
+		return burst
+	}
+	elapsed := now.Sub(updatedAt).Seconds()
+	return math.Min(burst, tokens+elapsed*limit.RequestsPerSecond)
+}
+
+/*
+補充済みのトークンを take の場合のみ 1 つ消費する
+
+@return 消費後のトークン数と結果
+*/
+func takeToken(tokens float64, now time.Time, limit RateLimit, take bool) (float64, RateLimitResult) {
+	result := RateLimitResult{Limit: limit.Burst}
+	if take {
+		tokens--
+		result.Allowed = true
+	} else if tokens < 1 {
+		wait := (1 - tokens) / limit.RequestsPerSecond
+		result.RetryAfter = time.Duration(wait * float64(time.Second))
+	}
+	result.Remaining = int(math.Floor(tokens))
+	fill := (float64(limit.Burst) - tokens) / limit.RequestsPerSecond
+	result.ResetAt = now.Add(time.Duration(fill * float64(time.Second)))
+	return tokens, result
+}
+
+/*
+補充済みのトークン数から全てのバケットの結果を決める
+
+@return 消費後のトークン数と結果 (いずれかのバケットにトークンがない場合は全て拒否し、消費しない)
+*/
+func takeTokens(tokens []float64, now time.Time, buckets []RateLimitBucket) ([]float64, []RateLimitResult) {
+	allowed := true
+	for _, t := range tokens {
+		if t < 1 {
+			allowed = false
+		}
+	}
+	taken := make([]float64, len(buckets))
+	results := make([]RateLimitResult, len(buckets))
+	for i, bucket := range buckets {
+		taken[i], results[i] = takeToken(tokens[i], now, bucket.Limit, allowed)
+	}
+	return taken, results
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// メモリ上でレート制限の状態を保持する (ローカルサーバー用)
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	takes   int
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, buckets []RateLimitBucket) ([]RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	tokens := make([]float64, len(buckets))
+	for i, b := range buckets {
+		if bucket, ok := s.buckets[b.Key]; ok {
+			tokens[i] = refillTokens(bucket.tokens, bucket.updatedAt, now, b.Limit)
+		} else {
+			tokens[i] = refillTokens(0, time.Time{}, now, b.Limit)
+		}
+	}
+	tokens, results := takeTokens(tokens, now, buckets)
+	for i, b := range buckets {
+		s.buckets[b.Key] = &tokenBucket{tokens: tokens[i], updatedAt: now}
+	}
+
+	// 一定回数ごとにしばらく使われていないバケットを削除する
+	s.takes++
+	if s.takes%1000 == 0 {
+		for k, b := range s.buckets {
+			if now.Sub(b.updatedAt) > 10*time.Minute {
+				delete(s.buckets, k)
+			}
+		}
+	}
+	return results, nil
+}
+
+/*
+DynamoDB でレート制限の状態を共有する (複数の Lambda インスタンス間で制限を適用するため)
+
+テーブル: パーティションキー id (S)、TTL 属性 ttl
+*/
+type DynamoRateLimitStore struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+// 楽観ロックの競合時の再試行回数
+const rateLimitMaxRetries = 3
+
+// バケットの状態 (prevUpdatedAt は楽観ロックに使う)
+type dynamoTokenBucket struct {
+	tokens        float64
+	updatedAt     time.Time
+	prevUpdatedAt string
+}
+
+func (s *DynamoRateLimitStore) getBucket(ctx context.Context, key string) (dynamoTokenBucket, error) {
+	output, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.TableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: key},
+		},
+	})
+	if err != nil {
+		return dynamoTokenBucket{}, err
+	}
+	var bucket dynamoTokenBucket
+	if output.Item != nil {
+		if v, ok := output.Item["tokens"].(*types.AttributeValueMemberN); ok {
+			bucket.tokens, _ = strconv.ParseFloat(v.Value, 64)
+		}
+		if v, ok := output.Item["updatedAt"].(*types.AttributeValueMemberN); ok {
+			bucket.prevUpdatedAt = v.Value
+			nanos, _ := strconv.ParseInt(v.Value, 10, 64)
+			bucket.updatedAt = time.Unix(0, nanos)
+		}
+	}
+	return bucket, nil
+}
+
+func (s *DynamoRateLimitStore) Take(ctx context.Context, buckets []RateLimitBucket) ([]RateLimitResult, error) {
+	for i := 0; i < rateLimitMaxRetries; i++ {
+		states := make([]dynamoTokenBucket, len(buckets))
+		for j, b := range buckets {
+			state, err := s.getBucket(ctx, b.Key)
+			if err != nil {
+				return nil, err
+			}
+			states[j] = state
+		}
+
+		now := time.Now()
+		tokens := make([]float64, len(buckets))
+		for j, b := range buckets {
+			tokens[j] = refillTokens(states[j].tokens, states[j].updatedAt, now, b.Limit)
+		}
+		tokens, results := takeTokens(tokens, now, buckets)
+		// 拒否した場合はトークンを消費しないため書き込まない
+		if !results[0].Allowed {
+			return results, nil
+		}
+
+		items := make([]types.TransactWriteItem, len(buckets))
+		for j, b := range buckets {
+			put := &types.Put{
+				TableName: aws.String(s.TableName),
+				Item: map[string]types.AttributeValue{
+					"id":        &types.AttributeValueMemberS{Value: b.Key},
+					"tokens":    &types.AttributeValueMemberN{Value: strconv.FormatFloat(tokens[j], 'f', -1, 64)},
+					"updatedAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixNano(), 10)},
+					"ttl":       &types.AttributeValueMemberN{Value: strconv.FormatInt(results[j].ResetAt.Add(time.Hour).Unix(), 10)},
+				},
+			}
+			// 読み込んでから書き込むまでに他のインスタンスが更新していないことを条件にする
+			if states[j].prevUpdatedAt == "" {
+				put.ConditionExpression = aws.String("attribute_not_exists(id)")
+			} else {
+				put.ConditionExpression = aws.String("updatedAt = :prev")
+				put.ExpressionAttributeValues = map[string]types.AttributeValue{
+					":prev": &types.AttributeValueMemberN{Value: states[j].prevUpdatedAt},
+				}
+			}
+			items[j] = types.TransactWriteItem{Put: put}
+		}
+		// 全てのバケットをまとめて更新する (一部のバケットだけ消費しないように)
+		_, err := s.Client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+		var canceledErr *types.TransactionCanceledException
+		if errors.As(err, &canceledErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+	return nil, fmt.Errorf("rate limit update conflict: %s", buckets[0].Key)
+}
+
+// 設定からレート制限の保存先を決める
+func newRateLimitStore() RateLimitStore {
+	if tableName := conf.RateLimitTableName; tableName != "" {
+		return &DynamoRateLimitStore{Client: dynamoClient, TableName: tableName}
+	}
+	return NewMemoryRateLimitStore()
+}
+
+// クライアント IP ごとのレート制限 (RATE_LIMIT_IP_RPS, RATE_LIMIT_IP_BURST で変更可能)
+func ipRateLimit() RateLimit {
+	return RateLimit{RequestsPerSecond: conf.RateLimitIPRPS, Burst: conf.RateLimitIPBurst}
+}
+
+// バケットのトークンを消費する (どれかが上限を超えた場合はどれも消費しない)
+func takeRateLimit(ctx context.Context, buckets []RateLimitBucket) (*RateLimitResult, error) {
+	results, err := rateLimitStore.Take(ctx, buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	// 拒否した場合は待ち時間が最も長いバケット、許可した場合は最後のバケットの結果を返す
+	result := results[len(results)-1]
+	if !result.Allowed {
+		for _, r := range results {
+			if r.RetryAfter > result.RetryAfter {
+				result = r
+			}
+		}
+	}
+	return &result, nil
+}
+
+// クライアント IP のレート制限を確認する (無効な API キーの大量送信も制限するため、キーの確認より前に行う)
+func CheckIPRateLimit(ctx context.Context, clientIP string) (*RateLimitResult, error) {
+	if rateLimitStore == nil {
+		return nil, nil
+	}
+	return takeRateLimit(ctx, []RateLimitBucket{{Key: "ip#" + clientIP, Limit: ipRateLimit()}})
+}
+
+// API キーの利用プランのレート制限を確認する (キーの認証後に行う。キーがない場合は nil)
+func CheckAPIKeyRateLimit(ctx context.Context) (*RateLimitResult, error) {
+	apiKey, plan := APIKeyFromContext(ctx)
+	if rateLimitStore == nil || apiKey == nil || plan == nil {
+		return nil, nil
+	}
+	limit := RateLimit{RequestsPerSecond: plan.RequestsPerSecond, Burst: plan.Burst}
+	return takeRateLimit(ctx, []RateLimitBucket{{Key: "key#" + apiKey.ID, Limit: limit}})
+}
+
+// レート制限のレスポンスヘッダー
+func rateLimitHeaders(result *RateLimitResult) map[string]string {
+	headers := map[string]string{
+		"X-RateLimit-Limit":     strconv.Itoa(result.Limit),
+		"X-RateLimit-Remaining": strconv.Itoa(result.Remaining),
+		"X-RateLimit-Reset":     strconv.FormatInt(result.ResetAt.Unix(), 10),
+	}
+	if !result.Allowed {
+		headers["Retry-After"] = strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds())))
+	}
+	return headers
+}
+
+var errRateLimitExceeded = NewError(http.StatusTooManyRequests, "リクエスト数が上限を超えました。時間をおいて再度お試しください")
+
+func rateLimitMiddleware(check func(c *gin.Context) (*RateLimitResult, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := check(c)
+		if err != nil {
+			// 保存先の障害でAPI全体を止めないよう、制限せずに通す
+			Logger(c.Request.Context()).Error("rate limit check failed", "error", err)
+			c.Next()
+			return
+		}
+		if result == nil {
+			c.Next()
+			return
+		}
+		// API キーの制限を確認した場合は、ヘッダーをキーの制限で上書きする
+		for k, v := range rateLimitHeaders(result) {
+			c.Header(k, v)
+		}
+		if !result.Allowed {
+			ginError(c, errRateLimitExceeded)
+			return
+		}
+		c.Next()
+	}
+}
+
+// クライアント IP のレート制限ミドルウェア (gin、APIKeyMiddleware の前に使う)
+func IPRateLimitMiddleware() gin.HandlerFunc {
+	return rateLimitMiddleware(func(c *gin.Context) (*RateLimitResult, error) {
+		return CheckIPRateLimit(c.Request.Context(), c.ClientIP())
+	})
+}
+
+// API キーのレート制限ミドルウェア (gin、APIKeyMiddleware の後に使う)
+func APIKeyRateLimitMiddleware() gin.HandlerFunc {
+	return rateLimitMiddleware(func(c *gin.Context) (*RateLimitResult, error) {
+		return CheckAPIKeyRateLimit(c.Request.Context())
+	})
+}
+
+func withRateLimit(check func(ctx context.Context, req events.APIGatewayProxyRequest) (*RateLimitResult, error), next LambdaHandler) LambdaHandler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		result, err := check(ctx, req)
+		if err != nil {
+			Logger(ctx).Error("rate limit check failed", "error", err)
+			return next(ctx, req)
+		}
+		if result == nil {
+			return next(ctx, req)
+		}
+		var res events.APIGatewayProxyResponse
+		if !result.Allowed {
+			res, err = errorResponse(errRateLimitExceeded)
+		} else {
+			res, err = next(ctx, req)
+		}
+		// 内側で API キーの制限を確認した場合は、キーの制限のヘッダーを残す
+		if res.Headers["X-RateLimit-Limit"] == "" {
+			setHeaders(&res, rateLimitHeaders(result))
+		}
+		return res, err
+	}
+}
+
+// クライアント IP のレート制限ミドルウェア (Lambda、WithAPIKey の外側で使う)
+func WithIPRateLimit(next LambdaHandler) LambdaHandler {
+	return withRateLimit(func(ctx context.Context, req events.APIGatewayProxyRequest) (*RateLimitResult, error) {
+		return CheckIPRateLimit(ctx, req.RequestContext.Identity.SourceIP)
+	}, next)
+}
+
+// API キーのレート制限ミドルウェア (Lambda、WithAPIKey の内側で使う)
+func WithAPIKeyRateLimit(next LambdaHandler) LambdaHandler {
+	return withRateLimit(func(ctx context.Context, req events.APIGatewayProxyRequest) (*RateLimitResult, error) {
+		return CheckAPIKeyRateLimit(ctx)
+	}, next)
+}
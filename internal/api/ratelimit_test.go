@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+func TestRefillTokens(t *testing.T) {
+	limit := RateLimit{RequestsPerSecond: 2, Burst: 10}
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		tokens    float64
+		updatedAt time.Time
+		want      float64
+	}{
+		{"初回は満タン", 0, time.Time{}, 10},
+		{"経過時間分を補充する", 1, now.Add(-2 * time.Second), 5},
+		{"容量を超えない", 9, now.Add(-time.Minute), 10},
+		{"経過時間なし", 0.5, now, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refillTokens(tt.tokens, tt.updatedAt, now, limit); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTakeToken(t *testing.T) {
+	limit := RateLimit{RequestsPerSecond: 2, Burst: 10}
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+	tokens, result := takeToken(10, now, limit, true)
+	if tokens != 9 || !result.Allowed || result.Remaining != 9 || result.Limit != 10 {
+		t.Errorf("tokens = %v, result = %+v", tokens, result)
+	}
+	// 1 トークン分 (0.5 秒) で満タンに戻る
+	if want := now.Add(500 * time.Millisecond); !result.ResetAt.Equal(want) {
+		t.Errorf("ResetAt = %v, want %v", result.ResetAt, want)
+	}
+
+	tokens, result = takeToken(0.5, now, limit, false)
+	if tokens != 0.5 || result.Allowed || result.Remaining != 0 {
+		t.Errorf("tokens = %v, result = %+v", tokens, result)
+	}
+	// 残り 0.5 トークンの補充 (0.25 秒) を待つ
+	if result.RetryAfter != 250*time.Millisecond {
+		t.Errorf("RetryAfter = %v", result.RetryAfter)
+	}
+	if header := rateLimitHeaders(&result)["Retry-After"]; header != "1" {
+		t.Errorf("Retry-After = %q", header)
+	}
+
+	// 他のバケットで拒否した場合はトークンがあっても消費せず、待ち時間もない
+	tokens, result = takeToken(5, now, limit, false)
+	if tokens != 5 || result.Allowed || result.RetryAfter != 0 || result.Remaining != 5 {
+		t.Errorf("tokens = %v, result = %+v", tokens, result)
+	}
+}
+
+func TestMemoryRateLimitStoreBurst(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	buckets := []RateLimitBucket{{Key: "ip#192.0.2.1", Limit: RateLimit{RequestsPerSecond: 0.001, Burst: 3}}}
+	for i := 0; i < 3; i++ {
+		results, err := store.Take(context.Background(), buckets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !results[0].Allowed || results[0].Remaining != 2-i {
+			t.Fatalf("%d 回目: %+v", i+1, results[0])
+		}
+	}
+	results, err := store.Take(context.Background(), buckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Allowed || results[0].RetryAfter <= 0 {
+		t.Errorf("容量を超えたリクエストを許可しました: %+v", results[0])
+	}
+}
+
+// ハッシュ化したキーで API キーを返し、取得回数を数える
+type countingAPIKeyStore struct {
+	keys  map[string]*internal.APIKey
+	calls int
+}
+
+func (s *countingAPIKeyStore) GetAPIKey(ctx context.Context, keyHash string) (*internal.APIKey, error) {
+	s.calls++
+	apiKey, ok := s.keys[keyHash]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return apiKey, nil
+}
+
+func TestRateLimitBeforeAPIKeyLookup(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	keyStore := &countingAPIKeyStore{keys: map[string]*internal.APIKey{
+		HashAPIKey("valid-key"): {ID: "key-1", Plan: "free"},
+	}}
+	prevStore, prevKeyStore, prevRPS, prevBurst := rateLimitStore, apiKeyStore, conf.RateLimitIPRPS, conf.RateLimitIPBurst
+	rateLimitStore, apiKeyStore = store, keyStore
+	conf.RateLimitIPRPS, conf.RateLimitIPBurst = 0.001, 8
+	t.Cleanup(func() {
+		rateLimitStore, apiKeyStore, conf.RateLimitIPRPS, conf.RateLimitIPBurst = prevStore, prevKeyStore, prevRPS, prevBurst
+	})
+
+	handler := WithIPRateLimit(WithAPIKey(WithAPIKeyRateLimit(func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	})))
+	request := func(ip string, key string) events.APIGatewayProxyResponse {
+		req := events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Headers: map[string]string{apiKeyHeader: key}}
+		req.RequestContext.Identity.SourceIP = ip
+		res, err := handler(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// 無効なキーの大量送信は IP の制限を超えるとキーを確認せずに拒否する
+	statuses := map[int]int{}
+	for i := 0; i < 12; i++ {
+		statuses[request("192.0.2.1", "invalid-key").StatusCode]++
+	}
+	if statuses[http.StatusUnauthorized] != 8 || statuses[http.StatusTooManyRequests] != 4 || keyStore.calls != 8 {
+		t.Errorf("statuses = %v, GetAPIKey = %d 回", statuses, keyStore.calls)
+	}
+
+	// キーの利用プラン (free: バースト 5) が IP の制限 (バースト 8) より厳しい
+	var allowed int
+	for i := 0; i < 7; i++ {
+		res := request("192.0.2.2", "valid-key")
+		if res.StatusCode == http.StatusOK {
+			allowed++
+		} else if res.StatusCode != http.StatusTooManyRequests || res.Headers["X-RateLimit-Limit"] != "5" {
+			// 拒否したキーの制限を返す
+			t.Errorf("status = %d, headers = %v", res.StatusCode, res.Headers)
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("allowed = %d, want 5", allowed)
+	}
+	if tokens := math.Floor(store.buckets["ip#192.0.2.2"].tokens); tokens != 1 {
+		t.Errorf("IP のトークン = %v, want 1", tokens)
+	}
+
+	// IP で拒否した場合はキーのトークンを消費しない
+	store.buckets["ip#192.0.2.3"] = &tokenBucket{tokens: 0, updatedAt: time.Now()}
+	store.buckets["key#key-1"] = &tokenBucket{tokens: 2, updatedAt: time.Now()}
+	if res := request("192.0.2.3", "valid-key"); res.StatusCode != http.StatusTooManyRequests || res.Headers["X-RateLimit-Limit"] != "8" {
+		t.Errorf("status = %d, headers = %v", res.StatusCode, res.Headers)
+	}
+	if tokens := math.Floor(store.buckets["key#key-1"].tokens); tokens != 2 {
+		t.Errorf("キーのトークン = %v, want 2", tokens)
+	}
+}
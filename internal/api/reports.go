@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+// /reports の返し方 (mode)
+const (
+	// 書類の中身を JSON に含めて返す (デフォルト)
+	reportModeInline = "inline"
+	// 書類の中身の代わりに署名付き URL を返す
+	reportModeURL = "url"
+)
+
+// /reports/{key}/raw で返す書類の種類と拡張子
+var (
+	rawReportTypes      = []string{"BS", "PL", "CF", "Fundamentals"}
+	rawReportExtensions = []string{".html", ".json"}
+)
+
+func validateReportMode(mode string) error {
+	switch mode {
+	case "", reportModeInline, reportModeURL:
+		return nil
+	}
+	return NewError(http.StatusBadRequest, "mode には inline または url を指定してください")
+}
+
+// EDINET コード配下の書類のうち、種類 (BS / PL / CF) と拡張子 (html / json) が一致するもののキーを返す
+func listReportKeys(ctx context.Context, EDINETCode string, reportType string, extension string) ([]string, error) {
+	// プレフィックス (ディレクトリのようなもの)
+	prefix := fmt.Sprintf("%s/", EDINETCode)
+	objects, err := reportStore.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, key := range objects {
+		if (extension != "html" && extension != "json") || !strings.HasSuffix(key, "."+extension) {
+			continue
+		}
+		splitFileName := strings.Split(key, "/")
+		if len(splitFileName) < 2 {
+			continue
+		}
+		fileType := splitFileName[1] // BS or PL or CF
+		if (reportType == "BS" || reportType == "PL" || reportType == "CF") && fileType == reportType {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// /reports/{key}/raw のパス (キーの / はエスケープする)
+func reportRawPath(key string) string {
+	return "/reports/" + url.PathEscape(key) + "/raw"
+}
+
+/*
+書類の中身の代わりにリンクを返す
+
+S3 では REPORT_URL_TTL (デフォルト 5m) の間だけ有効な署名付き URL、ローカルの保存先では /reports/{key}/raw を返す
+HTML は無害化してから返すため、保存先に関わらず /reports/{key}/raw を返す (署名付き URL では S3 のファイルがそのまま返るため)
+*/
+func GetReportLinksProcessor(ctx context.Context, EDINETCode string, reportType string, extension string) ([]internal.ReportLink, error) {
+	keys, err := listReportKeys(ctx, EDINETCode, reportType, extension)
+	if err != nil {
+		return nil, err
+	}
+
+	presigner, canPresign := reportStore.(ObjectPresigner)
+	links := []internal.ReportLink{}
+	for _, key := range keys {
+		link := internal.ReportLink{
+			FileName:    key,
+			ContentType: objectContentType(key, ""),
+			URL:         reportRawPath(key),
+		}
+		if canPresign && path.Ext(key) != ".html" {
+			expiresAt := time.Now().Add(conf.ReportURLTTL)
+			link.URL, err = presigner.PresignGetObject(ctx, key, conf.ReportURLTTL)
+			if err != nil {
+				Logger(ctx).Error("presign report failed", "key", key, "error", err)
+				return nil, err
+			}
+			link.ExpiresAt = &expiresAt
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// /reports/{key}/raw で返せるキーか ({EDINETコード}/{BS|PL|CF|Fundamentals}/{ファイル名}.{html|json})
+func validateRawReportKey(key string) error {
+	segments := strings.Split(key, "/")
+	if len(segments) != 3 || path.Clean(key) != key || slices.Contains(segments, "..") {
+		return NewError(http.StatusBadRequest, "書類のキーが正しくありません")
+	}
+	if segments[0] == "" || !slices.Contains(rawReportTypes, segments[1]) || !slices.Contains(rawReportExtensions, path.Ext(segments[2])) {
+		return NewError(http.StatusBadRequest, "書類のキーが正しくありません")
+	}
+	return nil
+}
+
+// 書類を 1 件開く (Body は呼び出し側で閉じる)
+func OpenReportProcessor(ctx context.Context, key string) (*Object, error) {
+	if err := validateRawReportKey(key); err != nil {
+		return nil, err
+	}
+	object, err := reportStore.OpenObject(ctx, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, NewError(http.StatusNotFound, "書類が見つかりません")
+	}
+	if err != nil {
+		Logger(ctx).Error("open report failed", "key", key, "error", err)
+		return nil, err
+	}
+	if !strings.HasPrefix(object.ContentType, "text/html") {
+		return object, nil
+	}
+
+	// HTML は取り込み時に加えて返す時にも無害化する (無害化の前に保存されたものがあるため)
+	defer object.Body.Close()
+	body, err := io.ReadAll(object.Body)
+	if err != nil {
+		Logger(ctx).Error("read report failed", "key", key, "error", err)
+		return nil, err
+	}
+	sanitized := SanitizeReportHTML(string(body))
+	return &Object{
+		Body:        io.NopCloser(strings.NewReader(sanitized)),
+		ContentType: object.ContentType,
+		Size:        int64(len(sanitized)),
+	}, nil
+}
+
+// 書類のレスポンスヘッダー (HTML はスクリプトを実行させない)
+func rawReportHeaders(object *Object) map[string]string {
+	headers := map[string]string{
+		"Content-Type":           object.ContentType,
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, max-age=300",
+	}
+	if strings.HasPrefix(object.ContentType, "text/html") {
+		headers["Content-Security-Policy"] = "sandbox"
+	}
+	return headers
+}
+
+// テキストの書類はそのまま、それ以外は Base64 で返す (Lambda)
+func isTextContentType(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") || strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, "application/xml")
+}
+
+func GetReportRawGin(c *gin.Context) {
+	object, err := OpenReportProcessor(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	defer object.Body.Close()
+	headers := rawReportHeaders(object)
+	delete(headers, "Content-Type")
+	c.DataFromReader(http.StatusOK, object.Size, object.ContentType, object.Body, headers)
+}
+
+// 書類を 1 件返す (Lambda のレスポンスはストリーミングできないため、読み込んでから返す)
+func GetReportRaw(ctx context.Context, key string) (events.APIGatewayProxyResponse, error) {
+	object, err := OpenReportProcessor(ctx, key)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer object.Body.Close()
+	body, err := io.ReadAll(object.Body)
+	if err != nil {
+		Logger(ctx).Error("read report failed", "key", key, "error", err)
+		return errorResponse(err)
+	}
+	headers := rawReportHeaders(object)
+	headers["Content-Length"] = strconv.Itoa(len(body))
+	if isTextContentType(object.ContentType) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Headers: headers, Body: string(body)}, nil
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode:      http.StatusOK,
+		Headers:         headers,
+		Body:            base64.StdEncoding.EncodeToString(body),
+		IsBase64Encoded: true,
+	}, nil
+}
+
+// reports/{key}/raw のルーティング (キーの / は %2F にエスケープしてもしなくてもよい)
+func Reports(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	path := strings.Trim(req.PathParameters["path"], "/")
+	if req.HTTPMethod == http.MethodGet && strings.HasPrefix(path, "reports/") && strings.HasSuffix(path, "/raw") {
+		key, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "reports/"), "/raw"))
+		if err != nil {
+			return errorResponse(NewError(http.StatusBadRequest, "書類のキーが正しくありません"))
+		}
+		return GetReportRaw(ctx, key)
+	}
+	return errorResponse(NewError(http.StatusNotFound, "Not Found"))
+}
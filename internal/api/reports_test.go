@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 署名付き URL を発行できる保存先 (S3 の代わり)
+type presigningObjectStore struct {
+	LocalObjectStore
+}
+
+func (s *presigningObjectStore) PresignGetObject(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return "https://bucket.example/" + key + "?X-Amz-Signature=x", nil
+}
+
+func useReportStore(t *testing.T, store ObjectStore) {
+	t.Helper()
+	prev := reportStore
+	reportStore = store
+	t.Cleanup(func() { reportStore = prev })
+}
+
+func TestGetReportLinksProcessorNeverPresignsHTML(t *testing.T) {
+	ctx := context.Background()
+	store := &presigningObjectStore{LocalObjectStore{Dir: t.TempDir()}}
+	useReportStore(t, store)
+	for _, key := range []string{"E00001/BS/2024.html", "E00001/BS/2024.json"} {
+		if err := store.PutObject(ctx, key, []byte("x"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links, err := GetReportLinksProcessor(ctx, "E00001", "BS", "html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].URL != "/reports/E00001%2FBS%2F2024.html/raw" || links[0].ExpiresAt != nil {
+		t.Errorf("HTML のリンク = %+v", links)
+	}
+
+	links, err = GetReportLinksProcessor(ctx, "E00001", "BS", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || !strings.HasPrefix(links[0].URL, "https://bucket.example/") || links[0].ExpiresAt == nil {
+		t.Errorf("JSON のリンク = %+v", links)
+	}
+}
+
+func TestOpenReportProcessorSanitizesHTML(t *testing.T) {
+	ctx := context.Background()
+	store := &LocalObjectStore{Dir: t.TempDir()}
+	useReportStore(t, store)
+	// 無害化の前に保存された HTML
+	if err := store.PutObject(ctx, "E00001/PL/2024.html", []byte(`<td onclick="x()">1<script>alert(1)</script></td>`), "text/html; charset=utf-8"); err != nil {
+		t.Fatal(err)
+	}
+
+	object, err := OpenReportProcessor(ctx, "E00001/PL/2024.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Body.Close()
+	body, err := io.ReadAll(object.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "1" || object.Size != 1 {
+		t.Errorf("body = %q, size = %d", body, object.Size)
+	}
+	if rawReportHeaders(object)["Content-Security-Policy"] != "sandbox" {
+		t.Errorf("HTML に CSP がありません")
+	}
+}
+
+func TestValidateRawReportKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{"E00001/BS/2024.html", false},
+		{"E00001/Fundamentals/2024.json", false},
+		{"E00001/BS/2024.xbrl", true},
+		{"E00001/XX/2024.html", true},
+		{"../BS/2024.html", true},
+		{"E00001/BS/../../secret.json", true},
+		{"E00001//BS/2024.html", true},
+		{"/BS/2024.html", true},
+	}
+	for _, tt := range tests {
+		if err := validateRawReportKey(tt.key); (err != nil) != tt.wantErr {
+			t.Errorf("validateRawReportKey(%q) = %v, wantErr %v", tt.key, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+// Lambda のハンドラー関数 (ミドルウェアで包むために型を定義)
+type LambdaHandler func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+type contextKey string
+
+// ステータスコード付きのエラーを作成する
+func NewError(status int, message string) *internal.Error {
+	return &internal.Error{Status: status, Message: message}
+}
+
+// エラーに対応するステータスコードを返す (internal.Error 以外は 500)
+func errorStatus(err error) int {
+	var apiErr *internal.Error
+	if errors.As(err, &apiErr) && apiErr.Status != 0 {
+		return apiErr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// JSON レスポンスを作成する (Lambda)
+func jsonResponse(status int, v interface{}) (events.APIGatewayProxyResponse, error) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       "json.MarshalIndent Error",
+		}, err
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-type": "application/json",
+		},
+	}, nil
+}
+
+// エラーレスポンスを作成する (Lambda)
+func errorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	status := errorStatus(err)
+	errObj := &internal.Error{Status: status, Message: err.Error()}
+	if status == http.StatusInternalServerError {
+		// 内部エラーの詳細はクライアントに返さない
+		errObj.Message = "Error"
+	}
+	return jsonResponse(status, errObj)
+}
+
+// 存在しないパスへのリクエスト (Lambda)
+func NotFound(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return errorResponse(NewError(http.StatusNotFound, "Not Found"))
+}
+
+// エラーレスポンスを返す (gin)
+func ginError(c *gin.Context, err error) {
+	status := errorStatus(err)
+	errObj := &internal.Error{Status: status, Message: err.Error()}
+	if status == http.StatusInternalServerError {
+		errObj.Message = "Error"
+	}
+	c.AbortWithStatusJSON(status, errObj)
+}
+
+// ヘッダーを大文字小文字を区別せずに取得する (API Gateway はヘッダー名を正規化しないため)
+func getHeader(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// レスポンスにヘッダーを追加する (Lambda)
+func setHeaders(res *events.APIGatewayProxyResponse, headers map[string]string) {
+	if res.Headers == nil {
+		res.Headers = map[string]string{}
+	}
+	for k, v := range headers {
+		res.Headers[k] = v
+	}
+}
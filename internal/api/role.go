@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+// ユーザーの権限
+type Role string
+
+const (
+	RoleViewer  Role = "viewer"  // 閲覧のみ
+	RoleAnalyst Role = "analyst" // 分析機能の利用
+	RoleAdmin   Role = "admin"   // 管理操作
+)
+
+// 上位の権限は下位の権限を含む
+var roleLevels = map[Role]int{
+	RoleViewer:  1,
+	RoleAnalyst: 2,
+	RoleAdmin:   3,
+}
+
+func ParseRole(s string) (Role, bool) {
+	role := Role(s)
+	_, ok := roleLevels[role]
+	return role, ok
+}
+
+// ユーザーの権限を返す (role 未設定のユーザーは admin フラグから判定する)
+func UserRole(user *internal.User) Role {
+	if role, ok := ParseRole(user.Role); ok {
+		return role
+	}
+	if user.Admin {
+		return RoleAdmin
+	}
+	return RoleViewer
+}
+
+// トークンの権限を返す (role クレームがないトークンは admin クレームから判定する)
+func RoleFromClaims(claims jwt.MapClaims) Role {
+	if s, ok := claims["role"].(string); ok {
+		if role, ok := ParseRole(s); ok {
+			return role
+		}
+	}
+	if admin, _ := claims["admin"].(bool); admin {
+		return RoleAdmin
+	}
+	return RoleViewer
+}
+
+// role が required 以上の権限を持つか
+func (role Role) Allows(required Role) bool {
+	return roleLevels[role] >= roleLevels[required]
+}
+
+func errRoleRequired(required Role) *internal.Error {
+	return NewError(http.StatusForbidden, "この操作には "+string(required)+" 以上の権限が必要です")
+}
+
+// 必要な権限を持つユーザーのみ許可するミドルウェア (gin、AuthMiddleware の後に使う)
+func RequireRole(required Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := ClaimsFromContext(c.Request.Context())
+		if claims == nil || !RoleFromClaims(claims).Allows(required) {
+			ginError(c, errRoleRequired(required))
+			return
+		}
+		c.Next()
+	}
+}
+
+// 必要な権限を持つユーザーのみ許可するミドルウェア (Lambda、WithAuth の内側で使う)
+func WithRole(required Role, next LambdaHandler) LambdaHandler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		claims := ClaimsFromContext(ctx)
+		if claims == nil || !RoleFromClaims(claims).Allows(required) {
+			return errorResponse(errRoleRequired(required))
+		}
+		return next(ctx, req)
+	}
+}
+
+/*
+ユーザーの権限を変更する (管理者用)
+
+発行済みのアクセストークンには有効期限まで古い権限が残るが、
+リフレッシュ時にユーザーを再取得するため次回の更新で反映される
+*/
+func UpdateUserRoleProcessor(ctx context.Context, userID string, roleName string) (*internal.User, error) {
+	role, ok := ParseRole(roleName)
+	if !ok {
+		return nil, NewError(http.StatusBadRequest, "権限は viewer / analyst / admin のいずれかを指定してください")
+	}
+	user, err := userRepository.GetUserByID(ctx, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, NewError(http.StatusNotFound, "ユーザーが見つかりません")
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Role = string(role)
+	user.Admin = role == RoleAdmin
+	user.UpdatedAt = time.Now()
+	err = userRepository.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func UpdateUserRoleGin(c *gin.Context) {
+	var reqBody internal.UpdateUserRoleBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	userID := c.Param("id")
+	if userID == "" {
+		userID = c.Query("id")
+	}
+	user, err := UpdateUserRoleProcessor(c.Request.Context(), userID, reqBody.Role)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func UpdateUserRole(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var reqBody internal.UpdateUserRoleBody
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+	}
+	user, err := UpdateUserRoleProcessor(ctx, req.QueryStringParameters["id"], reqBody.Role)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, user)
+}
@@ -1,90 +1,83 @@
 package api
 
 import (
-	"fmt"
-	"net/http"
-	"os"
-	"strings"
+	"context"
+	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 )
 
 // JWT認証ミドルウェア
 func AuthMiddleware() gin.HandlerFunc {
-	var jwtSecret = os.Getenv("SECRET_KEY")
-	// var jwtSecret = []byte(os.Getenv("SECRET_KEY"))
 	return func(c *gin.Context) {
-		// Authorizationヘッダーからトークンを取得
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
-			return
-		}
-
-		// Bearer 部分を除去しトークンを取得
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
-			c.Abort()
-			return
-		}
-
-		// トークンの検証
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// 署名方法の確認
-			if token.Method.Alg() != "HS256" {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(jwtSecret), nil
-		})
+		// Authorizationヘッダーからトークンを取得し検証
+		claims, err := AuthenticateToken(c.Request.Context(), c.GetHeader("Authorization"))
 		if err != nil {
-			fmt.Println("err ❗️ : ", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			// c.JSON(http.StatusUnauthorized, err.Error())
-			c.JSON(http.StatusUnauthorized, token)
-			c.Abort()
+			ginError(c, err)
 			return
 		}
 
-		// トークンが有効か確認
-		claims, ok := token.Claims.(jwt.MapClaims)
-		fmt.Println("claims ❗️: ", claims)
-		fmt.Println("ok ❗️: ", ok)
-		if ok && token.Valid {
-			// ユーザー名をコンテキストに設定
-			c.Set("username", claims["username"])
-			c.Set("isAdmin", claims["admin"])
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
+		// ユーザー名をコンテキストに設定
+		c.Set("username", claims["username"])
+		role := RoleFromClaims(claims)
+		c.Set("role", role)
+		c.Set("isAdmin", role == RoleAdmin)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), claimsContextKey, claims))
 
 		// 次のハンドラーを実行
 		c.Next()
-
 	}
 }
 
-func Router() {
-	router := gin.Default()
-	// trustedProxies := []string {"http://localhost:3000"}
-	// router.SetTrustedProxies(trustedProxies)
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000"},
+/*
+CORS の設定 (CORS_ALLOW_ORIGINS で許可するオリジンを指定する)
+
+* を指定した場合はすべてのオリジンを許可する (Cookie などの認証情報は送信させない)
+*/
+func corsConfig() cors.Config {
+	config := cors.Config{
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Access-Control-Allow-Origin"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Access-Control-Allow-Origin", apiKeyHeader, requestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", requestIDHeader, "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
 		AllowCredentials: true,
-		AllowOriginFunc: func(origin string) bool {
-			return origin == "https://github.com"
-		},
-		MaxAge: 12 * time.Hour,
-	}))
+		MaxAge:           12 * time.Hour,
+	}
+	if slices.Contains(conf.CORSAllowOrigins, "*") {
+		config.AllowAllOrigins = true
+		config.AllowCredentials = false
+	} else {
+		config.AllowOrigins = conf.CORSAllowOrigins
+	}
+	return config
+}
+
+/*
+gin のルーターを作成する
+
+Lambda (cmd/compass-api の handler) と同じパスでも受け付ける
+*/
+func NewRouter() *gin.Engine {
+	if !conf.IsLocal() {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	router := gin.New()
+	// /reports/{key}/raw のキーに含まれる %2F をパスの区切りとして扱わない
+	router.UseRawPath = true
+	// クライアント IP (レート制限・ログイン試行の制限に使う) は TRUSTED_PROXIES のプロキシからの X-Forwarded-For のみ信頼する
+	// 未指定の場合は X-Forwarded-For を無視する (クライアントが書き換えて制限を回避できないように)
+	err := router.SetTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		// 起動時の設定の検証で確認しているため、ここでは発生しない
+		slog.Error("set trusted proxies failed", "error", err)
+		router.SetTrustedProxies(nil)
+	}
+	router.Use(gin.Recovery())
+	// リクエスト ID の設定と構造化ログ
+	router.Use(RequestLoggerMiddleware())
+	router.Use(cors.New(corsConfig()))
 
 	// リクエスト内容をログ出力
 	// router.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
@@ -102,7 +95,21 @@ func Router() {
 	// 	)
 	// }))
 
-	// router.Use()
+	// リクエストのメトリクス
+	router.Use(MetricsMiddleware())
+
+	// ヘルスチェック (API キー認証・レート制限の対象外)
+	router.GET("/healthz", HealthzGin)
+	router.GET("/readyz", ReadyzGin)
+
+	// クライアント IP のレート制限 (無効な API キーの大量送信も制限するため、API キー認証の前に実行する)
+	router.Use(IPRateLimitMiddleware())
+	// API キー認証
+	router.Use(APIKeyMiddleware())
+	// メトリクス (API キー認証のみ。キーの利用プランのレート制限の対象外)
+	router.GET("/metrics", MetricsGin)
+	// API キーのレート制限 (API キーの後に実行し、キーの利用プランを適用する)
+	router.Use(APIKeyRateLimitMiddleware())
 
 	// config := cors.DefaultConfig()
 	// config.AllowOrigins = []string{"http://localhost:3000"}
@@ -119,8 +126,6 @@ func Router() {
 	// router.GET("/categories", GetCategories)
 	// router.POST("/title", CreateTitle)
 	// router.DELETE("/title/:id", DeleteTitle)
-	// router.POST("/register", RegisterUser)
-	// router.POST("/login", Login)
 	// router.GET("/reports", GetReports)
 	// router.GET("/fundamentals", GetFundamentals)
 	// router.GET("/search/companies", SearchCompaniesByName)
@@ -129,7 +134,28 @@ func Router() {
 	router.GET("/search/companies/local", SearchCompaniesByNameGin)
 	router.GET("/reports/local", GetReportsGin)
 	router.GET("/fundamentals/local", GetFundamentalsGin)
-	router.GET("/news/local", GetLatestNewsGin)
+	router.GET("/news/local", GetNewsGin)
+	router.GET("/companies", GetCompaniesGin)
+	router.GET("/companies/:id", GetCompanyGin)
+	router.GET("/companies/:id/news", GetCompanyNewsGin)
+	router.GET("/companies/:id/overview", GetCompanyOverviewGin)
+	router.GET("/search", SearchCompaniesByNameGin)
+	router.GET("/reports", GetReportsGin)
+	// キーの / は %2F にエスケープする (/reports/E00001%2FBS%2F....html/raw)
+	router.GET("/reports/:key/raw", GetReportRawGin)
+	router.GET("/filings/:docID/xbrl", GetFilingXBRLGin)
+	router.GET("/filings/:docID/pdf", GetFilingPDFGin)
+	router.GET("/fundamentals", GetFundamentalsGin)
+	router.GET("/news", GetNewsGin)
+	router.GET("/news/range", ListNewsEditionsGin)
+	router.POST("/register", RegisterUserGin)
+	router.POST("/login", LoginGin)
+	router.POST("/token/refresh", RefreshTokenGin)
+	router.POST("/logout", LogoutGin)
+	router.POST("/verify-email", VerifyEmailGin)
+	router.POST("/verify-email/resend", ResendVerificationGin)
+	router.POST("/password/forgot", ForgotPasswordGin)
+	router.POST("/password/reset", ResetPasswordGin)
 
 	// 認証が必要なエンドポイント
 	auth := router.Group("/")
@@ -137,13 +163,47 @@ func Router() {
 	{
 		// auth.GET("/company/:id/titles", GetCompanyTitles)
 		auth.GET("/user/auth", AuthUser)
+
+		auth.GET("/watchlists", ListWatchlistsGin)
+		auth.POST("/watchlists", CreateWatchlistGin)
+		auth.GET("/watchlists/:id", GetWatchlistGin)
+		auth.PUT("/watchlists/:id", UpdateWatchlistGin)
+		auth.DELETE("/watchlists/:id", DeleteWatchlistGin)
+		auth.GET("/watchlists/:id/summary", GetWatchlistSummaryGin)
+
+		auth.GET("/subscriptions", ListSubscriptionsGin)
+		auth.PUT("/subscriptions/:edinetCode", AddSubscriptionGin)
+		auth.DELETE("/subscriptions/:edinetCode", RemoveSubscriptionGin)
+		auth.GET("/alerts", ListAlertsGin)
+		auth.POST("/alerts/read", MarkAlertsReadGin)
+
 	}
 
-	// localhost だと Docker コンテナを立ち上げ外部からリクエストを受けることができないため
-	// 0.0.0.0 に変更
-	// err := router.Run("localhost:8080");
-	err := router.Run("0.0.0.0:8080")
-	if err != nil {
-		panic(err)
+	// 分析機能 (analyst 以上)
+	analyst := router.Group("/")
+	analyst.Use(AuthMiddleware(), RequireRole(RoleAnalyst))
+	{
+		analyst.GET("/webhooks", ListWebhooksGin)
+		analyst.POST("/webhooks", CreateWebhookGin)
+		analyst.GET("/webhooks/:id", GetWebhookGin)
+		analyst.DELETE("/webhooks/:id", DeleteWebhookGin)
+		analyst.GET("/webhooks/:id/deliveries", ListWebhookDeliveriesGin)
 	}
+
+	// 管理者用エンドポイント
+	admin := router.Group("/admin")
+	admin.Use(AuthMiddleware(), RequireRole(RoleAdmin))
+	{
+		admin.POST("/users/unlock", UnlockUserGin)
+		admin.PUT("/users/:id/role", UpdateUserRoleGin)
+		// Lambda と同じパス (?id= でユーザーを指定する)
+		admin.PUT("/users/role", UpdateUserRoleGin)
+		// 科目の編集 (MySQL を再度使用する場合に有効化する。admin のみ)
+		// admin.PUT("/company/:id/title/:titleId", UpdateCompanyTitles)
+		// admin.PUT("/title/:id", UpdateTitle)
+		// admin.POST("/title", CreateTitle)
+		// admin.DELETE("/title/:id", DeleteTitle)
+	}
+
+	return router
 }
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// 財務諸表の HTML で残す要素 (それ以外の要素はタグだけ除き、中のテキストは残す)
+var sanitizeAllowedElements = map[atom.Atom]bool{
+	atom.Table: true, atom.Caption: true, atom.Thead: true, atom.Tbody: true, atom.Tfoot: true,
+	atom.Tr: true, atom.Th: true, atom.Td: true,
+	atom.Div: true, atom.P: true, atom.Span: true, atom.Br: true, atom.Hr: true,
+	atom.B: true, atom.Strong: true, atom.I: true, atom.Em: true, atom.U: true,
+	atom.Sub: true, atom.Sup: true, atom.Small: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true,
+}
+
+// 中身ごと除く要素 (スクリプト・外部の参照・フォームなど)
+var sanitizeDroppedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Iframe: true, atom.Frame: true, atom.Frameset: true, atom.Object: true, atom.Embed: true, atom.Applet: true,
+	atom.Img: true, atom.Picture: true, atom.Video: true, atom.Audio: true, atom.Source: true, atom.Track: true,
+	atom.Svg: true, atom.Math: true, atom.Canvas: true,
+	atom.Link: true, atom.Meta: true, atom.Base: true, atom.Title: true, atom.Head: true,
+	atom.Form: true, atom.Input: true, atom.Button: true, atom.Textarea: true, atom.Select: true,
+}
+
+var (
+	sanitizeNumberPattern = regexp.MustCompile(`^[1-9][0-9]{0,2}$`)
+	sanitizeLengthPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(px|pt|em|rem|%|mm)?$`)
+)
+
+// 残す属性と、その値が使えるか
+var sanitizeAllowedAttributes = map[string]func(string) bool{
+	"colspan": sanitizeNumberPattern.MatchString,
+	"rowspan": sanitizeNumberPattern.MatchString,
+	"align":   oneOf("left", "center", "right", "justify"),
+	"valign":  oneOf("top", "middle", "bottom", "baseline"),
+}
+
+// style 属性で残すプロパティと、その値が使えるか (幅・高さ・位置などレイアウトを崩すものは除く)
+var sanitizeAllowedStyles = map[string]func(string) bool{
+	"text-align":      oneOf("left", "center", "right", "justify"),
+	"vertical-align":  oneOf("top", "middle", "bottom", "baseline"),
+	"font-weight":     oneOf("normal", "bold", "bolder", "lighter", "400", "700"),
+	"font-style":      oneOf("normal", "italic"),
+	"text-decoration": oneOf("none", "underline", "line-through"),
+	"padding-left":    sanitizeLengthPattern.MatchString,
+	"margin-left":     sanitizeLengthPattern.MatchString,
+	"text-indent":     sanitizeLengthPattern.MatchString,
+}
+
+func oneOf(values ...string) func(string) bool {
+	return func(value string) bool {
+		for _, v := range values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// style 属性から許可したプロパティだけを残す
+func sanitizeStyle(style string) string {
+	var declarations []string
+	for _, declaration := range strings.Split(style, ";") {
+		name, value, ok := strings.Cut(declaration, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.ToLower(strings.TrimSpace(value))
+		if valid, ok := sanitizeAllowedStyles[name]; ok && valid(value) {
+			declarations = append(declarations, name+":"+value)
+		}
+	}
+	return strings.Join(declarations, ";")
+}
+
+func sanitizeAttributes(attrs []html.Attribute) []html.Attribute {
+	var result []html.Attribute
+	for _, attr := range attrs {
+		// 名前空間付きの属性 (xlink:href など) は除く
+		if attr.Namespace != "" {
+			continue
+		}
+		key := strings.ToLower(attr.Key)
+		if key == "style" {
+			if style := sanitizeStyle(attr.Val); style != "" {
+				result = append(result, html.Attribute{Key: key, Val: style})
+			}
+			continue
+		}
+		value := strings.ToLower(strings.TrimSpace(attr.Val))
+		if valid, ok := sanitizeAllowedAttributes[key]; ok && valid(value) {
+			result = append(result, html.Attribute{Key: key, Val: value})
+		}
+	}
+	return result
+}
+
+// 許可していない要素・属性を除いた子ノードを parent に追加する
+func sanitizeNodes(parent *html.Node, nodes []*html.Node) {
+	for _, node := range nodes {
+		switch node.Type {
+		case html.TextNode:
+			parent.AppendChild(&html.Node{Type: html.TextNode, Data: node.Data})
+		case html.ElementNode:
+			if sanitizeDroppedElements[node.DataAtom] || node.Namespace != "" && !sanitizeAllowedElements[node.DataAtom] {
+				continue
+			}
+			children := childNodes(node)
+			if !sanitizeAllowedElements[node.DataAtom] {
+				// タグだけ除き、中のテキストは残す (<a>、<font> など)
+				sanitizeNodes(parent, children)
+				continue
+			}
+			element := &html.Node{
+				Type:     html.ElementNode,
+				DataAtom: node.DataAtom,
+				Data:     node.DataAtom.String(),
+				Attr:     sanitizeAttributes(node.Attr),
+			}
+			sanitizeNodes(element, children)
+			parent.AppendChild(element)
+		}
+		// コメント・DOCTYPE は除く
+	}
+}
+
+func childNodes(node *html.Node) []*html.Node {
+	var children []*html.Node
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, child)
+	}
+	return children
+}
+
+/*
+財務諸表の HTML から許可した要素・属性 (表・span・基本的な書式) 以外を除く
+
+  - script・style・iframe・img などは中身ごと除く
+  - イベントハンドラー (onclick など)・href・src・class などの属性は除く
+  - style は text-align などレイアウトを崩さないプロパティだけを残す
+
+テキストはそのまま残すため、無害化した HTML からも同じように表の値を読み取れる (取り込み時・返す時の両方で使う)
+*/
+func SanitizeReportHTML(htmlStr string) string {
+	body := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	nodes, err := html.ParseFragment(strings.NewReader(htmlStr), body)
+	if err != nil {
+		// strings.Reader からの読み込みでは発生しない
+		return html.EscapeString(htmlStr)
+	}
+	root := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	sanitizeNodes(root, nodes)
+
+	var buf bytes.Buffer
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if err := html.Render(&buf, child); err != nil {
+			return html.EscapeString(htmlStr)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestSanitizeReportHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "表はそのまま残す",
+			input: `<table><tbody><tr><th>科目</th><td colspan="2" rowspan="1">1,234</td></tr></tbody></table>`,
+			want:  `<table><tbody><tr><th>科目</th><td colspan="2" rowspan="1">1,234</td></tr></tbody></table>`,
+		},
+		{
+			name:  "script・style は中身ごと除く",
+			input: `<p>a<script>alert(1)</script><style>p{display:none}</style>b</p>`,
+			want:  `<p>ab</p>`,
+		},
+		{
+			name:  "表のセルからイベントハンドラー・class・id を除く",
+			input: `<table><tr><td onclick="x()" onmouseover="y()" class="c" id="i">1</td></tr></table>`,
+			want:  `<table><tbody><tr><td>1</td></tr></tbody></table>`,
+		},
+		{
+			name:  "表の外の要素からイベントハンドラー・class を除く",
+			input: `<span onclick="x()" class="c">1</span>`,
+			want:  `<span>1</span>`,
+		},
+		{
+			name:  "表の外の td はテキストだけ残す",
+			input: `<td onclick="x()" class="c">1</td>`,
+			want:  `1`,
+		},
+		{
+			name:  "外部の参照を除く",
+			input: `<p><img src="https://evil.example/x.png" onerror="x()"><iframe src="https://evil.example"></iframe><a href="javascript:alert(1)">リンク</a></p>`,
+			want:  `<p>リンク</p>`,
+		},
+		{
+			name:  "svg 内の script も除く",
+			input: `<div><svg><script>alert(1)</script></svg>x</div>`,
+			want:  `<div>x</div>`,
+		},
+		{
+			name:  "レイアウトを崩すスタイルを除く",
+			input: `<table><tr><td style="width: 400pt; position:absolute; text-align: RIGHT; padding-left:12pt; background:url(https://evil.example/x.png)">1</td></tr></table>`,
+			want:  `<table><tbody><tr><td style="text-align:right;padding-left:12pt">1</td></tr></tbody></table>`,
+		},
+		{
+			name:  "許可したスタイルだけを残す",
+			input: `<span style="width: 400pt; text-align: RIGHT; padding-left:12pt; color:red">1</span>`,
+			want:  `<span style="text-align:right;padding-left:12pt">1</span>`,
+		},
+		{
+			name:  "expression などの値は除く",
+			input: `<p style="text-align:expression(alert(1)); margin-left: 1em;">x</p>`,
+			want:  `<p style="margin-left:1em">x</p>`,
+		},
+		{
+			name:  "属性の値を検証する",
+			input: `<table><tbody><tr><td colspan="0" rowspan="x" align="left" valign="javascript:">1</td></tr></tbody></table>`,
+			want:  `<table><tbody><tr><td align="left">1</td></tr></tbody></table>`,
+		},
+		{
+			name:  "コメントを除く",
+			input: `<p>a<!-- <script>alert(1)</script> -->b</p>`,
+			want:  `<p>ab</p>`,
+		},
+		{
+			name:  "タグだけ除いた要素のテキストはエスケープする",
+			input: `<font color="red">&amp;&lt;script&gt;</font>`,
+			want:  `&amp;&lt;script&gt;`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeReportHTML(tt.input)
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+			// 取り込み時と返す時の 2 回無害化しても変わらない
+			if again := SanitizeReportHTML(got); again != got {
+				t.Errorf("2 回目の無害化で変わりました: %s", again)
+			}
+		})
+	}
+}
+
+// バッチは無害化した HTML の表から値を読み取るため、td のテキスト (改行を含む) が変わらないこと
+func TestSanitizeReportHTMLKeepsTableText(t *testing.T) {
+	input := `<table style="width: 400pt" onmouseover="x()">
+<tbody>
+<tr><td style="padding-left: 12pt"><p>
+<span style="font-weight:bold">流動資産合計</span></p></td>
+<td><a href="#">1,234</a></td><td><img src="x.png">△5<script>6</script></td></tr>
+</tbody></table>`
+
+	text := func(htmlStr string) string {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlStr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc.Find("tr").First().Find("td").Text()
+	}
+	want := "\n流動資産合計1,234△5"
+	if got := text(SanitizeReportHTML(input)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
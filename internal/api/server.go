@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// HTTP サーバーを作成する (アドレス・TLS・タイムアウトは設定から決める)
+func NewServer() *http.Server {
+	return &http.Server{
+		Addr:              conf.ServerAddr,
+		Handler:           NewRouter(),
+		ReadTimeout:       conf.ServerReadTimeout,
+		ReadHeaderTimeout: conf.ServerReadHeaderTimeout,
+		WriteTimeout:      conf.ServerWriteTimeout,
+		IdleTimeout:       conf.ServerIdleTimeout,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+}
+
+/*
+HTTP サーバーを起動し、ctx が終了するまでリクエストを処理する
+
+ctx の終了後は新しい接続を受け付けず、処理中のリクエストの完了を SERVER_SHUTDOWN_TIMEOUT まで待ってから終了する
+*/
+func Serve(ctx context.Context) error {
+	server := NewServer()
+	tlsEnabled := conf.TLSCertFile != ""
+
+	// ポートを使用中の場合などはここでエラーを返す
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		if tlsEnabled {
+			errCh <- server.ServeTLS(listener, conf.TLSCertFile, conf.TLSKeyFile)
+		} else {
+			errCh <- server.Serve(listener)
+		}
+	}()
+	Logger(ctx).Info("server started", "addr", listener.Addr().String(), "tls", tlsEnabled)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	Logger(ctx).Info("shutting down server", "timeout", conf.ServerShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.ServerShutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	Logger(ctx).Info("server stopped")
+	return nil
+}
@@ -0,0 +1,505 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const claimsContextKey contextKey = "claims"
+
+// JWKS の再取得に失敗した後、次に再取得するまでの間隔 (未知の kid による再取得の連続も防ぐ)
+const jwksRetryInterval = time.Minute
+
+// JWKS に含まれる鍵 (RSA / EC の公開鍵のみ対応)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// 検証用の公開鍵
+type verificationKey struct {
+	key crypto.PublicKey
+	alg string
+	// JWKS から削除された鍵の有効期限 (ゼロ値は期限なし)
+	expiresAt time.Time
+}
+
+func parseJSONWebKey(jwk jsonWebKey) (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+}
+
+/*
+JWKS (ローカルファイルまたは URL) から読み込んだ公開鍵の集合
+
+鍵のローテーションに対応するため、JWKS から削除された鍵も rotationGrace の間は検証に使用する
+URL の再取得は同時に 1 件だけ行い、その間や失敗した後も読み込み済みの鍵で検証する
+*/
+type KeySet struct {
+	mu              sync.RWMutex
+	keys            map[string]*verificationKey
+	source          string
+	refreshInterval time.Duration
+	rotationGrace   time.Duration
+	fetchedAt       time.Time
+	// 最後に再取得を始めた時刻 (失敗した場合も更新する)
+	attemptedAt time.Time
+	refreshing  bool
+	httpClient  *http.Client
+}
+
+func NewKeySet(source string, refreshInterval time.Duration, rotationGrace time.Duration) (*KeySet, error) {
+	keySet := &KeySet{
+		keys:            map[string]*verificationKey{},
+		source:          source,
+		refreshInterval: refreshInterval,
+		rotationGrace:   rotationGrace,
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+	}
+	err := keySet.Refresh(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return keySet, nil
+}
+
+func (ks *KeySet) isRemote() bool {
+	return strings.HasPrefix(ks.source, "http://") || strings.HasPrefix(ks.source, "https://")
+}
+
+func (ks *KeySet) fetch(ctx context.Context) ([]byte, error) {
+	if !ks.isRemote() {
+		return os.ReadFile(ks.source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ks.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS の取得に失敗しました (status: %d)", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// JWKS を再取得し、鍵を入れ替える
+func (ks *KeySet) Refresh(ctx context.Context) error {
+	body, err := ks.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	var set jsonWebKeySet
+	err = json.Unmarshal(body, &set)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	current := map[string]bool{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJSONWebKey(jwk)
+		if err != nil {
+			slog.Warn("JWKS の鍵を読み込めませんでした", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		ks.keys[jwk.Kid] = &verificationKey{key: key, alg: jwk.Alg}
+		current[jwk.Kid] = true
+	}
+	// JWKS から削除された鍵は猶予期間の後に無効にする
+	for kid, key := range ks.keys {
+		if current[kid] {
+			continue
+		}
+		if key.expiresAt.IsZero() {
+			key.expiresAt = now.Add(ks.rotationGrace)
+		}
+		if now.After(key.expiresAt) {
+			delete(ks.keys, kid)
+		}
+	}
+	ks.fetchedAt = now
+	return nil
+}
+
+func (ks *KeySet) lookup(kid string) (*verificationKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	if !ok || (!key.expiresAt.IsZero() && time.Now().After(key.expiresAt)) {
+		return nil, false
+	}
+	return key, true
+}
+
+// kid に対応する鍵を返す (見つからない場合は JWKS を再取得する)
+func (ks *KeySet) Key(ctx context.Context, kid string) (*verificationKey, error) {
+	key, ok := ks.lookup(kid)
+	metrics.ObserveCache("jwks", ok)
+	if ok {
+		return key, nil
+	}
+	// 未知の kid による再取得の連続を防ぐ
+	if ks.beginRefresh(func() bool { return true }) {
+		err := ks.refresh(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := ks.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown kid: %s", kid)
+}
+
+/*
+再取得を始めてよいか
+
+再取得中の場合と、前回の再取得から jwksRetryInterval が経っていない場合は始めない
+*/
+func (ks *KeySet) beginRefresh(needed func() bool) bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.refreshing || time.Since(ks.attemptedAt) < jwksRetryInterval || !needed() {
+		return false
+	}
+	ks.refreshing = true
+	ks.attemptedAt = time.Now()
+	return true
+}
+
+// beginRefresh の後に JWKS を再取得する
+func (ks *KeySet) refresh(ctx context.Context) error {
+	defer func() {
+		ks.mu.Lock()
+		ks.refreshing = false
+		ks.mu.Unlock()
+	}()
+	err := ks.Refresh(ctx)
+	if err != nil {
+		Logger(ctx).Error("JWKS refresh failed", "error", err)
+	}
+	return err
+}
+
+// 定期的に JWKS を再取得する (URL の場合のみ)
+// リクエストを待たせないよう、再取得はバックグラウンドで行い、それまでは読み込み済みの鍵を使う
+func (ks *KeySet) refreshIfStale(ctx context.Context) {
+	if !ks.isRemote() {
+		return
+	}
+	// ks.mu を取得した状態で呼ばれる
+	stale := func() bool { return time.Since(ks.fetchedAt) > ks.refreshInterval }
+	if ks.beginRefresh(stale) {
+		go ks.refresh(context.WithoutCancel(ctx))
+	}
+}
+
+// JWT の検証
+type TokenVerifier struct {
+	KeySet     *KeySet
+	HMACSecret []byte
+	Issuer     string
+	Audience   string
+}
+
+func (v *TokenVerifier) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		alg := token.Method.Alg()
+		if alg == jwt.SigningMethodHS256.Alg() {
+			if len(v.HMACSecret) == 0 {
+				return nil, fmt.Errorf("unexpected signing method: %v", alg)
+			}
+			return v.HMACSecret, nil
+		}
+		if v.KeySet == nil {
+			return nil, fmt.Errorf("unexpected signing method: %v", alg)
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := v.KeySet.Key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && key.alg != alg {
+			return nil, fmt.Errorf("alg mismatch: token %s, key %s", alg, key.alg)
+		}
+		switch key.key.(type) {
+		case *rsa.PublicKey:
+			if alg != jwt.SigningMethodRS256.Alg() {
+				return nil, fmt.Errorf("unexpected signing method: %v", alg)
+			}
+		case *ecdsa.PublicKey:
+			if alg != jwt.SigningMethodES256.Alg() {
+				return nil, fmt.Errorf("unexpected signing method: %v", alg)
+			}
+		}
+		return key.key, nil
+	}
+}
+
+// トークンを検証し、クレームを返す
+func (v *TokenVerifier) Verify(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	if v.KeySet != nil {
+		v.KeySet.refreshIfStale(ctx)
+	}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{
+		jwt.SigningMethodHS256.Alg(),
+		jwt.SigningMethodRS256.Alg(),
+		jwt.SigningMethodES256.Alg(),
+	}))
+	// exp / nbf / iat は Parse 内で検証される
+	token, err := parser.Parse(tokenString, v.keyFunc(ctx))
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, errors.New("token has no expiration")
+	}
+	if v.Issuer != "" && !claims.VerifyIssuer(v.Issuer, true) {
+		return nil, errors.New("invalid issuer")
+	}
+	if v.Audience != "" && !claims.VerifyAudience(v.Audience, true) {
+		return nil, errors.New("invalid audience")
+	}
+	return claims, nil
+}
+
+// JWT の署名 (秘密鍵が設定されていれば RS256 / ES256、なければ SECRET_KEY で HS256)
+type TokenSigner struct {
+	Method   jwt.SigningMethod
+	Key      interface{}
+	KeyID    string
+	Issuer   string
+	Audience string
+}
+
+func (s *TokenSigner) Sign(claims jwt.MapClaims) (string, error) {
+	if s.Key == nil {
+		return "", errors.New("署名用の鍵が設定されていません")
+	}
+	now := time.Now()
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	if s.Issuer != "" {
+		claims["iss"] = s.Issuer
+	}
+	if s.Audience != "" {
+		claims["aud"] = s.Audience
+	}
+	token := jwt.NewWithClaims(s.Method, claims)
+	if s.KeyID != "" {
+		token.Header["kid"] = s.KeyID
+	}
+	return token.SignedString(s.Key)
+}
+
+// PEM 形式の秘密鍵を読み込む (PKCS#8 / PKCS#1 / SEC 1)
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("%s: PEM 形式ではありません", path)
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s: 未対応の秘密鍵です", path)
+}
+
+/*
+設定から JWT の検証設定を作成する
+
+	JWKS_FILE / JWKS_URL:      検証用の公開鍵 (RS256 / ES256)
+	SECRET_KEY:                HS256 の共通鍵
+	JWT_ISSUER / JWT_AUDIENCE: iss / aud の期待値
+*/
+func newTokenVerifier() (*TokenVerifier, error) {
+	verifier := &TokenVerifier{
+		HMACSecret: []byte(conf.SecretKey),
+		Issuer:     conf.JWTIssuer,
+		Audience:   conf.JWTAudience,
+	}
+	source := conf.JWKSURL
+	if source == "" {
+		source = conf.JWKSFile
+	}
+	if source != "" {
+		keySet, err := NewKeySet(source, conf.JWKSRefreshInterval, conf.JWTKeyRotationGrace)
+		if err != nil {
+			return nil, err
+		}
+		verifier.KeySet = keySet
+	}
+	return verifier, nil
+}
+
+// 設定から JWT の署名設定を作成する (JWT_PRIVATE_KEY_FILE, JWT_KEY_ID)
+func newTokenSigner() (*TokenSigner, error) {
+	signer := &TokenSigner{
+		Issuer:   conf.JWTIssuer,
+		Audience: conf.JWTAudience,
+		KeyID:    conf.JWTKeyID,
+	}
+	path := conf.JWTPrivateKeyFile
+	if path == "" {
+		signer.Method = jwt.SigningMethodHS256
+		if secret := conf.SecretKey; secret != "" {
+			signer.Key = []byte(secret)
+		}
+		return signer, nil
+	}
+	key, err := loadPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		signer.Method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		signer.Method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("%s: 未対応の秘密鍵です", path)
+	}
+	signer.Key = key
+	return signer, nil
+}
+
+// コンテキストから JWT のクレームを取得する
+func ClaimsFromContext(ctx context.Context) jwt.MapClaims {
+	claims, _ := ctx.Value(claimsContextKey).(jwt.MapClaims)
+	return claims
+}
+
+// Authorization ヘッダーのトークンを検証する
+func AuthenticateToken(ctx context.Context, authHeader string) (jwt.MapClaims, error) {
+	if authHeader == "" {
+		return nil, NewError(http.StatusUnauthorized, "Authorization header required")
+	}
+	// Bearer 部分を除去しトークンを取得
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		return nil, NewError(http.StatusUnauthorized, "Invalid token format")
+	}
+	claims, err := tokenVerifier.Verify(ctx, tokenString)
+	if err != nil {
+		Logger(ctx).Info("token verification failed", "error", err)
+		return nil, NewError(http.StatusUnauthorized, "Invalid token")
+	}
+	// メールアドレス確認・パスワード再設定用のトークンはアクセストークンとして使えない
+	if _, ok := claims["purpose"]; ok {
+		return nil, NewError(http.StatusUnauthorized, "Invalid token")
+	}
+	// ユーザーごとのリソースは sub をキーにするため、sub のないトークンは受け付けない
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, NewError(http.StatusUnauthorized, "Invalid token")
+	}
+	// 失効リスト (jti) の確認
+	err = checkTokenRevoked(ctx, claims)
+	if err != nil {
+		if errorStatus(err) == http.StatusInternalServerError {
+			Logger(ctx).Error("check token revocation failed", "error", err)
+		}
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeySetRefreshIfStaleDoesNotBlock(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(jsonWebKeySet{Keys: []jsonWebKey{{
+		Kty: "EC", Kid: "key-1", Alg: "ES256", Crv: "P-256",
+		X: encode(private.X.Bytes()), Y: encode(private.Y.Bytes()),
+	}}})
+
+	var down atomic.Bool
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !down.Load() {
+			w.Write(jwks)
+			return
+		}
+		requests.Add(1)
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	keySet, err := NewKeySet(server.URL, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Store(true)
+	keySet.mu.Lock()
+	keySet.fetchedAt = time.Now().Add(-2 * time.Hour)
+	keySet.mu.Unlock()
+
+	// JWKS の取得が終わらなくても、同時のリクエストは待たずに読み込み済みの鍵を使う
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keySet.refreshIfStale(context.Background())
+			if _, err := keySet.Key(context.Background(), "key-1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("再取得を %v 待ちました", elapsed)
+	}
+	close(release)
+
+	// 失敗した後も jwksRetryInterval の間は再取得しない
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		keySet.mu.RLock()
+		refreshing := keySet.refreshing
+		keySet.mu.RUnlock()
+		if !refreshing || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	keySet.refreshIfStale(context.Background())
+	if _, err := keySet.Key(context.Background(), "unknown"); err == nil {
+		t.Error("未知の kid を受け付けました")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := requests.Load(); got != 1 {
+		t.Errorf("JWKS を %d 回取得しました, want 1", got)
+	}
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+var ErrTokenNotFound = errors.New("token not found")
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
+// リフレッシュトークンと失効したアクセストークンの保存先
+type TokenStore interface {
+	SaveRefreshToken(ctx context.Context, token *internal.RefreshToken) error
+	// ハッシュ化したトークンで取得する (存在しない場合は ErrTokenNotFound)
+	GetRefreshToken(ctx context.Context, id string) (*internal.RefreshToken, error)
+	// ローテーション済みにする (既に使用済みの場合は ErrRefreshTokenReused)
+	MarkRefreshTokenUsed(ctx context.Context, id string) error
+	RevokeTokenFamily(ctx context.Context, familyID string, expiresAt time.Time) error
+	IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error)
+	// ユーザーに revokedAt 以前に発行したリフレッシュトークンを全て失効させる (expiresAt まで保持する)
+	RevokeUserTokens(ctx context.Context, userID string, revokedAt time.Time, expiresAt time.Time) error
+	// ユーザーのトークンを失効させた時刻 (失効させていない場合はゼロ値)
+	UserTokensRevokedAt(ctx context.Context, userID string) (time.Time, error)
+	// アクセストークンを jti で失効させる (有効期限まで保持する)
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// メモリ上でトークンを保持する (ローカル用)
+type MemoryTokenStore struct {
+	mu              sync.Mutex
+	refreshTokens   map[string]internal.RefreshToken
+	revokedFamilies map[string]time.Time
+	revokedUsers    map[string]time.Time
+	revokedJTIs     map[string]time.Time
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		refreshTokens:   map[string]internal.RefreshToken{},
+		revokedFamilies: map[string]time.Time{},
+		revokedUsers:    map[string]time.Time{},
+		revokedJTIs:     map[string]time.Time{},
+	}
+}
+
+func (s *MemoryTokenStore) SaveRefreshToken(ctx context.Context, token *internal.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshTokens[token.ID] = *token
+	return nil
+}
+
+func (s *MemoryTokenStore) GetRefreshToken(ctx context.Context, id string) (*internal.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.refreshTokens[id]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return &token, nil
+}
+
+func (s *MemoryTokenStore) MarkRefreshTokenUsed(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.refreshTokens[id]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	if token.Used {
+		return ErrRefreshTokenReused
+	}
+	token.Used = true
+	s.refreshTokens[id] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) RevokeTokenFamily(ctx context.Context, familyID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedFamilies[familyID] = expiresAt
+	return nil
+}
+
+func (s *MemoryTokenStore) IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.revokedFamilies[familyID]
+	return ok, nil
+}
+
+func (s *MemoryTokenStore) RevokeUserTokens(ctx context.Context, userID string, revokedAt time.Time, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedUsers[userID] = revokedAt
+	return nil
+}
+
+func (s *MemoryTokenStore) UserTokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revokedUsers[userID], nil
+}
+
+func (s *MemoryTokenStore) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedJTIs[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryTokenStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt, ok := s.revokedJTIs[jti]
+	if ok && time.Now().After(expiresAt) {
+		delete(s.revokedJTIs, jti)
+		return false, nil
+	}
+	return ok, nil
+}
+
+/*
+DynamoDB にトークンを保存する
+
+1つのテーブルに id のプレフィックスで種類を分けて保存する
+
+	refresh#{ハッシュ}  リフレッシュトークン
+	family#{ファミリーID} 失効したトークンファミリー
+	user#{ユーザーID}    ユーザーのトークンを失効させた時刻 (revokedAt)
+	jti#{jti}          失効したアクセストークン
+
+テーブル: パーティションキー id (S)、TTL 属性 ttl
+*/
+type DynamoTokenStore struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+type dynamoRefreshToken struct {
+	internal.RefreshToken
+	TTL int64 `dynamodbav:"ttl"`
+}
+
+func (s *DynamoTokenStore) SaveRefreshToken(ctx context.Context, token *internal.RefreshToken) error {
+	item, err := attributevalue.MarshalMap(dynamoRefreshToken{RefreshToken: *token, TTL: token.ExpiresAt.Unix()})
+	if err != nil {
+		return err
+	}
+	item["id"] = &types.AttributeValueMemberS{Value: "refresh#" + token.ID}
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.TableName),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *DynamoTokenStore) GetRefreshToken(ctx context.Context, id string) (*internal.RefreshToken, error) {
+	output, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.TableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: "refresh#" + id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrTokenNotFound
+	}
+	var token internal.RefreshToken
+	err = attributevalue.UnmarshalMap(output.Item, &token)
+	if err != nil {
+		return nil, err
+	}
+	token.ID = id
+	return &token, nil
+}
+
+func (s *DynamoTokenStore) MarkRefreshTokenUsed(ctx context.Context, id string) error {
+	_, err := s.Client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: "refresh#" + id},
+		},
+		UpdateExpression:    aws.String("SET #used = :true"),
+		ConditionExpression: aws.String("attribute_exists(id) AND #used = :false"),
+		ExpressionAttributeNames: map[string]string{
+			"#used": "used",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true":  &types.AttributeValueMemberBOOL{Value: true},
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return ErrRefreshTokenReused
+	}
+	return err
+}
+
+func (s *DynamoTokenStore) putMarker(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.TableName),
+		Item: map[string]types.AttributeValue{
+			"id":  &types.AttributeValueMemberS{Value: id},
+			"ttl": &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
+		},
+	})
+	return err
+}
+
+func (s *DynamoTokenStore) hasMarker(ctx context.Context, id string) (bool, error) {
+	output, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	if output.Item == nil {
+		return false, nil
+	}
+	// TTL による削除は即時ではないため期限も確認する
+	if v, ok := output.Item["ttl"].(*types.AttributeValueMemberN); ok {
+		ttl, _ := strconv.ParseInt(v.Value, 10, 64)
+		if time.Now().Unix() > ttl {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *DynamoTokenStore) RevokeTokenFamily(ctx context.Context, familyID string, expiresAt time.Time) error {
+	return s.putMarker(ctx, "family#"+familyID, expiresAt)
+}
+
+func (s *DynamoTokenStore) IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	return s.hasMarker(ctx, "family#"+familyID)
+}
+
+func (s *DynamoTokenStore) RevokeUserTokens(ctx context.Context, userID string, revokedAt time.Time, expiresAt time.Time) error {
+	_, err := s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.TableName),
+		Item: map[string]types.AttributeValue{
+			"id":        &types.AttributeValueMemberS{Value: "user#" + userID},
+			"revokedAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(revokedAt.UnixNano(), 10)},
+			"ttl":       &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
+		},
+	})
+	return err
+}
+
+func (s *DynamoTokenStore) UserTokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	output, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.TableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: "user#" + userID},
+		},
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	v, ok := output.Item["revokedAt"].(*types.AttributeValueMemberN)
+	if !ok {
+		return time.Time{}, nil
+	}
+	revokedAt, err := strconv.ParseInt(v.Value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, revokedAt), nil
+}
+
+func (s *DynamoTokenStore) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return s.putMarker(ctx, "jti#"+jti, expiresAt)
+}
+
+func (s *DynamoTokenStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return s.hasMarker(ctx, "jti#"+jti)
+}
+
+// 設定からトークンの保存先を決める
+func newTokenStore() TokenStore {
+	if tableName := conf.TokenTableName; tableName != "" {
+		return &DynamoTokenStore{Client: dynamoClient, TableName: tableName}
+	}
+	return NewMemoryTokenStore()
+}
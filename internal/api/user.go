@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+var ErrEmailAlreadyExists = errors.New("email already exists")
+
+// ユーザーの保存先
+type UserRepository interface {
+	// ユーザーを作成する (メールアドレスが登録済みの場合は ErrEmailAlreadyExists)
+	CreateUser(ctx context.Context, user *internal.User) error
+	GetUserByID(ctx context.Context, id string) (*internal.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*internal.User, error)
+	// ユーザーを更新する (メールアドレスは変更できない)
+	UpdateUser(ctx context.Context, user *internal.User) error
+}
+
+// メールアドレスを比較用に正規化する
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// 表示名や山括弧のない、アドレスだけの形式かを確認する
+func isValidEmail(email string) bool {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Name == "" && addr.Address == email
+}
+
+// メモリ上でユーザーを保持する (ローカル用)
+type MemoryUserRepository struct {
+	mu      sync.RWMutex
+	users   map[string]internal.User
+	byEmail map[string]string
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users:   map[string]internal.User{},
+		byEmail: map[string]string{},
+	}
+}
+
+func (r *MemoryUserRepository) CreateUser(ctx context.Context, user *internal.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	email := NormalizeEmail(user.Email)
+	if _, ok := r.byEmail[email]; ok {
+		return ErrEmailAlreadyExists
+	}
+	r.users[user.ID] = *user
+	r.byEmail[email] = user.ID
+	return nil
+}
+
+func (r *MemoryUserRepository) GetUserByID(ctx context.Context, id string) (*internal.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) GetUserByEmail(ctx context.Context, email string) (*internal.User, error) {
+	r.mu.RLock()
+	id, ok := r.byEmail[NormalizeEmail(email)]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return r.GetUserByID(ctx, id)
+}
+
+func (r *MemoryUserRepository) UpdateUser(ctx context.Context, user *internal.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[user.ID]; !ok {
+		return ErrUserNotFound
+	}
+	r.users[user.ID] = *user
+	return nil
+}
+
+/*
+DynamoDB にユーザーを保存する
+
+メールアドレスの一意性は、ユーザーと同時に email#{メールアドレス} のアイテムを
+条件付きで書き込むことで保証する
+
+テーブル: パーティションキー id (S)
+*/
+type DynamoUserRepository struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+const emailKeyPrefix = "email#"
+
+func emailKey(email string) string {
+	return emailKeyPrefix + NormalizeEmail(email)
+}
+
+// メールアドレスの索引アイテムはユーザーとして読み書きさせない
+func isEmailKey(id string) bool {
+	return strings.HasPrefix(id, emailKeyPrefix)
+}
+
+func (r *DynamoUserRepository) CreateUser(ctx context.Context, user *internal.User) error {
+	item, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:           aws.String(r.TableName),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(id)"),
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName: aws.String(r.TableName),
+					Item: map[string]types.AttributeValue{
+						"id":     &types.AttributeValueMemberS{Value: emailKey(user.Email)},
+						"userId": &types.AttributeValueMemberS{Value: user.ID},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(id)"),
+				},
+			},
+		},
+	})
+	var canceledErr *types.TransactionCanceledException
+	if errors.As(err, &canceledErr) {
+		reasons := canceledErr.CancellationReasons
+		if len(reasons) >= 2 && aws.ToString(reasons[1].Code) == "ConditionalCheckFailed" {
+			return ErrEmailAlreadyExists
+		}
+	}
+	return err
+}
+
+func (r *DynamoUserRepository) getItem(ctx context.Context, id string) (map[string]types.AttributeValue, error) {
+	output, err := r.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(r.TableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrUserNotFound
+	}
+	return output.Item, nil
+}
+
+func (r *DynamoUserRepository) GetUserByID(ctx context.Context, id string) (*internal.User, error) {
+	if isEmailKey(id) {
+		return nil, ErrUserNotFound
+	}
+	item, err := r.getItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var user internal.User
+	err = attributevalue.UnmarshalMap(item, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *DynamoUserRepository) GetUserByEmail(ctx context.Context, email string) (*internal.User, error) {
+	item, err := r.getItem(ctx, emailKey(email))
+	if err != nil {
+		return nil, err
+	}
+	userID, ok := item["userId"].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return r.GetUserByID(ctx, userID.Value)
+}
+
+func (r *DynamoUserRepository) UpdateUser(ctx context.Context, user *internal.User) error {
+	if isEmailKey(user.ID) {
+		return ErrUserNotFound
+	}
+	item, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(r.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// 設定からユーザーの保存先を決める
+func newUserRepository() UserRepository {
+	if tableName := conf.UserTableName; tableName != "" {
+		return &DynamoUserRepository{Client: dynamoClient, TableName: tableName}
+	}
+	return NewMemoryUserRepository()
+}
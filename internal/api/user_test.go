@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+func TestRegisterUserRejectsInvalidEmail(t *testing.T) {
+	useUserRepository(t)
+	name, password := "user", "password"
+	for _, email := range []string{"", "user", "user@", "@example.com", "User <user@example.com>", "a@example.com, b@example.com"} {
+		err := RegisterUserProcessor(context.Background(), internal.RegisterUserBody{Name: &name, Password: &password, Email: &email})
+		if errorStatus(err) != http.StatusBadRequest {
+			t.Errorf("RegisterUserProcessor(%q) = %v", email, err)
+		}
+	}
+}
+
+func TestDynamoUserRepositoryRejectsEmailKey(t *testing.T) {
+	// 索引アイテムの ID ではテーブルを読み書きしない (Client が nil なので呼べば panic する)
+	repository := &DynamoUserRepository{TableName: "users"}
+	id := emailKey("user@example.com")
+	if _, err := repository.GetUserByID(context.Background(), id); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUserByID(%q) = %v", id, err)
+	}
+	if err := repository.UpdateUser(context.Background(), &internal.User{ID: id, Role: string(RoleAdmin)}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("UpdateUser(%q) = %v", id, err)
+	}
+}
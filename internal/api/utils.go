@@ -2,10 +2,13 @@ package api
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"log/slog"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -155,6 +158,11 @@ func QueryByName(svc *dynamodb.Client, tableName string, companyName string, edi
 	return result.Items, nil
 }
 
+// 企業名の表記を揃える (企業テーブルの企業名に合わせて半角英数字を全角にする)
+func NormalizeCompanyName(name string) string {
+	return width.Widen.String(name)
+}
+
 func ScanCompaniesByName(svc *dynamodb.Client, tableName string, companyName string) ([]internal.Company, error) {
 	targetNames := []string{companyName}
 	// 半角文字の存在チェック
@@ -162,8 +170,7 @@ func ScanCompaniesByName(svc *dynamodb.Client, tableName string, companyName str
 	re := regexp.MustCompile(halfWidthPattern)
 	if re.MatchString(companyName) {
 		// 半角英数字を全角英数字に変換
-		fullWidthCompanyName := width.Widen.String(companyName)
-		targetNames = append(targetNames, fullWidthCompanyName)
+		targetNames = append(targetNames, NormalizeCompanyName(companyName))
 	}
 
 	var resultItems []map[string]types.AttributeValue
@@ -196,12 +203,55 @@ func ScanCompaniesByName(svc *dynamodb.Client, tableName string, companyName str
 	var companies []internal.Company
 	err := attributevalue.UnmarshalListOfMaps(resultItems, &companies)
 	if err != nil {
-		fmt.Println("unMarshal err: ", err)
+		slog.Error("unmarshal companies failed", "error", err)
 		return nil, err
 	}
 	return companies, nil
 }
 
+/*
+EDINET コードに一致する企業を取得する
+
+企業テーブルの GSI EDINETCodeIndex (パーティションキー edinetCode) を EDINET コードごとに Query する (テーブル全体を Scan しないように)
+*/
+func QueryCompaniesByEDINETCodes(ctx context.Context, svc *dynamodb.Client, tableName string, edinetCodes []string) ([]internal.Company, error) {
+	companies := make([][]internal.Company, len(edinetCodes))
+	errs := make([]error, len(edinetCodes))
+	var wg sync.WaitGroup
+	// DynamoDB への同時リクエスト数
+	sem := make(chan struct{}, 8)
+	for i, code := range edinetCodes {
+		wg.Add(1)
+		go func(i int, code string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			result, err := svc.Query(ctx, &dynamodb.QueryInput{
+				TableName:              aws.String(tableName),
+				IndexName:              aws.String("EDINETCodeIndex"),
+				KeyConditionExpression: aws.String("#e = :edinetCode"),
+				ExpressionAttributeNames: map[string]string{
+					"#e": "edinetCode",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":edinetCode": &types.AttributeValueMemberS{Value: code},
+				},
+				Limit: aws.Int32(1),
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = attributevalue.UnmarshalListOfMaps(result.Items, &companies[i])
+		}(i, code)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return slices.Concat(companies...), nil
+}
+
 /*
 	S3 に指定したキーが存在するかチェックする
 
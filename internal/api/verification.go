@@ -0,0 +1,314 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/joe-black-jb/compass-api/internal"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ユーザーの状態
+const (
+	UserStatusPending = "pending" // メールアドレス未確認
+	UserStatusActive  = "active"
+)
+
+// メール用トークンの用途 (アクセストークンとして使えないよう purpose クレームに設定する)
+const (
+	purposeVerifyEmail   = "verify-email"
+	purposePasswordReset = "password-reset"
+)
+
+var errInvalidMailToken = NewError(http.StatusBadRequest, "リンクが無効か有効期限が切れています")
+
+// メールアドレス確認トークンの有効期間 (EMAIL_VERIFICATION_TTL で変更可能)
+func emailVerificationTTL() time.Duration {
+	return conf.EmailVerificationTTL
+}
+
+// パスワード再設定トークンの有効期間 (PASSWORD_RESET_TTL で変更可能)
+func passwordResetTTL() time.Duration {
+	return conf.PasswordResetTTL
+}
+
+// メール内のリンク先 (APP_BASE_URL で変更可能)
+func appLink(path string, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimSuffix(conf.AppBaseURL, "/"), path, url.QueryEscape(token))
+}
+
+// パスワードハッシュの指紋 (パスワード変更後に再設定トークンを無効にするために使う)
+func passwordFingerprint(hash []byte) string {
+	sum := sha256.Sum256(hash)
+	return hex.EncodeToString(sum[:8])
+}
+
+func signMailToken(user *internal.User, purpose string, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":     user.ID,
+		"purpose": purpose,
+		"exp":     time.Now().Add(ttl).Unix(),
+	}
+	if purpose == purposePasswordReset {
+		claims["pwd"] = passwordFingerprint(user.Password)
+	}
+	return tokenSigner.Sign(claims)
+}
+
+// メール用トークンを検証し、対象のユーザーを返す
+func verifyMailToken(ctx context.Context, tokenString string, purpose string) (*internal.User, jwt.MapClaims, error) {
+	if tokenString == "" {
+		return nil, nil, NewError(http.StatusBadRequest, "トークンを指定してください")
+	}
+	claims, err := tokenVerifier.Verify(ctx, tokenString)
+	if err != nil {
+		Logger(ctx).Info("mail token verification failed", "error", err)
+		return nil, nil, errInvalidMailToken
+	}
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return nil, nil, errInvalidMailToken
+	}
+	userID, _ := claims["sub"].(string)
+	user, err := userRepository.GetUserByID(ctx, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil, errInvalidMailToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, claims, nil
+}
+
+func sendVerificationMail(ctx context.Context, user *internal.User) error {
+	token, err := signMailToken(user, purposeVerifyEmail, emailVerificationTTL())
+	if err != nil {
+		return err
+	}
+	return mailSender.SendMail(ctx, Mail{
+		To:      user.Email,
+		Subject: "【Compass】メールアドレスの確認",
+		Body: fmt.Sprintf("%s 様\n\nCompass へのご登録ありがとうございます。\n以下のリンクからメールアドレスの確認を完了してください (有効期限: %s)。\n\n%s\n",
+			user.Name, emailVerificationTTL(), appLink("/verify-email", token)),
+	})
+}
+
+// メールアドレスを確認し、アカウントを有効にする
+func VerifyEmailProcessor(ctx context.Context, token string) error {
+	user, _, err := verifyMailToken(ctx, token, purposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	if user.Status != UserStatusPending {
+		return nil
+	}
+	user.Status = UserStatusActive
+	user.UpdatedAt = time.Now()
+	return userRepository.UpdateUser(ctx, user)
+}
+
+// 確認メールを再送する (登録有無が分からないよう、常に成功を返す)
+func ResendVerificationProcessor(ctx context.Context, email string) error {
+	user, err := userRepository.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Status != UserStatusPending {
+		return nil
+	}
+	// 送信に失敗した場合もエラーを返さない (登録済みのメールアドレスだけ失敗することで、登録有無が分からないように)
+	err = sendVerificationMail(ctx, user)
+	if err != nil {
+		Logger(ctx).Error("send verification mail failed", "userId", user.ID, "error", err)
+	}
+	return nil
+}
+
+// パスワード再設定メールを送信する (登録有無が分からないよう、常に成功を返す)
+func ForgotPasswordProcessor(ctx context.Context, email string) error {
+	if email == "" {
+		return NewError(http.StatusBadRequest, "メールアドレスを指定してください")
+	}
+	user, err := userRepository.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// 送信に失敗した場合もエラーを返さない (ResendVerificationProcessor と同じ)
+	err = sendPasswordResetMail(ctx, user)
+	if err != nil {
+		Logger(ctx).Error("send password reset mail failed", "userId", user.ID, "error", err)
+	}
+	return nil
+}
+
+func sendPasswordResetMail(ctx context.Context, user *internal.User) error {
+	token, err := signMailToken(user, purposePasswordReset, passwordResetTTL())
+	if err != nil {
+		return err
+	}
+	return mailSender.SendMail(ctx, Mail{
+		To:      user.Email,
+		Subject: "【Compass】パスワードの再設定",
+		Body: fmt.Sprintf("%s 様\n\n以下のリンクからパスワードを再設定してください (有効期限: %s)。\nお心当たりがない場合はこのメールを破棄してください。\n\n%s\n",
+			user.Name, passwordResetTTL(), appLink("/password/reset", token)),
+	})
+}
+
+/*
+パスワードを再設定する
+
+トークンには発行時のパスワードハッシュの指紋を含めるため、再設定後は同じトークンを使用できない
+メールを受け取れたことの確認になるため、未確認のアカウントも有効にする
+漏洩したパスワードで発行されたリフレッシュトークンを使えないよう、再設定前に発行したものは全て失効させる
+*/
+func ResetPasswordProcessor(ctx context.Context, token string, password string) error {
+	if password == "" {
+		return NewError(http.StatusBadRequest, "パスワードを指定してください")
+	}
+	user, claims, err := verifyMailToken(ctx, token, purposePasswordReset)
+	if err != nil {
+		return err
+	}
+	if fingerprint, _ := claims["pwd"].(string); fingerprint != passwordFingerprint(user.Password) {
+		return errInvalidMailToken
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return NewError(http.StatusInternalServerError, "パスワードの暗号化処理に失敗しました")
+	}
+	user.Password = hash
+	if user.Status == UserStatusPending {
+		user.Status = UserStatusActive
+	}
+	user.UpdatedAt = time.Now()
+	err = userRepository.UpdateUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	err = tokenStore.RevokeUserTokens(ctx, user.ID, user.UpdatedAt, user.UpdatedAt.Add(refreshTokenTTL()))
+	if err != nil {
+		return err
+	}
+	// ロック中でも新しいパスワードでログインできるようにする
+	return resetLoginFailures(ctx, user.Email)
+}
+
+func VerifyEmailGin(c *gin.Context) {
+	var reqBody internal.VerifyEmailBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	err := VerifyEmailProcessor(c.Request.Context(), reqBody.Token)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "メールアドレスの確認が完了しました")
+}
+
+func ResendVerificationGin(c *gin.Context) {
+	var reqBody internal.ForgotPasswordBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	err := ResendVerificationProcessor(c.Request.Context(), reqBody.Email)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "確認メールを送信しました")
+}
+
+func ForgotPasswordGin(c *gin.Context) {
+	var reqBody internal.ForgotPasswordBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	err := ForgotPasswordProcessor(c.Request.Context(), reqBody.Email)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "パスワード再設定メールを送信しました")
+}
+
+func ResetPasswordGin(c *gin.Context) {
+	var reqBody internal.ResetPasswordBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	err := ResetPasswordProcessor(c.Request.Context(), reqBody.Token, reqBody.Password)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "パスワードを再設定しました")
+}
+
+func VerifyEmail(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var reqBody internal.VerifyEmailBody
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+	}
+	err := VerifyEmailProcessor(ctx, reqBody.Token)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, "メールアドレスの確認が完了しました")
+}
+
+func ResendVerification(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var reqBody internal.ForgotPasswordBody
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+	}
+	err := ResendVerificationProcessor(ctx, reqBody.Email)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, "確認メールを送信しました")
+}
+
+func ForgotPassword(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var reqBody internal.ForgotPasswordBody
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+	}
+	err := ForgotPasswordProcessor(ctx, reqBody.Email)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, "パスワード再設定メールを送信しました")
+}
+
+func ResetPassword(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var reqBody internal.ResetPasswordBody
+	if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+		return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+	}
+	err := ResetPasswordProcessor(ctx, reqBody.Token, reqBody.Password)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return jsonResponse(http.StatusOK, "パスワードを再設定しました")
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+func useUserRepository(t *testing.T) *MemoryUserRepository {
+	t.Helper()
+	repository := NewMemoryUserRepository()
+	prev := userRepository
+	userRepository = repository
+	t.Cleanup(func() { userRepository = prev })
+	return repository
+}
+
+func TestMailProcessorsHideSendFailure(t *testing.T) {
+	useTestTokens(t, NewMemoryTokenStore())
+	repository := useUserRepository(t)
+	prevSender := mailSender
+	mailSender = disabledMailSender{}
+	t.Cleanup(func() { mailSender = prevSender })
+
+	user := &internal.User{ID: "user-1", Name: "user", Email: "user@example.com", Password: []byte("hash"), Status: UserStatusPending}
+	if err := repository.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	// 登録済みのメールアドレスで送信に失敗しても、未登録の場合と同じく成功を返す
+	for _, email := range []string{"user@example.com", "unknown@example.com"} {
+		if err := ResendVerificationProcessor(context.Background(), email); err != nil {
+			t.Errorf("ResendVerificationProcessor(%q) = %v", email, err)
+		}
+		if err := ForgotPasswordProcessor(context.Background(), email); err != nil {
+			t.Errorf("ForgotPasswordProcessor(%q) = %v", email, err)
+		}
+	}
+}
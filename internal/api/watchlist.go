@@ -0,0 +1,537 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+var ErrWatchlistNotFound = errors.New("watchlist not found")
+
+// ウォッチリストの上限
+const (
+	maxWatchlistsPerUser     = 20
+	maxCompaniesPerWatchlist = 100
+)
+
+var edinetCodePattern = regexp.MustCompile(`^E\d{5}$`)
+
+// ウォッチリストの保存先 (ユーザーごとに分けて保存する)
+type WatchlistRepository interface {
+	ListWatchlists(ctx context.Context, userID string) ([]internal.Watchlist, error)
+	// 存在しない場合は ErrWatchlistNotFound
+	GetWatchlist(ctx context.Context, userID string, id string) (*internal.Watchlist, error)
+	// 作成・更新する
+	PutWatchlist(ctx context.Context, watchlist *internal.Watchlist) error
+	DeleteWatchlist(ctx context.Context, userID string, id string) error
+}
+
+// メモリ上でウォッチリストを保持する (ローカル用)
+type MemoryWatchlistRepository struct {
+	mu         sync.RWMutex
+	watchlists map[string]map[string]internal.Watchlist
+}
+
+func NewMemoryWatchlistRepository() *MemoryWatchlistRepository {
+	return &MemoryWatchlistRepository{watchlists: map[string]map[string]internal.Watchlist{}}
+}
+
+func (r *MemoryWatchlistRepository) ListWatchlists(ctx context.Context, userID string) ([]internal.Watchlist, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	watchlists := []internal.Watchlist{}
+	for _, watchlist := range r.watchlists[userID] {
+		watchlists = append(watchlists, watchlist)
+	}
+	sort.Slice(watchlists, func(i, j int) bool {
+		return watchlists[i].CreatedAt.Before(watchlists[j].CreatedAt)
+	})
+	return watchlists, nil
+}
+
+func (r *MemoryWatchlistRepository) GetWatchlist(ctx context.Context, userID string, id string) (*internal.Watchlist, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	watchlist, ok := r.watchlists[userID][id]
+	if !ok {
+		return nil, ErrWatchlistNotFound
+	}
+	return &watchlist, nil
+}
+
+func (r *MemoryWatchlistRepository) PutWatchlist(ctx context.Context, watchlist *internal.Watchlist) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watchlists[watchlist.UserID] == nil {
+		r.watchlists[watchlist.UserID] = map[string]internal.Watchlist{}
+	}
+	r.watchlists[watchlist.UserID][watchlist.ID] = *watchlist
+	return nil
+}
+
+func (r *MemoryWatchlistRepository) DeleteWatchlist(ctx context.Context, userID string, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.watchlists[userID][id]; !ok {
+		return ErrWatchlistNotFound
+	}
+	delete(r.watchlists[userID], id)
+	return nil
+}
+
+/*
+DynamoDB にウォッチリストを保存する
+
+テーブル: パーティションキー userId (S)、ソートキー id (S)
+*/
+type DynamoWatchlistRepository struct {
+	Client    *dynamodb.Client
+	TableName string
+}
+
+func watchlistKey(userID string, id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"userId": &types.AttributeValueMemberS{Value: userID},
+		"id":     &types.AttributeValueMemberS{Value: id},
+	}
+}
+
+func (r *DynamoWatchlistRepository) ListWatchlists(ctx context.Context, userID string) ([]internal.Watchlist, error) {
+	watchlists := []internal.Watchlist{}
+	var lastEvaluatedKey map[string]types.AttributeValue
+	for {
+		output, err := r.Client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(r.TableName),
+			KeyConditionExpression: aws.String("userId = :userId"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":userId": &types.AttributeValueMemberS{Value: userID},
+			},
+			ExclusiveStartKey: lastEvaluatedKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var batch []internal.Watchlist
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &batch)
+		if err != nil {
+			return nil, err
+		}
+		watchlists = append(watchlists, batch...)
+		if output.LastEvaluatedKey == nil {
+			break
+		}
+		lastEvaluatedKey = output.LastEvaluatedKey
+	}
+	sort.Slice(watchlists, func(i, j int) bool {
+		return watchlists[i].CreatedAt.Before(watchlists[j].CreatedAt)
+	})
+	return watchlists, nil
+}
+
+func (r *DynamoWatchlistRepository) GetWatchlist(ctx context.Context, userID string, id string) (*internal.Watchlist, error) {
+	output, err := r.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(r.TableName),
+		Key:       watchlistKey(userID, id),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrWatchlistNotFound
+	}
+	var watchlist internal.Watchlist
+	err = attributevalue.UnmarshalMap(output.Item, &watchlist)
+	if err != nil {
+		return nil, err
+	}
+	return &watchlist, nil
+}
+
+func (r *DynamoWatchlistRepository) PutWatchlist(ctx context.Context, watchlist *internal.Watchlist) error {
+	item, err := attributevalue.MarshalMap(watchlist)
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(r.TableName),
+		Item:      item,
+	})
+	return err
+}
+
+func (r *DynamoWatchlistRepository) DeleteWatchlist(ctx context.Context, userID string, id string) error {
+	_, err := r.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(r.TableName),
+		Key:                 watchlistKey(userID, id),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return ErrWatchlistNotFound
+	}
+	return err
+}
+
+// 設定からウォッチリストの保存先を決める
+func newWatchlistRepository() WatchlistRepository {
+	if tableName := conf.WatchlistTableName; tableName != "" {
+		return &DynamoWatchlistRepository{Client: dynamoClient, TableName: tableName}
+	}
+	return NewMemoryWatchlistRepository()
+}
+
+var errWatchlistNotFound = NewError(http.StatusNotFound, "ウォッチリストが見つかりません")
+
+// EDINET コードを検証し、重複を除く
+func normalizeEDINETCodes(codes []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if !edinetCodePattern.MatchString(code) {
+			return nil, NewError(http.StatusBadRequest, fmt.Sprintf("EDINET コードの形式が正しくありません: %s", code))
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		normalized = append(normalized, code)
+	}
+	if len(normalized) > maxCompaniesPerWatchlist {
+		return nil, NewError(http.StatusBadRequest, fmt.Sprintf("ウォッチリストに登録できる企業は %d 件までです", maxCompaniesPerWatchlist))
+	}
+	return normalized, nil
+}
+
+func validateWatchlistName(name *string) (string, error) {
+	if name == nil || strings.TrimSpace(*name) == "" {
+		return "", NewError(http.StatusBadRequest, "ウォッチリスト名を指定してください")
+	}
+	return strings.TrimSpace(*name), nil
+}
+
+func ListWatchlistsProcessor(ctx context.Context, userID string) ([]internal.Watchlist, error) {
+	return watchlistRepository.ListWatchlists(ctx, userID)
+}
+
+func GetWatchlistProcessor(ctx context.Context, userID string, id string) (*internal.Watchlist, error) {
+	watchlist, err := watchlistRepository.GetWatchlist(ctx, userID, id)
+	if errors.Is(err, ErrWatchlistNotFound) {
+		return nil, errWatchlistNotFound
+	}
+	return watchlist, err
+}
+
+func CreateWatchlistProcessor(ctx context.Context, userID string, reqBody internal.WatchlistBody) (*internal.Watchlist, error) {
+	name, err := validateWatchlistName(reqBody.Name)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := normalizeEDINETCodes(reqBody.EDINETCodes)
+	if err != nil {
+		return nil, err
+	}
+	watchlists, err := watchlistRepository.ListWatchlists(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(watchlists) >= maxWatchlistsPerUser {
+		return nil, NewError(http.StatusBadRequest, fmt.Sprintf("ウォッチリストは %d 件まで作成できます", maxWatchlistsPerUser))
+	}
+	now := time.Now()
+	watchlist := &internal.Watchlist{
+		UserID:      userID,
+		ID:          uuid.NewString(),
+		Name:        name,
+		EDINETCodes: codes,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	err = watchlistRepository.PutWatchlist(ctx, watchlist)
+	if err != nil {
+		return nil, err
+	}
+	return watchlist, nil
+}
+
+// ウォッチリストを更新する (指定された項目のみ変更する)
+func UpdateWatchlistProcessor(ctx context.Context, userID string, id string, reqBody internal.WatchlistBody) (*internal.Watchlist, error) {
+	watchlist, err := GetWatchlistProcessor(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if reqBody.Name != nil {
+		watchlist.Name, err = validateWatchlistName(reqBody.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if reqBody.EDINETCodes != nil {
+		watchlist.EDINETCodes, err = normalizeEDINETCodes(reqBody.EDINETCodes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	watchlist.UpdatedAt = time.Now()
+	err = watchlistRepository.PutWatchlist(ctx, watchlist)
+	if err != nil {
+		return nil, err
+	}
+	return watchlist, nil
+}
+
+func DeleteWatchlistProcessor(ctx context.Context, userID string, id string) error {
+	err := watchlistRepository.DeleteWatchlist(ctx, userID, id)
+	if errors.Is(err, ErrWatchlistNotFound) {
+		return errWatchlistNotFound
+	}
+	return err
+}
+
+/*
+最新の Fundamental を取得する
+
+ファイル名は {EDINETコード}-fundamentals-from-{期首}-to-{期末}.json のため、期末が最も新しいものを選ぶ
+*/
+func getLatestFundamental(ctx context.Context, bucketName string, EDINETCode string) (*internal.Fundamental, error) {
+	output, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(fmt.Sprintf("%s/Fundamentals/", EDINETCode)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var latestKey, latestPeriodEnd string
+	for _, item := range output.Contents {
+		key := aws.ToString(item.Key)
+		index := strings.LastIndex(key, "-to-")
+		if index < 0 || !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		periodEnd := strings.TrimSuffix(key[index+len("-to-"):], ".json")
+		if periodEnd > latestPeriodEnd {
+			latestKey, latestPeriodEnd = key, periodEnd
+		}
+	}
+	if latestKey == "" {
+		return nil, nil
+	}
+	object, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(latestKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+	body, err := io.ReadAll(object.Body)
+	if err != nil {
+		return nil, err
+	}
+	var fundamental internal.Fundamental
+	err = json.Unmarshal(body, &fundamental)
+	if err != nil {
+		return nil, err
+	}
+	return &fundamental, nil
+}
+
+/*
+ウォッチリストの概要を取得する
+
+企業ごとに B/S・P/L データの有無と最新の Fundamental を返す
+企業が未登録の場合や Fundamental がない場合も EDINET コードのみで一覧に含める
+*/
+func GetWatchlistSummaryProcessor(ctx context.Context, userID string, id string) (*internal.WatchlistSummary, error) {
+	watchlist, err := GetWatchlistProcessor(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	companies, err := QueryCompaniesByEDINETCodes(ctx, dynamoClient, conf.CompaniesTableName, watchlist.EDINETCodes)
+	if err != nil {
+		return nil, err
+	}
+	companyByCode := map[string]internal.Company{}
+	for _, company := range companies {
+		companyByCode[company.EDINETCode] = company
+	}
+
+	summary := &internal.WatchlistSummary{
+		ID:        watchlist.ID,
+		Name:      watchlist.Name,
+		Companies: make([]internal.WatchlistCompany, len(watchlist.EDINETCodes)),
+	}
+	bucketName := conf.BucketName
+	var wg sync.WaitGroup
+	// S3 への同時リクエスト数
+	sem := make(chan struct{}, 8)
+	for i, code := range watchlist.EDINETCodes {
+		company := companyByCode[code]
+		summary.Companies[i] = internal.WatchlistCompany{
+			EDINETCode: code,
+			Name:       company.Name,
+			BS:         company.BS == 1,
+			PL:         company.PL == 1,
+		}
+		wg.Add(1)
+		go func(i int, code string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fundamental, err := getLatestFundamental(ctx, bucketName, code)
+			if err != nil {
+				Logger(ctx).Error("get latest fundamental failed", "edinetCode", code, "error", err)
+				return
+			}
+			summary.Companies[i].LatestFundamental = fundamental
+		}(i, code)
+	}
+	wg.Wait()
+	return summary, nil
+}
+
+func ListWatchlistsGin(c *gin.Context) {
+	watchlists, err := ListWatchlistsProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, watchlists)
+}
+
+func GetWatchlistGin(c *gin.Context) {
+	watchlist, err := GetWatchlistProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Param("id"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, watchlist)
+}
+
+func CreateWatchlistGin(c *gin.Context) {
+	var reqBody internal.WatchlistBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	watchlist, err := CreateWatchlistProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), reqBody)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, watchlist)
+}
+
+func UpdateWatchlistGin(c *gin.Context) {
+	var reqBody internal.WatchlistBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	watchlist, err := UpdateWatchlistProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Param("id"), reqBody)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, watchlist)
+}
+
+func DeleteWatchlistGin(c *gin.Context) {
+	err := DeleteWatchlistProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Param("id"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "ウォッチリストを削除しました")
+}
+
+func GetWatchlistSummaryGin(c *gin.Context) {
+	summary, err := GetWatchlistSummaryProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Param("id"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+/*
+ウォッチリストのルーティング (Lambda、WithAuth の内側で使う)
+
+	GET    watchlists
+	POST   watchlists
+	GET    watchlists/{id}
+	PUT    watchlists/{id}
+	DELETE watchlists/{id}
+	GET    watchlists/{id}/summary
+*/
+func Watchlists(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userID := UserIDFromContext(ctx)
+	segments := strings.Split(strings.Trim(req.PathParameters["path"], "/"), "/")
+	method := req.HTTPMethod
+
+	switch {
+	case len(segments) == 1 && method == http.MethodGet:
+		watchlists, err := ListWatchlistsProcessor(ctx, userID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, watchlists)
+	case len(segments) == 1 && method == http.MethodPost:
+		var reqBody internal.WatchlistBody
+		if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+			return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		}
+		watchlist, err := CreateWatchlistProcessor(ctx, userID, reqBody)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusCreated, watchlist)
+	case len(segments) == 2 && method == http.MethodGet:
+		watchlist, err := GetWatchlistProcessor(ctx, userID, segments[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, watchlist)
+	case len(segments) == 2 && method == http.MethodPut:
+		var reqBody internal.WatchlistBody
+		if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+			return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		}
+		watchlist, err := UpdateWatchlistProcessor(ctx, userID, segments[1], reqBody)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, watchlist)
+	case len(segments) == 2 && method == http.MethodDelete:
+		err := DeleteWatchlistProcessor(ctx, userID, segments[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, "ウォッチリストを削除しました")
+	case len(segments) == 3 && segments[2] == "summary" && method == http.MethodGet:
+		summary, err := GetWatchlistSummaryProcessor(ctx, userID, segments[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, summary)
+	}
+	return errorResponse(NewError(http.StatusNotFound, "Not Found"))
+}
@@ -0,0 +1,886 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+// Webhook のイベント
+const (
+	EventFilingRegistered    = "filing.registered"    // 書類の登録完了 (バッチ)
+	EventFundamentalsUpdated = "fundamentals.updated" // Fundamental の登録 (バッチ)
+	EventNewsPublished       = "news.published"       // ニュースの公開 (ニュースバッチ)
+)
+
+var webhookEvents = []string{EventFilingRegistered, EventFundamentalsUpdated, EventNewsPublished}
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+const (
+	maxWebhooksPerUser = 10
+	// 送信履歴の保持期間
+	webhookDeliveryRetention = 30 * 24 * time.Hour
+)
+
+// Webhook と送信履歴の保存先
+type WebhookStore interface {
+	ListWebhooks(ctx context.Context, userID string) ([]internal.Webhook, error)
+	// 存在しない場合は ErrWebhookNotFound
+	GetWebhook(ctx context.Context, userID string, id string) (*internal.Webhook, error)
+	PutWebhook(ctx context.Context, webhook *internal.Webhook) error
+	DeleteWebhook(ctx context.Context, userID string, id string) error
+	// イベントを購読している全ユーザーの Webhook を返す
+	ListWebhooksByEvent(ctx context.Context, event string) ([]internal.Webhook, error)
+	SaveDelivery(ctx context.Context, delivery *internal.WebhookDelivery) error
+	// 新しい順に最大 limit 件返す
+	ListDeliveries(ctx context.Context, webhookID string, limit int) ([]internal.WebhookDelivery, error)
+}
+
+// メモリ上で Webhook を保持する (ローカル用)
+type MemoryWebhookStore struct {
+	mu         sync.RWMutex
+	webhooks   map[string]internal.Webhook
+	deliveries map[string][]internal.WebhookDelivery
+}
+
+func NewMemoryWebhookStore() *MemoryWebhookStore {
+	return &MemoryWebhookStore{
+		webhooks:   map[string]internal.Webhook{},
+		deliveries: map[string][]internal.WebhookDelivery{},
+	}
+}
+
+func (s *MemoryWebhookStore) ListWebhooks(ctx context.Context, userID string) ([]internal.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	webhooks := []internal.Webhook{}
+	for _, webhook := range s.webhooks {
+		if webhook.UserID == userID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	sortWebhooks(webhooks)
+	return webhooks, nil
+}
+
+func (s *MemoryWebhookStore) GetWebhook(ctx context.Context, userID string, id string) (*internal.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	webhook, ok := s.webhooks[id]
+	if !ok || webhook.UserID != userID {
+		return nil, ErrWebhookNotFound
+	}
+	return &webhook, nil
+}
+
+func (s *MemoryWebhookStore) PutWebhook(ctx context.Context, webhook *internal.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks[webhook.ID] = *webhook
+	return nil
+}
+
+func (s *MemoryWebhookStore) DeleteWebhook(ctx context.Context, userID string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhook, ok := s.webhooks[id]
+	if !ok || webhook.UserID != userID {
+		return ErrWebhookNotFound
+	}
+	delete(s.webhooks, id)
+	return nil
+}
+
+func (s *MemoryWebhookStore) ListWebhooksByEvent(ctx context.Context, event string) ([]internal.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var webhooks []internal.Webhook
+	for _, webhook := range s.webhooks {
+		if slices.Contains(webhook.Events, event) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+// 同じ ID の送信履歴は上書きする (再送のたびに更新するため)
+func (s *MemoryWebhookStore) SaveDelivery(ctx context.Context, delivery *internal.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := s.deliveries[delivery.WebhookID]
+	for i := range deliveries {
+		if deliveries[i].ID == delivery.ID {
+			deliveries[i] = *delivery
+			return nil
+		}
+	}
+	s.deliveries[delivery.WebhookID] = append(deliveries, *delivery)
+	return nil
+}
+
+func (s *MemoryWebhookStore) ListDeliveries(ctx context.Context, webhookID string, limit int) ([]internal.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	deliveries := []internal.WebhookDelivery{}
+	all := s.deliveries[webhookID]
+	for i := len(all) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		deliveries = append(deliveries, all[i])
+	}
+	return deliveries, nil
+}
+
+func sortWebhooks(webhooks []internal.Webhook) {
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+}
+
+/*
+DynamoDB に Webhook と送信履歴を保存する
+
+Webhook テーブル: パーティションキー userId (S)、ソートキー id (S)
+送信履歴テーブル: パーティションキー webhookId (S)、ソートキー id (S)、TTL 属性 ttl
+*/
+type DynamoWebhookStore struct {
+	Client            *dynamodb.Client
+	TableName         string
+	DeliveryTableName string
+}
+
+func (s *DynamoWebhookStore) key(userID string, id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"userId": &types.AttributeValueMemberS{Value: userID},
+		"id":     &types.AttributeValueMemberS{Value: id},
+	}
+}
+
+func (s *DynamoWebhookStore) ListWebhooks(ctx context.Context, userID string) ([]internal.Webhook, error) {
+	output, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.TableName),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	webhooks := []internal.Webhook{}
+	err = attributevalue.UnmarshalListOfMaps(output.Items, &webhooks)
+	if err != nil {
+		return nil, err
+	}
+	sortWebhooks(webhooks)
+	return webhooks, nil
+}
+
+func (s *DynamoWebhookStore) GetWebhook(ctx context.Context, userID string, id string) (*internal.Webhook, error) {
+	output, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key:       s.key(userID, id),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrWebhookNotFound
+	}
+	var webhook internal.Webhook
+	err = attributevalue.UnmarshalMap(output.Item, &webhook)
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (s *DynamoWebhookStore) PutWebhook(ctx context.Context, webhook *internal.Webhook) error {
+	item, err := attributevalue.MarshalMap(webhook)
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.TableName),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *DynamoWebhookStore) DeleteWebhook(ctx context.Context, userID string, id string) error {
+	_, err := s.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(s.TableName),
+		Key:                 s.key(userID, id),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// Webhook の件数は少ないため Scan で取得する
+func (s *DynamoWebhookStore) ListWebhooksByEvent(ctx context.Context, event string) ([]internal.Webhook, error) {
+	input := &dynamodb.ScanInput{
+		TableName:        aws.String(s.TableName),
+		FilterExpression: aws.String("contains(events, :event)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":event": &types.AttributeValueMemberS{Value: event},
+		},
+	}
+	var webhooks []internal.Webhook
+	for {
+		output, err := s.Client.Scan(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		var batch []internal.Webhook
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &batch)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, batch...)
+		if output.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return webhooks, nil
+}
+
+func (s *DynamoWebhookStore) SaveDelivery(ctx context.Context, delivery *internal.WebhookDelivery) error {
+	item, err := attributevalue.MarshalMap(delivery)
+	if err != nil {
+		return err
+	}
+	item["ttl"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(delivery.CreatedAt.Add(webhookDeliveryRetention).Unix(), 10)}
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.DeliveryTableName),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *DynamoWebhookStore) ListDeliveries(ctx context.Context, webhookID string, limit int) ([]internal.WebhookDelivery, error) {
+	output, err := s.Client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.DeliveryTableName),
+		KeyConditionExpression: aws.String("webhookId = :webhookId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":webhookId": &types.AttributeValueMemberS{Value: webhookID},
+		},
+		// id は作成日時から始まるため降順で新しい順になる
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []internal.WebhookDelivery{}
+	err = attributevalue.UnmarshalListOfMaps(output.Items, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// 設定から Webhook の保存先を決める
+func newWebhookStore() WebhookStore {
+	if tableName := conf.WebhookTableName; tableName != "" {
+		deliveryTableName := conf.WebhookDeliveryTableName
+		if deliveryTableName == "" {
+			deliveryTableName = tableName + "_deliveries"
+		}
+		return &DynamoWebhookStore{Client: dynamoClient, TableName: tableName, DeliveryTableName: deliveryTableName}
+	}
+	return NewMemoryWebhookStore()
+}
+
+// 送信履歴の状態
+const (
+	webhookDeliverySucceeded = "succeeded"
+	webhookDeliveryFailed    = "failed"
+	webhookDeliveryRetrying  = "retrying" // 再送待ち
+)
+
+/*
+Webhook の送信
+
+初回の送信が失敗した場合は送信履歴を再送待ちとして記録し、バックグラウンドで
+指数バックオフ (BaseDelay から倍々、MaxDelay まで、ジッターあり) で MaxAttempts 回まで再送する (呼び出し元は再送を待たない)
+4xx (408 / 429 を除く) は再送しても成功しないため、その時点で失敗とする
+*/
+type WebhookDispatcher struct {
+	Client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	retries     sync.WaitGroup
+	retryCtx    context.Context
+	cancelRetry context.CancelFunc
+}
+
+func NewWebhookDispatcher(client *http.Client, maxAttempts int, baseDelay time.Duration, maxDelay time.Duration) *WebhookDispatcher {
+	retryCtx, cancelRetry := context.WithCancel(context.Background())
+	return &WebhookDispatcher{
+		Client:      client,
+		MaxAttempts: maxAttempts,
+		BaseDelay:   baseDelay,
+		MaxDelay:    maxDelay,
+		retryCtx:    retryCtx,
+		cancelRetry: cancelRetry,
+	}
+}
+
+// 送信先にできないアドレスの範囲 (ループバック・プライベート・リンクローカル以外の予約済みの範囲)
+var webhookBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // キャリアグレード NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // ベンチマーク用
+	netip.MustParsePrefix("240.0.0.0/4"),   // 予約済み・ブロードキャスト
+}
+
+// 送信先にできるアドレスか (インターネット上のアドレスのみ。ループバック・プライベート・リンクローカル・メタデータサービスなどは除く)
+func isPublicWebhookAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range webhookBlockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// 接続する直前に接続先のアドレスを確認する (登録後に DNS の向き先を内部のアドレスに変更された場合のため)
+func webhookDialControl(network string, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicWebhookAddr(addrPort.Addr()) {
+		return fmt.Errorf("webhook address not allowed: %s", addrPort.Addr())
+	}
+	return nil
+}
+
+/*
+Webhook の送信に使う HTTP クライアント
+
+ローカル環境以外では内部のアドレスに接続せず、リダイレクトも追わない (3xx は失敗とする)
+*/
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: conf.WebhookTimeout}
+	if !conf.IsLocal() {
+		dialer.Control = webhookDialControl
+	}
+	return &http.Client{
+		Timeout: conf.WebhookTimeout,
+		Transport: &http.Transport{
+			// プロキシ経由では接続先のアドレスを確認できないため使わない
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		// リダイレクト先は検証していないため追わない
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func newWebhookDispatcher() *WebhookDispatcher {
+	return NewWebhookDispatcher(newWebhookClient(), conf.WebhookMaxAttempts, conf.WebhookRetryBaseDelay, time.Minute)
+}
+
+// 再送までの待ち時間 (attempt は 1 始まり)
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := time.Duration(float64(d.BaseDelay) * math.Pow(2, float64(attempt-1)))
+	if delay > d.MaxDelay || delay <= 0 {
+		delay = d.MaxDelay
+	}
+	// 同時に失敗した送信が一斉に再送しないよう 0.5〜1 倍にばらつかせる
+	return delay/2 + time.Duration(mathrand.Int63n(int64(delay/2)+1))
+}
+
+/*
+署名ヘッダーの値
+
+	X-Compass-Signature: t={UNIX時刻},v1={HMAC-SHA256("{UNIX時刻}.{本文}") の16進数}
+
+受信側は同じ計算をして比較し、時刻が古すぎるものは拒否する
+*/
+func SignWebhookPayload(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+/*
+1 件の Webhook に送信し、結果を送信履歴として記録して返す
+
+初回の送信が再送できる失敗の場合は再送待ち (retrying) として返し、再送はバックグラウンドで行う
+*/
+func (d *WebhookDispatcher) Deliver(ctx context.Context, webhook internal.Webhook, event internal.WebhookEvent) *internal.WebhookDelivery {
+	now := time.Now()
+	delivery := &internal.WebhookDelivery{
+		WebhookID: webhook.ID,
+		ID:        fmt.Sprintf("%s-%s", now.UTC().Format("20060102T150405.000Z"), uuid.NewString()[:8]),
+		EventID:   event.ID,
+		Event:     event.Type,
+		CreatedAt: now,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		delivery.Status = webhookDeliveryFailed
+		delivery.Error = err.Error()
+		delivery.CompletedAt = time.Now()
+		d.record(ctx, *delivery)
+		return delivery
+	}
+
+	retry := d.attempt(ctx, webhook, event, body, delivery)
+	// 再送の結果で上書きされないよう、再送を始める前に記録する
+	d.record(ctx, *delivery)
+	if retry {
+		d.retryLater(webhook, event, body, *delivery)
+	}
+	return delivery
+}
+
+/*
+1 回送信し、結果を delivery に反映する
+
+@return 再送するか
+*/
+func (d *WebhookDispatcher) attempt(ctx context.Context, webhook internal.Webhook, event internal.WebhookEvent, body []byte, delivery *internal.WebhookDelivery) bool {
+	delivery.Attempts++
+	status, err := d.send(ctx, webhook, event, delivery.ID, body)
+	delivery.ResponseStatus = status
+	if err == nil && status >= 200 && status < 300 {
+		delivery.Status = webhookDeliverySucceeded
+		delivery.Error = ""
+		delivery.CompletedAt = time.Now()
+		return false
+	}
+	retryable := true
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		delivery.Error = fmt.Sprintf("unexpected status: %d", status)
+		retryable = retryableStatus(status)
+	}
+	if retryable && delivery.Attempts < d.MaxAttempts {
+		delivery.Status = webhookDeliveryRetrying
+		return true
+	}
+	delivery.Status = webhookDeliveryFailed
+	delivery.CompletedAt = time.Now()
+	return false
+}
+
+// バックグラウンドで再送する (Wait で取りやめた場合は失敗として記録する)
+func (d *WebhookDispatcher) retryLater(webhook internal.Webhook, event internal.WebhookEvent, body []byte, delivery internal.WebhookDelivery) {
+	d.retries.Add(1)
+	go func() {
+		defer d.retries.Done()
+		for retry := true; retry; {
+			select {
+			case <-d.retryCtx.Done():
+				delivery.Status = webhookDeliveryFailed
+				delivery.Error = "retry canceled: " + delivery.Error
+				delivery.CompletedAt = time.Now()
+				// 取りやめた後も記録できるよう、再送用のコンテキストは使わない
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				d.record(ctx, delivery)
+				return
+			case <-time.After(d.backoff(delivery.Attempts)):
+			}
+			// 送信中に取りやめた場合は再送できる失敗となり、次のループで失敗として記録する
+			retry = d.attempt(d.retryCtx, webhook, event, body, &delivery)
+		}
+		d.record(d.retryCtx, delivery)
+	}()
+}
+
+// 送信履歴を記録する
+func (d *WebhookDispatcher) record(ctx context.Context, delivery internal.WebhookDelivery) {
+	if delivery.Status == webhookDeliveryFailed {
+		Logger(ctx).Warn("Webhook の送信に失敗しました", "webhookId", delivery.WebhookID, "event", delivery.Event, "attempts", delivery.Attempts, "error", delivery.Error)
+	}
+	if err := webhookStore.SaveDelivery(ctx, &delivery); err != nil {
+		Logger(ctx).Error("SaveDelivery failed", "webhookId", delivery.WebhookID, "error", err)
+	}
+}
+
+/*
+再送待ちの送信が全て終わるまで待つ
+
+ctx が終了した場合は残りの再送を取りやめて失敗として記録し、ctx のエラーを返す (以降は再送しない)
+*/
+func (d *WebhookDispatcher) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.retries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.cancelRetry()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, webhook internal.Webhook, event internal.WebhookEvent, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "compass-webhook/1.0")
+	req.Header.Set("X-Compass-Event", event.Type)
+	req.Header.Set("X-Compass-Delivery", deliveryID)
+	req.Header.Set("X-Compass-Signature", SignWebhookPayload(webhook.Secret, time.Now(), body))
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// 接続を再利用するため本文を読み捨てる
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	return res.StatusCode, nil
+}
+
+/*
+イベントを購読している Webhook に送信する
+
+Webhook ごとに並行して初回の送信を行い、それが終わるまで待つ (再送は待たない)
+送信結果は送信履歴に記録する
+*/
+func PublishEvent(ctx context.Context, eventType string, data interface{}) error {
+	webhooks, err := webhookStore.ListWebhooksByEvent(ctx, eventType)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+	event := internal.WebhookEvent{
+		ID:        uuid.NewString(),
+		Type:      eventType,
+		CreatedAt: time.Now(),
+		Data:      data,
+	}
+	var wg sync.WaitGroup
+	for _, webhook := range webhooks {
+		wg.Add(1)
+		go func(webhook internal.Webhook) {
+			defer wg.Done()
+			webhookDispatcher.Deliver(ctx, webhook, event)
+		}(webhook)
+	}
+	wg.Wait()
+	return nil
+}
+
+/*
+再送待ちの Webhook の送信が終わるまで待つ (バッチの終了前に呼ぶ)
+
+WEBHOOK_RETRY_TIMEOUT (デフォルト 2m) を過ぎた場合は残りの再送を取りやめ、失敗として記録する
+*/
+func WaitWebhookRetries(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, conf.WebhookRetryTimeout)
+	defer cancel()
+	return webhookDispatcher.Wait(ctx)
+}
+
+/*
+送信先 URL を検証する
+
+ローカル環境以外は https のみとし、ホストを解決して内部のアドレス (ループバック・プライベート・リンクローカルなど) を拒否する
+*/
+func validateWebhookURL(ctx context.Context, rawURL *string) (string, error) {
+	if rawURL == nil || *rawURL == "" {
+		return "", NewError(http.StatusBadRequest, "URL を指定してください")
+	}
+	u, err := url.Parse(*rawURL)
+	if err != nil || u.Host == "" {
+		return "", NewError(http.StatusBadRequest, "URL の形式が正しくありません")
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && conf.IsLocal()) {
+		return "", NewError(http.StatusBadRequest, "URL は https で指定してください")
+	}
+	if conf.IsLocal() {
+		return u.String(), nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return "", NewError(http.StatusBadRequest, "URL のホストを解決できません")
+	}
+	for _, addr := range addrs {
+		if !isPublicWebhookAddr(addr) {
+			return "", NewError(http.StatusBadRequest, "URL に内部のアドレス (プライベート・ループバックなど) は指定できません")
+		}
+	}
+	return u.String(), nil
+}
+
+func validateWebhookEvents(eventTypes []string) ([]string, error) {
+	if len(eventTypes) == 0 {
+		return nil, NewError(http.StatusBadRequest, "イベントを指定してください")
+	}
+	var normalized []string
+	for _, eventType := range eventTypes {
+		if !slices.Contains(webhookEvents, eventType) {
+			return nil, NewError(http.StatusBadRequest, fmt.Sprintf("未対応のイベントです: %s (%s)", eventType, strings.Join(webhookEvents, " / ")))
+		}
+		if !slices.Contains(normalized, eventType) {
+			normalized = append(normalized, eventType)
+		}
+	}
+	return normalized, nil
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+var errWebhookNotFound = NewError(http.StatusNotFound, "Webhook が見つかりません")
+
+// 一覧では署名用のシークレットを返さない
+func ListWebhooksProcessor(ctx context.Context, userID string) ([]internal.Webhook, error) {
+	webhooks, err := webhookStore.ListWebhooks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+func GetWebhookProcessor(ctx context.Context, userID string, id string) (*internal.Webhook, error) {
+	webhook, err := webhookStore.GetWebhook(ctx, userID, id)
+	if errors.Is(err, ErrWebhookNotFound) {
+		return nil, errWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+// Webhook を登録する (署名用のシークレットはこのレスポンスでのみ返す)
+func CreateWebhookProcessor(ctx context.Context, userID string, reqBody internal.WebhookBody) (*internal.Webhook, error) {
+	webhookURL, err := validateWebhookURL(ctx, reqBody.URL)
+	if err != nil {
+		return nil, err
+	}
+	eventTypes, err := validateWebhookEvents(reqBody.Events)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := webhookStore.ListWebhooks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(webhooks) >= maxWebhooksPerUser {
+		return nil, NewError(http.StatusBadRequest, fmt.Sprintf("Webhook は %d 件まで登録できます", maxWebhooksPerUser))
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	webhook := &internal.Webhook{
+		UserID:    userID,
+		ID:        uuid.NewString(),
+		URL:       webhookURL,
+		Events:    eventTypes,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	err = webhookStore.PutWebhook(ctx, webhook)
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func DeleteWebhookProcessor(ctx context.Context, userID string, id string) error {
+	err := webhookStore.DeleteWebhook(ctx, userID, id)
+	if errors.Is(err, ErrWebhookNotFound) {
+		return errWebhookNotFound
+	}
+	return err
+}
+
+// 送信履歴を新しい順に取得する (limit は最大 100)
+func ListWebhookDeliveriesProcessor(ctx context.Context, userID string, id string, limit string) ([]internal.WebhookDelivery, error) {
+	// 他のユーザーの Webhook の履歴は参照できない
+	if _, err := GetWebhookProcessor(ctx, userID, id); err != nil {
+		return nil, err
+	}
+	n := 50
+	if limit != "" {
+		v, err := strconv.Atoi(limit)
+		if err != nil || v <= 0 {
+			return nil, NewError(http.StatusBadRequest, "limit は正の整数で指定してください")
+		}
+		n = min(v, 100)
+	}
+	return webhookStore.ListDeliveries(ctx, id, n)
+}
+
+func ListWebhooksGin(c *gin.Context) {
+	webhooks, err := ListWebhooksProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+func GetWebhookGin(c *gin.Context) {
+	webhook, err := GetWebhookProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Param("id"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+func CreateWebhookGin(c *gin.Context) {
+	var reqBody internal.WebhookBody
+	if err := c.ShouldBindJSON(&reqBody); err != nil {
+		ginError(c, NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		return
+	}
+	webhook, err := CreateWebhookProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), reqBody)
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, webhook)
+}
+
+func DeleteWebhookGin(c *gin.Context) {
+	err := DeleteWebhookProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Param("id"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "Webhook を削除しました")
+}
+
+func ListWebhookDeliveriesGin(c *gin.Context) {
+	deliveries, err := ListWebhookDeliveriesProcessor(c.Request.Context(), UserIDFromContext(c.Request.Context()), c.Param("id"), c.Query("limit"))
+	if err != nil {
+		ginError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+/*
+Webhook のルーティング (Lambda、WithAuth の内側で使う)
+
+	GET    webhooks
+	POST   webhooks
+	GET    webhooks/{id}
+	DELETE webhooks/{id}
+	GET    webhooks/{id}/deliveries
+*/
+func Webhooks(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userID := UserIDFromContext(ctx)
+	segments := strings.Split(strings.Trim(req.PathParameters["path"], "/"), "/")
+	method := req.HTTPMethod
+
+	switch {
+	case len(segments) == 1 && method == http.MethodGet:
+		webhooks, err := ListWebhooksProcessor(ctx, userID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, webhooks)
+	case len(segments) == 1 && method == http.MethodPost:
+		var reqBody internal.WebhookBody
+		if err := json.Unmarshal([]byte(req.Body), &reqBody); err != nil {
+			return errorResponse(NewError(http.StatusBadRequest, "リクエストの形式が正しくありません"))
+		}
+		webhook, err := CreateWebhookProcessor(ctx, userID, reqBody)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusCreated, webhook)
+	case len(segments) == 2 && method == http.MethodGet:
+		webhook, err := GetWebhookProcessor(ctx, userID, segments[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, webhook)
+	case len(segments) == 2 && method == http.MethodDelete:
+		err := DeleteWebhookProcessor(ctx, userID, segments[1])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, "Webhook を削除しました")
+	case len(segments) == 3 && segments[2] == "deliveries" && method == http.MethodGet:
+		deliveries, err := ListWebhookDeliveriesProcessor(ctx, userID, segments[1], req.QueryStringParameters["limit"])
+		if err != nil {
+			return errorResponse(err)
+		}
+		return jsonResponse(http.StatusOK, deliveries)
+	}
+	return errorResponse(NewError(http.StatusNotFound, "Not Found"))
+}
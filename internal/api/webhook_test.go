@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joe-black-jb/compass-api/internal"
+)
+
+func useEnv(t *testing.T, env string) {
+	t.Helper()
+	prev := conf.Env
+	conf.Env = env
+	t.Cleanup(func() { conf.Env = prev })
+}
+
+func TestIsPublicWebhookAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		// IPv4 射影アドレスで書いたループバック
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicWebhookAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicWebhookAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://93.184.216.34/hook", false},
+		{"http://93.184.216.34/hook", true},
+		{"https://127.0.0.1/hook", true},
+		{"https://[::1]:8443/hook", true},
+		{"https://10.0.0.5/hook", true},
+		{"https://169.254.169.254/latest/meta-data/", true},
+		{"https://[::ffff:192.168.0.1]/hook", true},
+		{"https:///hook", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			rawURL := tt.url
+			_, err := validateWebhookURL(context.Background(), &rawURL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// ローカル環境では localhost の http も使える
+	useEnv(t, "local")
+	rawURL := "http://127.0.0.1:8080/hook"
+	if _, err := validateWebhookURL(context.Background(), &rawURL); err != nil {
+		t.Errorf("ローカル環境で拒否しました: %v", err)
+	}
+}
+
+func useWebhookStore(t *testing.T) *MemoryWebhookStore {
+	t.Helper()
+	store := NewMemoryWebhookStore()
+	prev := webhookStore
+	webhookStore = store
+	t.Cleanup(func() { webhookStore = prev })
+	return store
+}
+
+func testWebhookDispatcher() *WebhookDispatcher {
+	return NewWebhookDispatcher(newWebhookClient(), 1, time.Millisecond, time.Millisecond)
+}
+
+func TestWebhookDispatcherRejectsInternalAddress(t *testing.T) {
+	useWebhookStore(t)
+	var received bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer server.Close()
+
+	// 登録後に内部のアドレスを指すようになった場合も接続しない
+	webhook := internal.Webhook{ID: "wh-1", URL: server.URL, Secret: "secret"}
+	delivery := testWebhookDispatcher().Deliver(context.Background(), webhook, internal.WebhookEvent{ID: "ev-1", Type: EventNewsPublished})
+	if delivery.Status != "failed" || !strings.Contains(delivery.Error, "not allowed") {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if received {
+		t.Error("内部のアドレスに送信しました")
+	}
+}
+
+func TestWebhookDispatcherDoesNotFollowRedirects(t *testing.T) {
+	useEnv(t, "local")
+	useWebhookStore(t)
+	var redirected bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			redirected = true
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	webhook := internal.Webhook{ID: "wh-1", URL: server.URL + "/hook", Secret: "secret"}
+	delivery := testWebhookDispatcher().Deliver(context.Background(), webhook, internal.WebhookEvent{ID: "ev-1", Type: EventNewsPublished})
+	if delivery.Status != "failed" || delivery.ResponseStatus != http.StatusTemporaryRedirect {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if redirected {
+		t.Error("リダイレクト先に送信しました")
+	}
+}
+
+func TestWebhookDispatcherRetriesInBackground(t *testing.T) {
+	useEnv(t, "local")
+	store := useWebhookStore(t)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	dispatcher := NewWebhookDispatcher(newWebhookClient(), 3, 10*time.Millisecond, 10*time.Millisecond)
+	webhook := internal.Webhook{ID: "wh-1", URL: server.URL, Secret: "secret"}
+	// 初回の送信だけで返る
+	delivery := dispatcher.Deliver(context.Background(), webhook, internal.WebhookEvent{ID: "ev-1", Type: EventNewsPublished})
+	if delivery.Status != webhookDeliveryRetrying || delivery.Attempts != 1 {
+		t.Fatalf("delivery = %+v", delivery)
+	}
+
+	if err := dispatcher.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, _ := store.ListDeliveries(context.Background(), "wh-1", 10)
+	if len(deliveries) != 1 {
+		t.Fatalf("送信履歴が %d 件あります", len(deliveries))
+	}
+	if got := deliveries[0]; got.Status != webhookDeliverySucceeded || got.Attempts != 2 || got.ID != delivery.ID {
+		t.Errorf("delivery = %+v", got)
+	}
+}
+
+func TestWebhookDispatcherWaitCancelsRetries(t *testing.T) {
+	useEnv(t, "local")
+	store := useWebhookStore(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dispatcher := NewWebhookDispatcher(newWebhookClient(), 5, time.Hour, time.Hour)
+	webhook := internal.Webhook{ID: "wh-1", URL: server.URL, Secret: "secret"}
+	dispatcher.Deliver(context.Background(), webhook, internal.WebhookEvent{ID: "ev-1", Type: EventNewsPublished})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := dispatcher.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("再送を打ち切るまでに %v かかりました", elapsed)
+	}
+	deliveries, _ := store.ListDeliveries(context.Background(), "wh-1", 10)
+	if len(deliveries) != 1 || deliveries[0].Status != webhookDeliveryFailed || !strings.HasPrefix(deliveries[0].Error, "retry canceled") {
+		t.Errorf("deliveries = %+v", deliveries)
+	}
+}
@@ -0,0 +1,494 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+アプリケーションの設定
+
+各項目は env タグの環境変数名で設定する (YAML ファイルではその小文字をキーにする)
+優先順位は 環境変数 > .env ファイル > YAML ファイル (CONFIG_FILE) > default タグの値
+*/
+type Config struct {
+	Env      string `env:"ENV"`
+	LogLevel string `env:"LOG_LEVEL" default:"info"`
+	Region   string `env:"REGION"`
+
+	// HTTP サーバー (Lambda 以外で起動する場合)
+	ServerMode              string        `env:"SERVER_MODE"`
+	ServerAddr              string        `env:"SERVER_ADDR" default:"0.0.0.0:8080"`
+	TLSCertFile             string        `env:"TLS_CERT_FILE"`
+	TLSKeyFile              string        `env:"TLS_KEY_FILE"`
+	ServerReadTimeout       time.Duration `env:"SERVER_READ_TIMEOUT" default:"15s"`
+	ServerReadHeaderTimeout time.Duration `env:"SERVER_READ_HEADER_TIMEOUT" default:"5s"`
+	ServerWriteTimeout      time.Duration `env:"SERVER_WRITE_TIMEOUT" default:"30s"`
+	ServerIdleTimeout       time.Duration `env:"SERVER_IDLE_TIMEOUT" default:"120s"`
+	ServerShutdownTimeout   time.Duration `env:"SERVER_SHUTDOWN_TIMEOUT" default:"30s"`
+	CORSAllowOrigins        []string      `env:"CORS_ALLOW_ORIGINS" default:"http://localhost:3000"`
+	// X-Forwarded-For を信頼するプロキシ (IP または CIDR、カンマ区切り)。未指定の場合は接続元の IP をクライアント IP とする
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
+
+	// 企業
+	CompaniesTableName string `env:"DYNAMO_TABLE_NAME" default:"compass_companies"`
+	BucketName         string `env:"BUCKET_NAME"`
+
+	// 書類の署名付き URL (/reports?mode=url) の有効期間
+	ReportURLTTL time.Duration `env:"REPORT_URL_TTL" default:"5m"`
+	// 提出書類の原本の署名付き URL (/filings/{docID}/xbrl・pdf) の有効期間
+	FilingURLTTL time.Duration `env:"FILING_URL_TTL" default:"5m"`
+
+	// 企業の概要 (/companies/{id}/overview) の項目ごとの取得時間の上限
+	OverviewTimeout time.Duration `env:"OVERVIEW_TIMEOUT" default:"3s"`
+
+	// ニュース
+	NewsBucketName   string `env:"NEWS_BUCKET_NAME"`
+	NewsLocalDir     string `env:"NEWS_LOCAL_DIR"`
+	NewsCategoryFile string `env:"NEWS_CATEGORY_FILE"`
+
+	// ヘルスチェック・メトリクス
+	ReadinessTimeout  time.Duration `env:"READINESS_TIMEOUT" default:"3s"`
+	ReadinessCacheTTL time.Duration `env:"READINESS_CACHE_TTL" default:"5s"`
+	MetricsNamespace  string        `env:"METRICS_NAMESPACE" default:"CompassAPI"`
+
+	// API キー・レート制限
+	APIKeyTableName    string  `env:"API_KEY_TABLE_NAME"`
+	APIKeyFile         string  `env:"API_KEY_FILE"`
+	RateLimitTableName string  `env:"RATE_LIMIT_TABLE_NAME"`
+	RateLimitIPRPS     float64 `env:"RATE_LIMIT_IP_RPS" default:"10"`
+	RateLimitIPBurst   int     `env:"RATE_LIMIT_IP_BURST" default:"30"`
+
+	// JWT
+	SecretKey           string        `env:"SECRET_KEY"`
+	JWTIssuer           string        `env:"JWT_ISSUER"`
+	JWTAudience         string        `env:"JWT_AUDIENCE"`
+	JWKSURL             string        `env:"JWKS_URL"`
+	JWKSFile            string        `env:"JWKS_FILE"`
+	JWKSRefreshInterval time.Duration `env:"JWKS_REFRESH_INTERVAL" default:"1h"`
+	JWTKeyRotationGrace time.Duration `env:"JWT_KEY_ROTATION_GRACE" default:"24h"`
+	JWTKeyID            string        `env:"JWT_KEY_ID"`
+	JWTPrivateKeyFile   string        `env:"JWT_PRIVATE_KEY_FILE"`
+	AccessTokenTTL      time.Duration `env:"ACCESS_TOKEN_TTL" default:"15m"`
+	RefreshTokenTTL     time.Duration `env:"REFRESH_TOKEN_TTL" default:"720h"`
+	TokenTableName      string        `env:"TOKEN_TABLE_NAME"`
+
+	// ユーザー
+	UserTableName         string        `env:"USER_TABLE_NAME"`
+	LoginAttemptTableName string        `env:"LOGIN_ATTEMPT_TABLE_NAME"`
+	LoginMaxFailures      int           `env:"LOGIN_MAX_FAILURES" default:"5"`
+	LoginMaxIPFailures    int           `env:"LOGIN_MAX_IP_FAILURES" default:"20"`
+	LoginLockoutDuration  time.Duration `env:"LOGIN_LOCKOUT_DURATION" default:"15m"`
+	EmailVerificationTTL  time.Duration `env:"EMAIL_VERIFICATION_TTL" default:"24h"`
+	PasswordResetTTL      time.Duration `env:"PASSWORD_RESET_TTL" default:"1h"`
+	AppBaseURL            string        `env:"APP_BASE_URL" default:"http://localhost:3000"`
+
+	// メール
+	MailFrom       string `env:"MAIL_FROM" default:"no-reply@compass.local"`
+	SMTPHost       string `env:"SMTP_HOST"`
+	SMTPPort       string `env:"SMTP_PORT" default:"587"`
+	SMTPUsername   string `env:"SMTP_USERNAME"`
+	SMTPPassword   string `env:"SMTP_PASSWORD"`
+	MailOutputFile string `env:"MAIL_OUTPUT_FILE"`
+
+	// ウォッチリスト・通知・Webhook
+	WatchlistTableName       string        `env:"WATCHLIST_TABLE_NAME"`
+	SubscriptionTableName    string        `env:"SUBSCRIPTION_TABLE_NAME"`
+	AlertTableName           string        `env:"ALERT_TABLE_NAME"`
+	WebhookTableName         string        `env:"WEBHOOK_TABLE_NAME"`
+	WebhookDeliveryTableName string        `env:"WEBHOOK_DELIVERY_TABLE_NAME"`
+	WebhookMaxAttempts       int           `env:"WEBHOOK_MAX_ATTEMPTS" default:"5"`
+	WebhookTimeout           time.Duration `env:"WEBHOOK_TIMEOUT" default:"10s"`
+	WebhookRetryBaseDelay    time.Duration `env:"WEBHOOK_RETRY_BASE_DELAY" default:"1s"`
+	WebhookRetryTimeout      time.Duration `env:"WEBHOOK_RETRY_TIMEOUT" default:"2m"`
+
+	// 書類バッチ (batch/getXBRL.go)
+	EDINETAPIKey    string `env:"EDINET_API_KEY"`
+	EDINETSubAPIKey string `env:"EDINET_SUB_API_KEY"`
+	// 提出書類の原本 (XBRL・PDF) の保存先 (API の /filings でも使う)
+	EDINETBucketName     string `env:"EDINET_BUCKET_NAME"`
+	RegisterSingleReport bool   `env:"REGISTER_SINGLE_REPORT"`
+	GetXBRLFromS3        bool   `env:"GET_XBRL_FROM_S3"`
+
+	// ニュースバッチ (newsBatch/getNews.go)
+	NewsFeeds     []string `env:"NEWS_FEEDS"`
+	NewsFeedsFile string   `env:"NEWS_FEEDS_FILE"`
+	NewsMaxItems  int      `env:"NEWS_MAX_ITEMS" default:"50"`
+	NewsDate      string   `env:"NEWS_DATE"`
+	NewsAmPm      string   `env:"NEWS_AMPM"`
+}
+
+// 設定の検証エラー (問題のある項目をまとめて返す)
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "設定が正しくありません: " + strings.Join(e.Problems, "; ")
+}
+
+// ローカル環境 (ENV=local) かどうか
+func (c *Config) IsLocal() bool {
+	return c.Env == "local"
+}
+
+/*
+HTTP サーバーとして起動するかどうか
+
+SERVER_MODE が http の場合はサーバー、lambda の場合は Lambda
+未指定の場合はローカル環境 (ENV=local) のみサーバーとして起動する
+*/
+func (c *Config) UseHTTPServer() bool {
+	if c.ServerMode == "" {
+		return c.IsLocal()
+	}
+	return c.ServerMode == "http"
+}
+
+// default タグの値のみの設定
+func Default() *Config {
+	c, err := load(func(string) (string, bool) { return "", false })
+	if err != nil {
+		// default タグの値は固定のため、ここでエラーになることはない
+		panic(err)
+	}
+	return c
+}
+
+/*
+環境変数・.env ファイル・YAML ファイルから設定を読み込む
+
+	CONFIG_FILE: YAML ファイルのパス (未指定の場合は読み込まない)
+	ENV_FILE:    .env ファイルのパス (デフォルト .env、ファイルがない場合は読み込まない)
+*/
+func Load() (*Config, error) {
+	values := map[string]string{}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		fileValues, err := readYAML(path)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileValues {
+			values[k] = v
+		}
+	}
+
+	envFile := os.Getenv("ENV_FILE")
+	if envFile == "" {
+		envFile = ".env"
+	}
+	dotenv, err := godotenv.Read(envFile)
+	if err != nil && !(errors.Is(err, os.ErrNotExist) && os.Getenv("ENV_FILE") == "") {
+		return nil, fmt.Errorf("%s の読み込みエラー: %w", envFile, err)
+	}
+	for k, v := range dotenv {
+		values[k] = v
+	}
+
+	return load(func(name string) (string, bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			return v, true
+		}
+		v, ok := values[name]
+		return v, ok
+	})
+}
+
+// YAML ファイルを読み込み、環境変数名をキーにした値を返す
+func readYAML(path string) (map[string]string, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s の読み込みエラー: %w", path, err)
+	}
+	var raw map[string]interface{}
+	err = yaml.Unmarshal(body, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s の読み込みエラー: %w", path, err)
+	}
+
+	known := map[string]bool{}
+	for _, name := range envNames() {
+		known[name] = true
+	}
+	values := map[string]string{}
+	for key, value := range raw {
+		name := strings.ToUpper(key)
+		if !known[name] {
+			return nil, fmt.Errorf("%s: 未知の設定項目です: %s", path, key)
+		}
+		switch v := value.(type) {
+		case nil:
+			values[name] = ""
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+func envNames() []string {
+	t := reflect.TypeOf(Config{})
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		names = append(names, t.Field(i).Tag.Get("env"))
+	}
+	return names
+}
+
+// lookup で取得した値 (なければ default タグの値) を各項目に設定する
+func load(lookup func(name string) (string, bool)) (*Config, error) {
+	c := &Config{}
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	var problems []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("env")
+		value, ok := lookup(name)
+		if !ok || value == "" {
+			value = field.Tag.Get("default")
+		}
+		if value == "" {
+			continue
+		}
+		err := setField(v.Field(i), value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s=%q: %v", name, value, err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return c, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("true / false を指定してください")
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("整数を指定してください")
+		}
+		field.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("数値を指定してください")
+		}
+		field.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("期間 (例: 30s, 15m, 24h) を指定してください")
+		}
+		field.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("未対応の型です: %s", field.Type())
+	}
+	return nil
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) require(name string, value string) {
+	if value == "" {
+		v.problems = append(v.problems, name+" を設定してください")
+	}
+}
+
+func (v *validator) requireOneOf(value1 string, value2 string, names ...string) {
+	if value1 == "" && value2 == "" {
+		v.problems = append(v.problems, strings.Join(names, " または ")+" を設定してください")
+	}
+}
+
+func (v *validator) positive(name string, value float64) {
+	if value <= 0 {
+		v.problems = append(v.problems, name+" には正の値を指定してください")
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+func (v *validator) common(c *Config) {
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		v.problems = append(v.problems, "LOG_LEVEL には debug / info / warn / error のいずれかを指定してください")
+	}
+	v.require("DYNAMO_TABLE_NAME", c.CompaniesTableName)
+}
+
+func (v *validator) server(c *Config) {
+	switch c.ServerMode {
+	case "", "http", "lambda":
+	default:
+		v.problems = append(v.problems, "SERVER_MODE には http / lambda のいずれかを指定してください")
+	}
+	if !c.UseHTTPServer() {
+		return
+	}
+	v.require("SERVER_ADDR", c.ServerAddr)
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		v.problems = append(v.problems, "TLS_CERT_FILE と TLS_KEY_FILE は両方設定してください")
+	}
+	v.positive("SERVER_READ_TIMEOUT", float64(c.ServerReadTimeout))
+	v.positive("SERVER_READ_HEADER_TIMEOUT", float64(c.ServerReadHeaderTimeout))
+	v.positive("SERVER_WRITE_TIMEOUT", float64(c.ServerWriteTimeout))
+	v.positive("SERVER_IDLE_TIMEOUT", float64(c.ServerIdleTimeout))
+	v.positive("SERVER_SHUTDOWN_TIMEOUT", float64(c.ServerShutdownTimeout))
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			v.problems = append(v.problems, fmt.Sprintf("TRUSTED_PROXIES の %q は IP アドレスまたは CIDR (例: 10.0.0.0/8) で指定してください", proxy))
+		}
+	}
+	for _, origin := range c.CORSAllowOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			v.problems = append(v.problems, fmt.Sprintf("CORS_ALLOW_ORIGINS の %q はオリジン (例: https://example.com) で指定してください", origin))
+		}
+	}
+}
+
+// Lambda ではインスタンスごとにメモリが分かれるため、メモリ上の保存先は使えない (DynamoDB のテーブルを必須にする)
+func (v *validator) lambda(c *Config) {
+	if c.UseHTTPServer() {
+		return
+	}
+	v.require("USER_TABLE_NAME", c.UserTableName)
+	v.require("TOKEN_TABLE_NAME", c.TokenTableName)
+	v.require("LOGIN_ATTEMPT_TABLE_NAME", c.LoginAttemptTableName)
+	v.require("WATCHLIST_TABLE_NAME", c.WatchlistTableName)
+	v.require("SUBSCRIPTION_TABLE_NAME", c.SubscriptionTableName)
+	v.require("ALERT_TABLE_NAME", c.AlertTableName)
+	// 送信履歴のテーブルは未指定の場合 {WEBHOOK_TABLE_NAME}_deliveries を使う
+	v.require("WEBHOOK_TABLE_NAME", c.WebhookTableName)
+	// メモリ上ではインスタンスごとの制限になる
+	v.require("RATE_LIMIT_TABLE_NAME", c.RateLimitTableName)
+}
+
+// API の起動に必要な設定を検証する
+func (c *Config) ValidateAPI() error {
+	v := &validator{}
+	v.common(c)
+	v.require("REGION", c.Region)
+	v.require("BUCKET_NAME", c.BucketName)
+	v.requireOneOf(c.NewsBucketName, c.NewsLocalDir, "NEWS_BUCKET_NAME", "NEWS_LOCAL_DIR")
+	v.requireOneOf(c.SecretKey, c.JWTPrivateKeyFile, "SECRET_KEY", "JWT_PRIVATE_KEY_FILE")
+	v.positive("RATE_LIMIT_IP_RPS", c.RateLimitIPRPS)
+	v.positive("RATE_LIMIT_IP_BURST", float64(c.RateLimitIPBurst))
+	v.positive("LOGIN_MAX_FAILURES", float64(c.LoginMaxFailures))
+	v.positive("LOGIN_MAX_IP_FAILURES", float64(c.LoginMaxIPFailures))
+	v.positive("WEBHOOK_MAX_ATTEMPTS", float64(c.WebhookMaxAttempts))
+	v.positive("OVERVIEW_TIMEOUT", float64(c.OverviewTimeout))
+	v.positive("REPORT_URL_TTL", float64(c.ReportURLTTL))
+	// S3 の署名付き URL の有効期間は最長 7 日
+	if c.ReportURLTTL > 7*24*time.Hour {
+		v.problems = append(v.problems, "REPORT_URL_TTL は 168h 以下を指定してください")
+	}
+	v.positive("FILING_URL_TTL", float64(c.FilingURLTTL))
+	if c.FilingURLTTL > 7*24*time.Hour {
+		v.problems = append(v.problems, "FILING_URL_TTL は 168h 以下を指定してください")
+	}
+	if u, err := url.Parse(c.AppBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		v.problems = append(v.problems, "APP_BASE_URL には URL (例: https://example.com) を指定してください")
+	}
+	// ローカル環境以外ではメールを標準出力・ファイルに書き出さず、API キーの検証も省略しない
+	if !c.IsLocal() {
+		v.require("SMTP_HOST", c.SMTPHost)
+		v.requireOneOf(c.APIKeyTableName, c.APIKeyFile, "API_KEY_TABLE_NAME", "API_KEY_FILE")
+	} else if c.SMTPUsername != "" && c.SMTPHost == "" {
+		v.problems = append(v.problems, "SMTP_USERNAME を指定する場合は SMTP_HOST を設定してください")
+	}
+	v.server(c)
+	v.lambda(c)
+	return v.err()
+}
+
+// 書類バッチ (batch/getXBRL.go) に必要な設定を検証する
+func (c *Config) ValidateBatch() error {
+	v := &validator{}
+	v.common(c)
+	v.require("REGION", c.Region)
+	v.require("BUCKET_NAME", c.BucketName)
+	v.require("EDINET_BUCKET_NAME", c.EDINETBucketName)
+	v.require("EDINET_SUB_API_KEY", c.EDINETSubAPIKey)
+	// 購読・通知を API と共有する
+	v.require("SUBSCRIPTION_TABLE_NAME", c.SubscriptionTableName)
+	v.require("ALERT_TABLE_NAME", c.AlertTableName)
+	// Webhook の送信先を API と共有する
+	v.require("WEBHOOK_TABLE_NAME", c.WebhookTableName)
+	v.positive("WEBHOOK_RETRY_TIMEOUT", float64(c.WebhookRetryTimeout))
+	return v.err()
+}
+
+// ニュースバッチ (newsBatch/getNews.go) に必要な設定を検証する
+func (c *Config) ValidateNewsBatch() error {
+	v := &validator{}
+	v.common(c)
+	if len(c.NewsFeeds) == 0 && c.NewsFeedsFile == "" {
+		v.problems = append(v.problems, "NEWS_FEEDS または NEWS_FEEDS_FILE を設定してください")
+	}
+	v.requireOneOf(c.NewsBucketName, c.NewsLocalDir, "NEWS_BUCKET_NAME", "NEWS_LOCAL_DIR")
+	if c.NewsLocalDir == "" {
+		v.require("REGION", c.Region)
+		v.require("WEBHOOK_TABLE_NAME", c.WebhookTableName)
+	}
+	v.positive("NEWS_MAX_ITEMS", float64(c.NewsMaxItems))
+	v.positive("WEBHOOK_RETRY_TIMEOUT", float64(c.WebhookRetryTimeout))
+	if c.NewsDate != "" {
+		if _, err := time.Parse("2006-01-02", c.NewsDate); err != nil {
+			v.problems = append(v.problems, "NEWS_DATE は YYYY-MM-DD 形式で指定してください")
+		}
+	}
+	if c.NewsAmPm != "" && c.NewsAmPm != "am" && c.NewsAmPm != "pm" {
+		v.problems = append(v.problems, "NEWS_AMPM には am / pm のいずれかを指定してください")
+	}
+	return v.err()
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// テスト中だけ環境変数を未設定にする (t.Setenv で空にすると「設定あり」になるため)
+func unsetEnv(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		prev, ok := os.LookupEnv(name)
+		os.Unsetenv(name)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, prev)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name string, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mapLookup(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := values[name]
+		return v, ok
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	unsetEnv(t, "REGION", "BUCKET_NAME", "READINESS_TIMEOUT", "NEWS_FEEDS", "SERVER_ADDR")
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", `region: yaml-region
+bucket_name: yaml-bucket
+news_bucket_name: yaml-news
+readiness_timeout: 5s
+news_feeds:
+  - https://example.com/a.xml
+  - https://example.com/b.xml
+`))
+	t.Setenv("ENV_FILE", writeFile(t, ".env", "BUCKET_NAME=dotenv-bucket\nNEWS_BUCKET_NAME=dotenv-news\n"))
+	t.Setenv("NEWS_BUCKET_NAME", "env-news")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"YAML のみ", c.Region, "yaml-region"},
+		{".env > YAML", c.BucketName, "dotenv-bucket"},
+		{"環境変数 > .env > YAML", c.NewsBucketName, "env-news"},
+		{"期間", c.ReadinessTimeout, 5 * time.Second},
+		{"YAML のリスト", c.NewsFeeds, []string{"https://example.com/a.xml", "https://example.com/b.xml"}},
+		{"デフォルト値", c.ServerAddr, "0.0.0.0:8080"},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownYAMLKey(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", "region: ap-northeast-1\nbucket: typo\n"))
+	t.Setenv("ENV_FILE", writeFile(t, ".env", ""))
+	_, err := Load()
+	if err == nil || !strings.Contains(err.Error(), "未知の設定項目です: bucket") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestLoadMissingEnvFile(t *testing.T) {
+	unsetEnv(t, "CONFIG_FILE")
+	// 明示的に指定した .env ファイルがない場合はエラー
+	t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+	if _, err := Load(); err == nil {
+		t.Error("存在しない ENV_FILE を読み込みました")
+	}
+}
+
+func TestLoadParsesValues(t *testing.T) {
+	c, err := load(mapLookup(map[string]string{
+		"CORS_ALLOW_ORIGINS":  " https://a.example , ,https://b.example",
+		"RATE_LIMIT_IP_RPS":   "2.5",
+		"NEWS_MAX_ITEMS":      "10",
+		"GET_XBRL_FROM_S3":    "true",
+		"WEBHOOK_TIMEOUT":     "1m30s",
+		"LOGIN_MAX_FAILURES":  "",
+		"SERVER_READ_TIMEOUT": "",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(c.CORSAllowOrigins, want) {
+		t.Errorf("CORSAllowOrigins = %q", c.CORSAllowOrigins)
+	}
+	if c.RateLimitIPRPS != 2.5 || c.NewsMaxItems != 10 || !c.GetXBRLFromS3 || c.WebhookTimeout != 90*time.Second {
+		t.Errorf("config = %+v", c)
+	}
+	// 空の値はデフォルト値にする
+	if c.LoginMaxFailures != 5 || c.ServerReadTimeout != 15*time.Second {
+		t.Errorf("LoginMaxFailures = %d, ServerReadTimeout = %v", c.LoginMaxFailures, c.ServerReadTimeout)
+	}
+
+	// 形式の誤りはまとめて返す
+	_, err = load(mapLookup(map[string]string{
+		"READINESS_TIMEOUT": "5",
+		"NEWS_MAX_ITEMS":    "ten",
+		"RATE_LIMIT_IP_RPS": "fast",
+		"GET_XBRL_FROM_S3":  "maybe",
+	}))
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != 4 {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestValidateAPILambdaTables(t *testing.T) {
+	values := map[string]string{
+		"REGION":           "ap-northeast-1",
+		"BUCKET_NAME":      "bucket",
+		"NEWS_BUCKET_NAME": "news",
+		"SECRET_KEY":       "secret",
+		"SMTP_HOST":        "smtp.example.com",
+		"API_KEY_FILE":     "keys.json",
+		"SERVER_MODE":      "http",
+	}
+	c, err := load(mapLookup(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ValidateAPI(); err != nil {
+		t.Errorf("HTTP サーバー: %v", err)
+	}
+
+	// Lambda ではメモリ上の保存先を使えない
+	values["SERVER_MODE"] = "lambda"
+	c, err = load(mapLookup(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ValidateAPI()
+	for _, name := range []string{"USER_TABLE_NAME", "TOKEN_TABLE_NAME", "LOGIN_ATTEMPT_TABLE_NAME", "WATCHLIST_TABLE_NAME", "SUBSCRIPTION_TABLE_NAME", "ALERT_TABLE_NAME", "WEBHOOK_TABLE_NAME", "RATE_LIMIT_TABLE_NAME"} {
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s を必須にしていません: %v", name, err)
+		}
+	}
+}
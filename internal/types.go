@@ -8,11 +8,15 @@ import (
 )
 
 type User struct {
-	gorm.Model
-	Name     string
-	Email    string `gorm:"unique"`
-	Password []byte
-	Admin    bool
+	ID        string    `json:"id" dynamodbav:"id"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" dynamodbav:"updatedAt"`
+	Name      string    `json:"name" dynamodbav:"name"`
+	Email     string    `json:"email" dynamodbav:"email"`
+	Password  []byte    `json:"-" dynamodbav:"password"`    // bcrypt ハッシュ
+	Admin     bool      `json:"admin" dynamodbav:"admin"`   // role 導入前のユーザー用
+	Role      string    `json:"role" dynamodbav:"role"`     // viewer / analyst / admin
+	Status    string    `json:"status" dynamodbav:"status"` // pending: メールアドレス未確認 (空の場合は有効)
 }
 
 type Company struct {
@@ -74,6 +78,10 @@ type Error struct {
 	Message string
 }
 
+func (e *Error) Error() string {
+	return e.Message
+}
+
 type Ok struct {
 	Status  int
 	Message string
@@ -91,8 +99,57 @@ type RegisterUserBody struct {
 }
 
 type Login struct {
-	Username string
+	Username     string
+	Token        string
+	RefreshToken string
+	ExpiresIn    int64 // アクセストークンの有効期間 (秒)
+}
+
+type VerifyEmailBody struct {
+	Token string
+}
+
+type ForgotPasswordBody struct {
+	Email string
+}
+
+type ResetPasswordBody struct {
 	Token    string
+	Password string
+}
+
+type UpdateUserRoleBody struct {
+	Role string
+}
+
+type UnlockUserBody struct {
+	Email string
+	// 指定した場合は IP のロックも解除する
+	IP string `json:"ip"`
+}
+
+// ログイン失敗の記録 (アカウント・IP ごと)
+type LoginAttempt struct {
+	ID           string    `dynamodbav:"id"`
+	Failures     int       `dynamodbav:"failures"`
+	LastFailedAt time.Time `dynamodbav:"lastFailedAt"`
+	LockedUntil  time.Time `dynamodbav:"lockedUntil"`
+}
+
+type RefreshTokenBody struct {
+	RefreshToken string
+}
+
+// リフレッシュトークン (トークン本体は保存せず SHA-256 ハッシュを ID とする)
+type RefreshToken struct {
+	ID        string    `dynamodbav:"id"`
+	FamilyID  string    `dynamodbav:"familyId"` // ローテーションで発行されたトークンは同じファミリーに属する
+	UserID    string    `dynamodbav:"userId"`
+	Username  string    `dynamodbav:"username"`
+	Role      string    `dynamodbav:"role"`
+	Used      bool      `dynamodbav:"used"` // ローテーション済み
+	ExpiresAt time.Time `dynamodbav:"expiresAt"`
+	CreatedAt time.Time `dynamodbav:"createdAt"`
 }
 
 type ReportData struct {
@@ -100,6 +157,26 @@ type ReportData struct {
 	Data     string `json:"data"`
 }
 
+// 提出書類の原本の保存場所 (EDINET_BUCKET_NAME の filings/{docID}.json、書類バッチで登録する)
+type Filing struct {
+	DocID   string `json:"doc_id"`
+	DateKey string `json:"date_key"`
+	// {dateKey}/{docID}/{XBRL ファイル名}
+	XBRLKey string `json:"xbrl_key"`
+	// {dateKey}/{docID}/{docID}.pdf (PDF がない書類は空)
+	PDFKey string `json:"pdf_key"`
+}
+
+// 書類のリンク (/reports?mode=url)
+type ReportLink struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	// S3 の署名付き URL (HTML・ローカルの保存先では /reports/{key}/raw)
+	URL string `json:"url"`
+	// 署名付き URL の有効期限 (/reports/{key}/raw の場合は null)
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
 // <link:schemaRef> 要素
 type SchemaRef struct {
 	Href string `xml:"xlink:href,attr"`
@@ -301,9 +378,131 @@ type InvalidSummary struct {
 }
 
 type NewsData struct {
-	Title   string `json:"title"`
-	Summary string `json:"summary"`
-	Link    string `json:"link"`
+	Title      string        `json:"title"`
+	Summary    string        `json:"summary"`
+	Link       string        `json:"link"`
+	Companies  []NewsCompany `json:"companies,omitempty"`  // 記事で言及されている企業 (ニュースバッチで設定)
+	Categories []string      `json:"categories,omitempty"` // earnings / m_and_a / guidance / dividends など (ニュースバッチで設定)
+}
+
+// ニュース記事に紐づく企業
+type NewsCompany struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	EDINETCode string `json:"edinetCode"`
+}
+
+// 企業ごとのニュース (記事と掲載された版)
+type CompanyNews struct {
+	NewsData
+	Date string `json:"date"`
+	AmPm string `json:"am_pm"`
+}
+
+// 企業の書類がある期間
+type ReportPeriod struct {
+	PeriodStart string   `json:"period_start"`
+	PeriodEnd   string   `json:"period_end"`
+	Reports     []string `json:"reports"` // BS / PL / CF
+}
+
+/*
+企業の概要 (/companies/{id}/overview)
+
+取得に失敗した (時間内に取得できなかった) 項目は Missing に名前を入れ、値は null にする
+*/
+type CompanyOverview struct {
+	Company      Company        `json:"company"`
+	BS           *Summary       `json:"bs"`
+	PL           *PLSummary     `json:"pl"`
+	CF           *CFSummary     `json:"cf"`
+	Fundamentals []Fundamental  `json:"fundamentals"`
+	Periods      []ReportPeriod `json:"periods"`
+	News         []CompanyNews  `json:"news"`
+	Missing      []string       `json:"missing"`
+}
+
+type Watchlist struct {
+	UserID      string    `json:"-" dynamodbav:"userId"`
+	ID          string    `json:"id" dynamodbav:"id"`
+	Name        string    `json:"name" dynamodbav:"name"`
+	EDINETCodes []string  `json:"edinetCodes" dynamodbav:"edinetCodes"`
+	CreatedAt   time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt" dynamodbav:"updatedAt"`
+}
+
+type WatchlistBody struct {
+	Name        *string
+	EDINETCodes []string
+}
+
+// ウォッチリストの企業ごとの概要
+type WatchlistCompany struct {
+	EDINETCode        string       `json:"edinetCode"`
+	Name              string       `json:"name"`
+	BS                bool         `json:"bs"` // B/S データの有無
+	PL                bool         `json:"pl"` // P/L データの有無
+	LatestFundamental *Fundamental `json:"latestFundamental"`
+}
+
+type WatchlistSummary struct {
+	ID        string             `json:"id"`
+	Name      string             `json:"name"`
+	Companies []WatchlistCompany `json:"companies"`
+}
+
+// 新しい書類の通知
+type Alert struct {
+	UserID         string    `json:"-" dynamodbav:"userId"`
+	ID             string    `json:"id" dynamodbav:"id"` // docID (同じ書類の通知は 1 件のみ)
+	EDINETCode     string    `json:"edinetCode" dynamodbav:"edinetCode"`
+	DocID          string    `json:"docId" dynamodbav:"docId"`
+	DocTypeCode    string    `json:"docTypeCode" dynamodbav:"docTypeCode"`
+	CompanyName    string    `json:"companyName" dynamodbav:"companyName"`
+	DocDescription string    `json:"docDescription" dynamodbav:"docDescription"`
+	SubmitDateTime string    `json:"submitDateTime" dynamodbav:"submitDateTime"`
+	Read           bool      `json:"read" dynamodbav:"read"`
+	CreatedAt      time.Time `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+type MarkAlertsReadBody struct {
+	IDs []string // 空の場合は全て既読にする
+}
+
+type Webhook struct {
+	UserID    string    `json:"-" dynamodbav:"userId"`
+	ID        string    `json:"id" dynamodbav:"id"`
+	URL       string    `json:"url" dynamodbav:"url"`
+	Events    []string  `json:"events" dynamodbav:"events"`
+	Secret    string    `json:"secret,omitempty" dynamodbav:"secret"` // 署名用 (作成時のみ返す)
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+type WebhookBody struct {
+	URL    *string
+	Events []string
+}
+
+// Webhook で送信するイベント
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// Webhook の送信履歴
+type WebhookDelivery struct {
+	WebhookID      string    `json:"webhookId" dynamodbav:"webhookId"`
+	ID             string    `json:"id" dynamodbav:"id"`
+	EventID        string    `json:"eventId" dynamodbav:"eventId"`
+	Event          string    `json:"event" dynamodbav:"event"`
+	Status         string    `json:"status" dynamodbav:"status"` // succeeded / failed / retrying (再送待ち)
+	Attempts       int       `json:"attempts" dynamodbav:"attempts"`
+	ResponseStatus int       `json:"responseStatus" dynamodbav:"responseStatus"`
+	Error          string    `json:"error,omitempty" dynamodbav:"error"`
+	CreatedAt      time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	CompletedAt    time.Time `json:"completedAt" dynamodbav:"completedAt"`
 }
 
 type NewsResult struct {
@@ -311,3 +510,19 @@ type NewsResult struct {
 	DateStr  string     `json:"date_str"`
 	AmPm     string     `json:"am_pm"` // "am" か "pm" を設定
 }
+
+// API キー (キー本体は保存せず SHA-256 ハッシュを ID とする)
+type APIKey struct {
+	ID        string    `json:"id" dynamodbav:"id"`
+	Name      string    `json:"name" dynamodbav:"name"` // 発行先 (パートナー名など)
+	Plan      string    `json:"plan" dynamodbav:"plan"` // 利用プラン名
+	Disabled  bool      `json:"disabled" dynamodbav:"disabled"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// API キーに紐づく利用プラン
+type UsagePlan struct {
+	Name              string  `json:"name"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
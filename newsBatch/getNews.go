@@ -0,0 +1,198 @@
+//go:build ignore
+// +build ignore
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/joe-black-jb/compass-api/internal"
+	"github.com/joe-black-jb/compass-api/internal/api"
+	"github.com/joe-black-jb/compass-api/internal/config"
+)
+
+/*
+ニュースバッチ
+
+NEWS_FEEDS (カンマ区切り) または NEWS_FEEDS_FILE (1 行に 1 件) で指定した RSS / Atom フィードを読み込み、
+リンクで重複を除いた NewsResult を現在の版 (日付と午前・午後) としてニュースの保存先に書き込む
+
+フィードには URL のほかローカルのファイルパスも指定できる (newsBatch/testdata にオフライン確認用のフィードがある)
+
+	NEWS_LOCAL_DIR=tmp/news NEWS_FEEDS_FILE=newsBatch/testdata/feeds.txt make news
+*/
+
+var s3Client *s3.Client
+var conf *config.Config
+var feeds []string
+var maxItems = 50
+
+func init() {
+	var err error
+	conf, err = config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = conf.ValidateNewsBatch()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = api.Init(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("環境", "env", conf.Env)
+
+	sdkConfig, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithRegion(conf.Region))
+	if err != nil {
+		slog.Error("load default config failed", "error", err)
+		return
+	}
+	s3Client = s3.NewFromConfig(sdkConfig)
+
+	feeds, err = loadFeeds()
+	if err != nil {
+		log.Fatal("フィード一覧の読み込みエラー: ", err)
+	}
+	maxItems = conf.NewsMaxItems
+}
+
+func main() {
+	start := time.Now()
+	ctx := context.Background()
+
+	if len(feeds) == 0 {
+		log.Fatal("フィードが設定されていません (NEWS_FEEDS または NEWS_FEEDS_FILE)")
+	}
+
+	var entries []api.NewsFeedEntry
+	for _, feed := range feeds {
+		feedEntries, err := fetchFeed(ctx, feed)
+		if err != nil {
+			// 1 件のフィードの失敗で全体を止めない
+			slog.Error("フィードの取得エラー", "feed", feed, "error", err)
+			continue
+		}
+		slog.Info("フィードを取得しました", "feed", feed, "count", len(feedEntries))
+		entries = append(entries, feedEntries...)
+	}
+
+	newsList := api.BuildNewsList(entries, maxItems)
+	if len(newsList) == 0 {
+		log.Fatal("ニュースを取得できませんでした")
+	}
+
+	// キーワードでカテゴリを設定する
+	api.CategorizeNews(newsList)
+
+	// 記事で言及されている企業を紐づける (企業一覧の取得に失敗した場合は紐づけずに保存する)
+	companies, err := api.GetCompaniesProcessor("")
+	if err != nil {
+		slog.Error("企業一覧の取得エラー", "error", err)
+	} else {
+		api.LinkNewsCompanies(newsList, companies)
+	}
+
+	edition := api.CurrentNewsEdition(time.Now())
+	if date := conf.NewsDate; date != "" {
+		edition.Date = date
+	}
+	if ampm := conf.NewsAmPm; ampm != "" {
+		edition.AmPm = ampm
+	}
+	result := internal.NewsResult{
+		NewsList: newsList,
+		DateStr:  edition.Date,
+		AmPm:     edition.AmPm,
+	}
+
+	store := api.NewNewsObjectStore(s3Client)
+	err = api.PutNewsEdition(ctx, store, result)
+	if err != nil {
+		log.Fatal("ニュースの保存エラー: ", err)
+	}
+	slog.Info("ニュースを登録しました", "date", edition.Date, "ampm", edition.AmPm, "count", len(newsList))
+
+	err = api.PublishEvent(ctx, api.EventNewsPublished, map[string]interface{}{
+		"date":  edition.Date,
+		"ampm":  edition.AmPm,
+		"count": len(newsList),
+	})
+	if err != nil {
+		slog.Error("Webhook 送信エラー", "date", edition.Date, "ampm", edition.AmPm, "error", err)
+	}
+	// Webhook の再送を待つ (WEBHOOK_RETRY_TIMEOUT を過ぎたものは失敗として記録する)
+	err = api.WaitWebhookRetries(ctx)
+	if err != nil {
+		slog.Error("Webhook の再送を打ち切りました", "error", err)
+	}
+
+	slog.Info("All processes done", "elapsed", time.Since(start).String())
+}
+
+// 設定からフィード一覧を読み込む
+func loadFeeds() ([]string, error) {
+	list := append([]string{}, conf.NewsFeeds...)
+	path := conf.NewsFeedsFile
+	if path == "" {
+		return list, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list = append(list, line)
+	}
+	return list, scanner.Err()
+}
+
+// フィードを取得する (URL またはファイルパス)
+func fetchFeed(ctx context.Context, feed string) ([]api.NewsFeedEntry, error) {
+	var body []byte
+	if strings.HasPrefix(feed, "http://") || strings.HasPrefix(feed, "https://") {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "compass-news-batch/1.0")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		}
+		body, err = io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		body, err = os.ReadFile(strings.TrimPrefix(feed, "file://"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return api.ParseNewsFeed(body)
+}
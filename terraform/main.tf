@@ -44,6 +44,15 @@ resource "aws_dynamodb_table" "compass-dynamodb-table" {
     non_key_attributes = ["id"]
   }
 
+  # ウォッチリストの概要で EDINET コードから企業を取得する
+  global_secondary_index {
+    name            = "EDINETCodeIndex"
+    hash_key        = "edinetCode"
+    write_capacity  = 10
+    read_capacity   = 10
+    projection_type = "ALL"
+  }
+
 
   tags = {
     Name        = "Name"